@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/Merith-TK/ftp-aio/internal/audit"
 	"github.com/Merith-TK/ftp-aio/internal/auth"
 	"github.com/Merith-TK/ftp-aio/internal/config"
 	"github.com/Merith-TK/ftp-aio/internal/fs"
@@ -25,6 +27,7 @@ var (
 	logLevel   string
 	certFile   string
 	keyFile    string
+	runAs      string
 
 	// Protocol flags
 	enableFTP   bool
@@ -39,6 +42,10 @@ var (
 	httpsPort   int
 	enableTFTP  bool
 	tftpPort    int
+
+	// User helper flags
+	helperRoot     string
+	helperSymlinks string
 )
 
 var rootCmd = &cobra.Command{
@@ -54,6 +61,18 @@ Examples:
 	RunE: runServer,
 }
 
+// helperCmd is started by the daemon as a per-user helper process when
+// security.user_helpers is enabled
+var helperCmd = &cobra.Command{
+	Use:    fs.HelperCommand,
+	Short:  "Serve file operations for one user (internal)",
+	Hidden: true,
+	Args:   cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return fs.ServeHelper(helperRoot, helperSymlinks, os.Stdin, os.Stdout)
+	},
+}
+
 func init() {
 	// General flags
 	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Configuration file path")
@@ -61,6 +80,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
 	rootCmd.PersistentFlags().StringVar(&certFile, "cert", "", "SSL certificate file")
 	rootCmd.PersistentFlags().StringVar(&keyFile, "key", "", "SSL key file")
+	rootCmd.PersistentFlags().StringVar(&runAs, "run-as", "", "User to run as once the ports are bound, as 'user' or 'user:group'")
 
 	// Protocol flags
 	rootCmd.PersistentFlags().BoolVar(&enableFTP, "ftp", false, "Enable FTP server")
@@ -75,63 +95,159 @@ func init() {
 	rootCmd.PersistentFlags().IntVar(&httpsPort, "https-port", 0, "HTTPS port (default: 443)")
 	rootCmd.PersistentFlags().BoolVar(&enableTFTP, "tftp", false, "Enable TFTP server")
 	rootCmd.PersistentFlags().IntVar(&tftpPort, "tftp-port", 0, "TFTP port (default: 69)")
+
+	// User helper
+	helperCmd.Flags().StringVar(&helperRoot, "root", "", "Directory to serve")
+	helperCmd.Flags().StringVar(&helperSymlinks, "symlinks", config.SymlinksWithinRoot, "Symlink policy")
+	rootCmd.AddCommand(helperCmd)
 }
 
 func runServer(cmd *cobra.Command, args []string) error {
 	var err error
 
+	// Take the sockets passed by systemd socket activation, before any
+	// helper process could inherit them
+	sockets := server.NewSockets(utils.ListenFDs())
+
 	// Get data directory from args or flag
 	if len(args) > 0 {
 		dataDir = args[0]
 	}
 
-	// Load configuration
-	cfg, err = loadConfiguration()
+	// Load, override and validate the configuration
+	cfg, err = buildConfiguration()
 	if err != nil {
-		return fmt.Errorf("failed to load configuration: %w", err)
-	}
-
-	// Override with CLI flags
-	if err := applyCLIFlags(cfg); err != nil {
-		return fmt.Errorf("failed to apply CLI flags: %w", err)
-	}
-
-	// Validate configuration
-	if err := cfg.Validate(); err != nil {
-		return fmt.Errorf("configuration validation failed: %w", err)
+		return err
 	}
 
 	// Create logger
-	logger := utils.NewLogger(cfg.Logging.Level, cfg.Logging.Format)
+	logger, err := newLogger(cfg.Logging)
+	if err != nil {
+		return err
+	}
+	defer logger.Close()
 	logger.Info("Starting FTP-AIO server...")
 	logger.Info("Data directory: %s", cfg.Data)
 	logger.Info("Users configured: %d", len(cfg.Users))
 
+	// Create brute-force guard shared by all protocol servers
+	var guard *auth.Guard
+	if cfg.Security.BruteForce.Enabled {
+		guard, err = auth.NewGuard(cfg.Security.BruteForce, cfg.State, logger.Subsystem("auth"))
+		if err != nil {
+			return fmt.Errorf("failed to create login guard: %w", err)
+		}
+	}
+
 	// Create authenticator
-	authenticator := auth.NewAuthenticator(cfg.Users)
+	authenticator, err := auth.NewAuthenticator(cfg, guard)
+	if err != nil {
+		return fmt.Errorf("failed to create authenticator: %w", err)
+	}
 
 	// Create file system
-	fileSystem := fs.NewFileSystem(cfg.Data, authenticator)
+	fileSystem, err := fs.NewFileSystem(cfg, authenticator)
+	if err != nil {
+		return fmt.Errorf("failed to create file system: %w", err)
+	}
+
+	// Open the transfer and audit logs
+	recorder, err := audit.NewRecorder(cfg)
+	if err != nil {
+		return err
+	}
+	defer recorder.Close()
 
 	// Create server manager
-	manager := server.NewManager(cfg, logger, authenticator, fileSystem)
+	manager := server.NewManager(cfg, logger, authenticator, fileSystem, recorder, sockets)
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	// Start servers
 	if err := manager.Start(ctx); err != nil {
 		return fmt.Errorf("failed to start servers: %w", err)
 	}
 
+	// Keep the systemd watchdog, if enabled, informed of the health
+	go manager.RunWatchdog(ctx)
+
+	// Purge expired trash in the background
+	go fileSystem.RunTrashPurge(ctx, logger.Subsystem("fs"))
+
+	// Reload the configuration on SIGHUP, and when the file changes if
+	// it is watched. A configuration that fails to validate is not applied.
+	reload := func() {
+		newCfg, err := buildConfiguration()
+		if err != nil {
+			logger.Error("Keeping the current configuration: %v", err)
+			return
+		}
+		if err := manager.Reload(newCfg); err != nil {
+			logger.Error("Failed to reload configuration: %v", err)
+		}
+	}
+	go utils.HandleReload(ctx, logger, reload)
+	if cfg.Reload.Watch {
+		if configFile == "" {
+			logger.Warn("Not watching the configuration, no file was given")
+		} else {
+			go utils.WatchFile(ctx, configFile, cfg.Reload.Interval, func() {
+				logger.Info("Configuration file %s changed, reloading...", configFile)
+				reload()
+			})
+		}
+	}
+
 	// Setup graceful shutdown
-	utils.GracefulShutdown(ctx, cancel, logger, func() error {
-		return manager.Stop()
-	})
+	utils.GracefulShutdown(ctx, cancel, logger, cfg.Shutdown.DrainTimeout, manager.Shutdown)
 
 	return nil
 }
 
+// newLogger creates the logger with the configured outputs
+func newLogger(cfg config.LoggingConfig) (*utils.Logger, error) {
+	outputs := make([]utils.LogOutput, 0, len(cfg.Outputs))
+	for _, o := range cfg.Outputs {
+		outputs = append(outputs, utils.LogOutput{
+			Type:       o.Type,
+			Level:      o.Level,
+			Format:     o.Format,
+			Path:       o.Path,
+			MaxSize:    o.MaxSize,
+			MaxAge:     o.MaxAge,
+			MaxBackups: o.MaxBackups,
+			Network:    o.Network,
+			Address:    o.Address,
+			Facility:   o.Facility,
+			Tag:        o.Tag,
+		})
+	}
+	return utils.NewLogger(cfg.Level, cfg.Subsystems, outputs)
+}
+
+// buildConfiguration loads the configuration, applies the CLI flags and
+// validates the result
+func buildConfiguration() (*config.Config, error) {
+	cfg, err := loadConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Override with CLI flags
+	if err := applyCLIFlags(cfg); err != nil {
+		return nil, fmt.Errorf("failed to apply CLI flags: %w", err)
+	}
+
+	// Validate configuration
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return cfg, nil
+}
+
 func loadConfiguration() (*config.Config, error) {
 	// Load from file first
 	cfg, err := config.LoadFromFile(configFile)
@@ -166,6 +282,11 @@ func applyCLIFlags(cfg *config.Config) error {
 		cfg.Logging.Level = logLevel
 	}
 
+	// Privileges
+	if runAs != "" {
+		cfg.RunAs.User, cfg.RunAs.Group, _ = strings.Cut(runAs, ":")
+	}
+
 	// SSL certificates
 	if certFile != "" {
 		cfg.Services.FTPS.Cert = certFile
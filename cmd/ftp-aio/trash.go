@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Merith-TK/ftp-aio/internal/fs"
+)
+
+var (
+	// Trash flags
+	trashUser string
+	trashTo   string
+	trashAll  bool
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Inspect and restore deleted files",
+}
+
+var trashListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List files in the trash",
+	Args:  cobra.NoArgs,
+	// Errors are reported by main
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileSystem, err := openFileSystem()
+		if err != nil {
+			return err
+		}
+
+		entries, err := fileSystem.ListTrash(trashUser)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tDELETED\tSIZE\tPATH")
+		for _, entry := range entries {
+			path := entry.Path
+			if entry.Dir {
+				path += "/"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", entry.ID, entry.User, entry.Deleted.Format(time.DateTime), entry.Size, path)
+		}
+		return w.Flush()
+	},
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Restore a file from the trash",
+	Args:  cobra.ExactArgs(1),
+	// Errors are reported by main
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileSystem, err := openFileSystem()
+		if err != nil {
+			return err
+		}
+
+		entry, err := fileSystem.RestoreTrash(args[0], trashTo)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s for %s\n", entry.Path, entry.User)
+		return nil
+	},
+}
+
+var trashPurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Permanently delete expired files from the trash",
+	Args:  cobra.NoArgs,
+	// Errors are reported by main
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fileSystem, err := openFileSystem()
+		if err != nil {
+			return err
+		}
+
+		cutoff := time.Now().Add(-cfg.Trash.Retention)
+		if trashAll {
+			cutoff = time.Now()
+		}
+		purged, err := fileSystem.PurgeTrash(cutoff)
+		fmt.Printf("Purged %d entries\n", purged)
+		return err
+	},
+}
+
+func init() {
+	trashListCmd.Flags().StringVar(&trashUser, "user", "", "Only list files deleted by this user")
+	trashRestoreCmd.Flags().StringVar(&trashTo, "to", "", "Restore to this path instead of the original one")
+	trashPurgeCmd.Flags().BoolVar(&trashAll, "all", false, "Purge everything, not only expired files")
+
+	trashCmd.AddCommand(trashListCmd, trashRestoreCmd, trashPurgeCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+// openFileSystem loads and validates the configuration and opens the file
+// system for an admin command
+func openFileSystem() (*fs.FileSystem, error) {
+	var err error
+	cfg, err = loadConfiguration()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if err := applyCLIFlags(cfg); err != nil {
+		return nil, fmt.Errorf("failed to apply CLI flags: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("configuration validation failed: %w", err)
+	}
+
+	return fs.NewFileSystem(cfg, nil)
+}
@@ -1,6 +1,6 @@
 module github.com/Merith-TK/ftp-aio
 
-go 1.24.4
+go 1.25.0
 
 require (
 	github.com/spf13/cobra v1.9.1
@@ -0,0 +1,201 @@
+// Package audit records transfers and security relevant actions in log
+// files of their own, separate from the diagnostic log, so they can be
+// kept and searched for compliance.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// Directions of a transfer
+const (
+	Download = "out"
+	Upload   = "in"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionLogin       = "login"
+	ActionLoginFailed = "login_failed"
+	ActionLogout      = "logout"
+	ActionDelete      = "delete"
+	ActionRename      = "rename"
+	ActionCopy        = "copy"
+	ActionMkdir       = "mkdir"
+	ActionRmdir       = "rmdir"
+	ActionChmod       = "chmod"
+	ActionDenied      = "permission_denied"
+)
+
+// Transfer is a finished or failed transfer of a file
+type Transfer struct {
+	Time      time.Time     `json:"time"` // when the transfer ended
+	Duration  time.Duration `json:"-"`
+	Seconds   float64       `json:"duration"`
+	Service   string        `json:"service"`
+	Session   string        `json:"session,omitempty"`
+	User      string        `json:"user"`
+	Anonymous bool          `json:"anonymous,omitempty"`
+	Remote    string        `json:"remote"` // client IP address
+	Path      string        `json:"path"`
+	Direction string        `json:"direction"` // Upload or Download
+	Bytes     int64         `json:"bytes"`
+	Complete  bool          `json:"complete"`
+}
+
+// Event is an entry of the audit log
+type Event struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Session string    `json:"session,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Remote  string    `json:"remote,omitempty"`
+	Action  string    `json:"action"`
+	Path    string    `json:"path,omitempty"`
+	Target  string    `json:"target,omitempty"` // destination of renames and copies
+	Success bool      `json:"success"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Recorder writes the transfer and audit logs. A nil Recorder, or one with
+// both logs disabled, records nothing.
+type Recorder struct {
+	transfers      *utils.RotatingFile
+	transferFormat string
+	events         *utils.RotatingFile
+}
+
+// NewRecorder opens the configured transfer and audit logs
+func NewRecorder(cfg *config.Config) (*Recorder, error) {
+	r := &Recorder{transferFormat: cfg.TransferLog.Format}
+
+	if cfg.TransferLog.Enabled {
+		file, err := openLogFile(cfg.TransferLog.LogFileConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open transfer log: %w", err)
+		}
+		r.transfers = file
+	}
+
+	if cfg.AuditLog.Enabled {
+		file, err := openLogFile(cfg.AuditLog)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to open audit log: %w", err)
+		}
+		r.events = file
+	}
+
+	return r, nil
+}
+
+// openLogFile opens a configured log file
+func openLogFile(cfg config.LogFileConfig) (*utils.RotatingFile, error) {
+	return utils.OpenRotatingFile(cfg.Path, cfg.MaxSize, cfg.MaxAge, cfg.MaxBackups)
+}
+
+// Transfer records a transfer
+func (r *Recorder) Transfer(t Transfer) {
+	if r == nil || r.transfers == nil {
+		return
+	}
+	if t.Time.IsZero() {
+		t.Time = time.Now()
+	}
+
+	var line []byte
+	if r.transferFormat == config.TransferLogJSON {
+		t.Seconds = t.Duration.Seconds()
+		data, err := json.Marshal(t)
+		if err != nil {
+			return
+		}
+		line = append(data, '\n')
+	} else {
+		line = []byte(xferlogLine(t))
+	}
+	r.transfers.Write(line)
+}
+
+// Event records an entry of the audit log
+func (r *Recorder) Event(e Event) {
+	if r == nil || r.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.events.Write(append(data, '\n'))
+}
+
+// Close closes the log files
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	var err error
+	if r.transfers != nil {
+		err = r.transfers.Close()
+	}
+	if r.events != nil {
+		if closeErr := r.events.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// xferlogLine formats a transfer like wu-ftpd's xferlog:
+//
+//	current-time transfer-time remote-host file-size filename transfer-type
+//	special-action-flag direction access-mode username service-name
+//	authentication-method authenticated-user-id completion-status
+//
+// Whitespace in file and user names is replaced by underscores to keep the
+// fields apart.
+func xferlogLine(t Transfer) string {
+	seconds := int64(t.Duration.Round(time.Second) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	direction := "o"
+	if t.Direction == Upload {
+		direction = "i"
+	}
+	access := "r"
+	if t.Anonymous {
+		access = "a"
+	}
+	status := "i"
+	if t.Complete {
+		status = "c"
+	}
+
+	return fmt.Sprintf("%s %d %s %d %s b _ %s %s %s %s 0 * %s\n",
+		t.Time.Format(time.ANSIC), seconds, t.Remote, t.Bytes, xferlogField(t.Path),
+		direction, access, xferlogField(t.User), t.Service, status)
+}
+
+// xferlogField replaces whitespace so a value stays one field
+func xferlogField(value string) string {
+	if value == "" {
+		return "*"
+	}
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\n' || r == '\r' {
+			return '_'
+		}
+		return r
+	}, value)
+}
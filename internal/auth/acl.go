@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// ErrAccessDenied is returned when a client network is not permitted
+var ErrAccessDenied = errors.New("access denied")
+
+// accessRule is a single parsed allow or deny entry
+type accessRule struct {
+	text string
+	net  *net.IPNet
+}
+
+// AccessList holds the allow and deny rules of one scope, such as the
+// global settings, a service or a user
+type AccessList struct {
+	scope string
+	allow []accessRule
+	deny  []accessRule
+}
+
+// NewAccessList parses the access rules of a scope
+func NewAccessList(scope string, cfg config.AccessConfig) (*AccessList, error) {
+	allow, err := parseRules(cfg.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("%s allow list: %w", scope, err)
+	}
+	deny, err := parseRules(cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("%s deny list: %w", scope, err)
+	}
+
+	return &AccessList{scope: scope, allow: allow, deny: deny}, nil
+}
+
+// Check returns an error naming the matching rule if ip is not permitted
+func (l *AccessList) Check(ip net.IP) error {
+	if l == nil {
+		return nil
+	}
+
+	for _, rule := range l.deny {
+		if ip != nil && rule.net.Contains(ip) {
+			return fmt.Errorf("%w: %s matches %s deny rule %s", ErrAccessDenied, ip, l.scope, rule.text)
+		}
+	}
+
+	if len(l.allow) == 0 {
+		return nil
+	}
+	for _, rule := range l.allow {
+		if ip != nil && rule.net.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not in the %s allow list", ErrAccessDenied, ip, l.scope)
+}
+
+// CheckAccess checks ip against each access list in order
+func CheckAccess(ip net.IP, lists ...*AccessList) error {
+	for _, l := range lists {
+		if err := l.Check(ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseRules parses CIDR entries while keeping their original text
+func parseRules(entries []string) ([]accessRule, error) {
+	nets, err := config.ParseCIDRs(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]accessRule, len(nets))
+	for i, n := range nets {
+		rules[i] = accessRule{text: entries[i], net: n}
+	}
+	return rules, nil
+}
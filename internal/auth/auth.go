@@ -1,48 +1,222 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/Merith-TK/ftp-aio/internal/config"
 )
 
+// ErrUserDisabled is returned when a disabled user tries to log in
+var ErrUserDisabled = errors.New("account is disabled")
+
 // Authenticator handles user authentication
 type Authenticator struct {
-	users map[string]*config.User
+	guard *Guard
+
+	// The users start out as the configured ones and change through the
+	// admin API and configuration reloads while running
+	mutex     sync.RWMutex
+	users     map[string]*config.User
+	anonymous *config.User
+	access    *AccessList
 }
 
-// NewAuthenticator creates a new authenticator with the given users
-func NewAuthenticator(users map[string]*config.User) *Authenticator {
-	return &Authenticator{
-		users: users,
+// NewAuthenticator creates a new authenticator for the configured users.
+// guard may be nil to disable brute-force protection.
+func NewAuthenticator(cfg *config.Config, guard *Guard) (*Authenticator, error) {
+	a := &Authenticator{guard: guard}
+	if err := a.Update(cfg); err != nil {
+		return nil, err
 	}
+	return a, nil
 }
 
-// Authenticate verifies user credentials
-func (a *Authenticator) Authenticate(username, password string) (*config.User, error) {
+// Update replaces the users, the anonymous user and the global access rules
+// with those of a validated configuration in one step. Users added through
+// the admin API are dropped. Sessions keep the user they logged in as.
+func (a *Authenticator) Update(cfg *config.Config) error {
+	access, err := NewAccessList("global", cfg.Access)
+	if err != nil {
+		return err
+	}
+
+	users := make(map[string]*config.User, len(cfg.Users))
+	for name, user := range cfg.Users {
+		users[name] = user
+	}
+
+	a.mutex.Lock()
+	a.users = users
+	a.anonymous = cfg.AnonymousUser()
+	a.access = access
+	a.mutex.Unlock()
+	return nil
+}
+
+// Authenticate verifies user credentials for a client connecting from remoteIP.
+// Failed attempts are delayed progressively and may lock the account or ban
+// the client address.
+func (a *Authenticator) Authenticate(username, password string, remoteIP net.IP) (*config.User, error) {
+	if a.guard != nil {
+		if err := a.guard.Check(remoteIP, username); err != nil {
+			return nil, err
+		}
+	}
+
+	a.mutex.RLock()
 	user, exists := a.users[username]
+	anonymous, access := a.anonymous, a.access
+	a.mutex.RUnlock()
+
+	if !exists && anonymous != nil && config.IsAnonymousName(username) {
+		// Any password is accepted, clients send their email address
+		if err := checkAccess(remoteIP, access, anonymous); err != nil {
+			return nil, err
+		}
+		return anonymous, nil
+	}
 	if !exists {
+		a.fail(remoteIP, username)
 		return nil, fmt.Errorf("user '%s' not found", username)
 	}
 
+	// Global network restrictions don't depend on the account
+	if err := access.Check(remoteIP); err != nil {
+		return nil, err
+	}
+
 	if user.Pass != password {
+		a.fail(remoteIP, username)
 		return nil, fmt.Errorf("invalid password for user '%s'", username)
 	}
 
+	// Checked after the password so the answer doesn't reveal the account
+	if err := CheckUserAccess(remoteIP, user); err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, fmt.Errorf("%w: %s", ErrUserDisabled, username)
+	}
+
+	if a.guard != nil {
+		a.guard.Success(remoteIP, username)
+	}
+
 	return user, nil
 }
 
+// IsBanned returns true if connections from remoteIP must be refused
+func (a *Authenticator) IsBanned(remoteIP net.IP) bool {
+	return a.guard != nil && a.guard.IsBanned(remoteIP)
+}
+
+// Guard returns the brute-force guard, or nil if protection is disabled
+func (a *Authenticator) Guard() *Guard {
+	return a.guard
+}
+
+// checkAccess checks the global and per-user network restrictions
+func checkAccess(remoteIP net.IP, global *AccessList, user *config.User) error {
+	if err := global.Check(remoteIP); err != nil {
+		return err
+	}
+	return CheckUserAccess(remoteIP, user)
+}
+
+// CheckUserAccess checks remoteIP against the user's own network
+// restrictions, for services that act as a user without a login
+func CheckUserAccess(remoteIP net.IP, user *config.User) error {
+	userAccess, err := NewAccessList("user "+user.Name, user.Access)
+	if err != nil {
+		return err
+	}
+	return userAccess.Check(remoteIP)
+}
+
+// fail records a failed login and delays the caller accordingly
+func (a *Authenticator) fail(remoteIP net.IP, username string) {
+	if a.guard == nil {
+		return
+	}
+	if delay := a.guard.Failure(remoteIP, username); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Anonymous returns the user anonymous logins run as, or nil if anonymous
+// access is disabled
+func (a *Authenticator) Anonymous() *config.User {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.anonymous
+}
+
 // GetUser returns a user by username without authentication
 func (a *Authenticator) GetUser(username string) (*config.User, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	user, exists := a.users[username]
 	return user, exists
 }
 
-// ListUsers returns all usernames
+// ListUsers returns all usernames in alphabetical order
 func (a *Authenticator) ListUsers() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
 	users := make([]string, 0, len(a.users))
 	for username := range a.users {
 		users = append(users, username)
 	}
+	sort.Strings(users)
 	return users
 }
+
+// AddUser adds a user that has been through config.ValidateUser. It fails
+// if the name is taken.
+func (a *Authenticator) AddUser(user *config.User) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.users[user.Name]; exists {
+		return fmt.Errorf("user '%s' already exists", user.Name)
+	}
+	a.users[user.Name] = user
+	return nil
+}
+
+// RemoveUser removes a user, returning false if there is none by that name.
+// Sessions of the user are not affected.
+func (a *Authenticator) RemoveUser(username string) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.users[username]; !exists {
+		return false
+	}
+	delete(a.users, username)
+	return true
+}
+
+// SetDisabled disables or enables logins of a user, returning false if
+// there is no user by that name
+func (a *Authenticator) SetDisabled(username string, disabled bool) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	user, exists := a.users[username]
+	if !exists {
+		return false
+	}
+	// Replaced rather than changed, sessions hold on to the old one
+	updated := *user
+	updated.Disabled = disabled
+	a.users[username] = &updated
+	return true
+}
@@ -0,0 +1,350 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+var (
+	// ErrBanned is returned when the client IP is banned
+	ErrBanned = errors.New("client address is banned")
+	// ErrLockedOut is returned when the account is temporarily locked
+	ErrLockedOut = errors.New("account is temporarily locked")
+)
+
+// bansFile is the name of the ban list inside the state directory
+const bansFile = "bans.json"
+
+// Ban records a banned IP address
+type Ban struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Offences int       `json:"offences"` // number of bans so far, drives escalation
+	Reason   string    `json:"reason"`
+}
+
+// Active returns true if the ban has not expired yet
+func (b *Ban) Active(now time.Time) bool {
+	return now.Before(b.Until)
+}
+
+// Guard tracks failed logins across all protocol servers and applies
+// progressive delays, username lockouts and escalating IP bans
+type Guard struct {
+	config config.BruteForceConfig
+	logger *utils.Logger
+	path   string
+	exempt []*net.IPNet
+
+	mutex     sync.Mutex
+	ipFails   map[string][]time.Time
+	usrFails  map[string][]time.Time
+	lockouts  map[string]time.Time
+	bans      map[string]*Ban
+	lastSweep time.Time // when stale entries were last dropped
+}
+
+// NewGuard creates a guard and loads persisted bans from the state directory
+func NewGuard(cfg config.BruteForceConfig, stateDir string, logger *utils.Logger) (*Guard, error) {
+	exempt, err := config.ParseCIDRs(cfg.Exempt)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Guard{
+		config:   cfg,
+		logger:   logger,
+		path:     filepath.Join(stateDir, bansFile),
+		exempt:   exempt,
+		ipFails:  make(map[string][]time.Time),
+		usrFails: make(map[string][]time.Time),
+		lockouts: make(map[string]time.Time),
+		bans:     make(map[string]*Ban),
+	}
+
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return g, nil
+		}
+		return nil, fmt.Errorf("failed to read ban list: %w", err)
+	}
+
+	var bans []*Ban
+	if err := json.Unmarshal(data, &bans); err != nil {
+		return nil, fmt.Errorf("failed to parse ban list: %w", err)
+	}
+	now := time.Now()
+	for _, ban := range bans {
+		g.bans[ban.IP] = ban
+		if ban.Active(now) {
+			logger.Info("Restored ban for %s until %s", ban.IP, ban.Until.Format(time.RFC3339))
+		}
+	}
+
+	return g, nil
+}
+
+// IsBanned returns true if connections from ip must be refused
+func (g *Guard) IsBanned(ip net.IP) bool {
+	if !g.config.Enabled || ip == nil || g.isExempt(ip) {
+		return false
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ban, exists := g.bans[ip.String()]
+	return exists && ban.Active(time.Now())
+}
+
+// Check returns an error if a login for username from ip must be refused
+func (g *Guard) Check(ip net.IP, username string) error {
+	if !g.config.Enabled || g.isExempt(ip) {
+		return nil
+	}
+	if g.IsBanned(ip) {
+		return ErrBanned
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if until, exists := g.lockouts[username]; exists {
+		if time.Now().Before(until) {
+			return ErrLockedOut
+		}
+		delete(g.lockouts, username)
+	}
+
+	return nil
+}
+
+// Failure records a failed login and returns how long the response to the
+// client should be delayed
+func (g *Guard) Failure(ip net.IP, username string) time.Duration {
+	if !g.config.Enabled || g.isExempt(ip) {
+		return 0
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	g.sweep(now)
+	ipKey := ""
+	if ip != nil {
+		ipKey = ip.String()
+	}
+
+	ipCount := g.record(g.ipFails, ipKey, now)
+	userCount := g.record(g.usrFails, username, now)
+
+	if g.config.UserMaxAttempts > 0 && userCount >= g.config.UserMaxAttempts {
+		g.lockouts[username] = now.Add(g.config.LockoutDuration)
+		delete(g.usrFails, username)
+		g.logger.Warn("Locked account %s for %s after %d failed logins", username, g.config.LockoutDuration, userCount)
+	}
+
+	if ipKey != "" && g.config.MaxAttempts > 0 && ipCount >= g.config.MaxAttempts {
+		g.ban(ipKey, fmt.Sprintf("%d failed logins within %s", ipCount, g.config.Window), now)
+		delete(g.ipFails, ipKey)
+	}
+
+	return min(time.Duration(ipCount)*g.config.Delay, g.config.MaxDelay)
+}
+
+// Success clears the failure history of an IP and username
+func (g *Guard) Success(ip net.IP, username string) {
+	if !g.config.Enabled {
+		return
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if ip != nil {
+		delete(g.ipFails, ip.String())
+	}
+	delete(g.usrFails, username)
+}
+
+// Ban bans ip for the given duration, escalating if duration is zero
+func (g *Guard) Ban(ip net.IP, duration time.Duration, reason string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	key := ip.String()
+	if duration <= 0 {
+		g.ban(key, reason, now)
+		return
+	}
+
+	ban := g.bans[key]
+	if ban == nil {
+		ban = &Ban{IP: key}
+		g.bans[key] = ban
+	}
+	ban.Offences++
+	ban.Until = now.Add(duration)
+	ban.Reason = reason
+	g.logger.Warn("Banned %s until %s: %s", key, ban.Until.Format(time.RFC3339), reason)
+	g.save()
+}
+
+// Unban lifts a ban on ip and forgets its offences
+func (g *Guard) Unban(ip net.IP) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	key := ip.String()
+	if _, exists := g.bans[key]; !exists {
+		return false
+	}
+	delete(g.bans, key)
+	delete(g.ipFails, key)
+	g.logger.Info("Unbanned %s", key)
+	g.save()
+	return true
+}
+
+// Bans returns the currently active bans sorted by expiry
+func (g *Guard) Bans() []Ban {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	now := time.Now()
+	bans := make([]Ban, 0, len(g.bans))
+	for _, ban := range g.bans {
+		if ban.Active(now) {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+	return bans
+}
+
+// ban bans key with an escalating duration. Must be called with the mutex held.
+func (g *Guard) ban(key, reason string, now time.Time) {
+	ban := g.bans[key]
+	if ban == nil || now.Sub(ban.Until) > g.config.MaxBanDuration {
+		// Offences are forgotten once the last ban is long expired
+		ban = &Ban{IP: key}
+		g.bans[key] = ban
+	}
+
+	duration := g.config.BanDuration
+	for i := 0; i < ban.Offences && duration < g.config.MaxBanDuration; i++ {
+		duration *= 2
+	}
+	duration = min(duration, g.config.MaxBanDuration)
+
+	ban.Offences++
+	ban.Until = now.Add(duration)
+	ban.Reason = reason
+
+	g.logger.Warn("Banned %s for %s (offence %d): %s", key, duration, ban.Offences, reason)
+	g.save()
+}
+
+// record adds a failure for key and returns the failures within the window.
+// Must be called with the mutex held.
+func (g *Guard) record(failures map[string][]time.Time, key string, now time.Time) int {
+	if key == "" {
+		return 0
+	}
+
+	cutoff := now.Add(-g.config.Window)
+	recent := failures[key][:0]
+	for _, t := range failures[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	failures[key] = recent
+
+	return len(recent)
+}
+
+// sweep drops failures that left the window, expired lockouts and bans
+// whose offences are forgotten, so addresses and usernames that stop
+// failing don't stay in memory. It runs at most once per window. Must be
+// called with the mutex held.
+func (g *Guard) sweep(now time.Time) {
+	if now.Sub(g.lastSweep) < g.config.Window {
+		return
+	}
+	g.lastSweep = now
+
+	cutoff := now.Add(-g.config.Window)
+	for _, failures := range []map[string][]time.Time{g.ipFails, g.usrFails} {
+		for key, times := range failures {
+			if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+				delete(failures, key)
+			}
+		}
+	}
+	for username, until := range g.lockouts {
+		if !now.Before(until) {
+			delete(g.lockouts, username)
+		}
+	}
+
+	forgotten := false
+	for key, ban := range g.bans {
+		if now.Sub(ban.Until) > g.config.MaxBanDuration {
+			delete(g.bans, key)
+			forgotten = true
+		}
+	}
+	if forgotten {
+		g.save()
+	}
+}
+
+// save persists the ban list. Must be called with the mutex held.
+func (g *Guard) save() {
+	bans := make([]*Ban, 0, len(g.bans))
+	for _, ban := range g.bans {
+		bans = append(bans, ban)
+	}
+
+	data, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		g.logger.Error("Failed to encode ban list: %v", err)
+		return
+	}
+
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		g.logger.Error("Failed to write ban list: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, g.path); err != nil {
+		g.logger.Error("Failed to write ban list: %v", err)
+	}
+}
+
+// isExempt returns true if ip belongs to a trusted network
+func (g *Guard) isExempt(ip net.IP) bool {
+	for _, n := range g.exempt {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+func TestGuardForgetsStaleEntries(t *testing.T) {
+	g, err := NewGuard(config.BruteForceConfig{
+		Enabled:         true,
+		Window:          time.Minute,
+		MaxAttempts:     100,
+		UserMaxAttempts: 100,
+		LockoutDuration: time.Minute,
+		BanDuration:     time.Minute,
+		MaxBanDuration:  time.Hour,
+	}, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 50 {
+		g.Failure(net.IPv4(192, 0, 2, byte(i)), "user")
+	}
+	now := time.Now()
+	g.lockouts["locked"] = now.Add(-time.Second)
+	g.bans["198.51.100.1"] = &Ban{IP: "198.51.100.1", Until: now.Add(-2 * time.Hour), Offences: 1}
+	g.bans["198.51.100.2"] = &Ban{IP: "198.51.100.2", Until: now.Add(-time.Minute), Offences: 1}
+
+	g.mutex.Lock()
+	g.sweep(now.Add(2 * time.Minute))
+	g.mutex.Unlock()
+
+	if len(g.ipFails) != 0 || len(g.usrFails) != 0 {
+		t.Errorf("failures outside the window were kept: %d addresses, %d users", len(g.ipFails), len(g.usrFails))
+	}
+	if len(g.lockouts) != 0 {
+		t.Errorf("expired lockouts were kept: %v", g.lockouts)
+	}
+	if _, exists := g.bans["198.51.100.1"]; exists {
+		t.Errorf("a ban whose offences are forgotten was kept")
+	}
+	if _, exists := g.bans["198.51.100.2"]; !exists {
+		t.Errorf("a recently expired ban was dropped before it stopped counting for escalation")
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -25,30 +26,30 @@ func CheckPermission(user *config.User, dataDir, requestPath string, perm Permis
 	}
 
 	// Normalize the requested path
-	requestPath = filepath.Clean(requestPath)
-	if !strings.HasPrefix(requestPath, "/") {
-		requestPath = "/" + requestPath
-	}
+	requestPath = path.Clean("/" + requestPath)
 
 	// Get user's allowed path
-	userPath := user.Path
-	if userPath == "" {
-		userPath = "/"
-	}
+	userPath := path.Clean("/" + user.Path)
 
 	// Check if the requested path is within the user's allowed path
-	if !strings.HasPrefix(requestPath, userPath) {
+	if !IsWithin(userPath, requestPath) {
 		return fmt.Errorf("access denied: path '%s' is outside user's allowed path '%s'", requestPath, userPath)
 	}
 
+	// Mounted folders carry their own permissions
+	readOnly := user.IsReadOnly()
+	if mount, ok := user.FindMount(requestPath); ok {
+		readOnly = mount.IsReadOnly()
+	}
+
 	// Check permission based on user's access level
 	switch perm {
 	case PermissionRead, PermissionList:
 		// Read operations are always allowed for authenticated users
 		return nil
 	case PermissionWrite, PermissionDelete:
-		if user.IsReadOnly() {
-			return fmt.Errorf("access denied: user '%s' has read-only permissions", "user")
+		if readOnly {
+			return fmt.Errorf("access denied: user '%s' has read-only permissions", user.Name)
 		}
 		return nil
 	default:
@@ -56,6 +57,16 @@ func CheckPermission(user *config.User, dataDir, requestPath string, perm Permis
 	}
 }
 
+// IsWithin reports whether target is base or below it. Both paths must be
+// clean and absolute; the comparison works on whole path segments, so
+// "/data" does not contain "/data2".
+func IsWithin(base, target string) bool {
+	if base == "/" || base == target {
+		return true
+	}
+	return strings.HasPrefix(target, base+"/")
+}
+
 // GetUserRootPath returns the full filesystem path for the user's root directory
 func GetUserRootPath(user *config.User, dataDir string) string {
 	if user == nil {
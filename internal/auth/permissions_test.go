@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// FuzzCheckPermission checks that a user is only let into its own path,
+// and never into a sibling that merely starts with the same name
+func FuzzCheckPermission(f *testing.F) {
+	for _, seed := range []string{
+		"/data",
+		"/data/",
+		"/data/file.txt",
+		"data/file.txt",
+		"/data2",
+		"/data2/file.txt",
+		"/data-old/file.txt",
+		"/data/../data2/file.txt",
+		"/data/./../data2",
+		"//data//file.txt",
+		"/data/..",
+		"/",
+		"",
+		"..",
+		"/../data/file.txt",
+	} {
+		f.Add(seed)
+	}
+
+	user := &config.User{Name: "bob", Path: "/data", Permissions: "rw"}
+
+	f.Fuzz(func(t *testing.T, requestPath string) {
+		cleaned := path.Clean("/" + requestPath)
+		inside := cleaned == "/data" || strings.HasPrefix(cleaned, "/data/")
+
+		err := CheckPermission(user, "/srv", requestPath, PermissionRead)
+		if inside && err != nil {
+			t.Errorf("%q (%s) was refused: %v", requestPath, cleaned, err)
+		}
+		if !inside && err == nil {
+			t.Errorf("%q (%s) was allowed outside /data", requestPath, cleaned)
+		}
+	})
+}
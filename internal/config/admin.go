@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// AdminConfig enables the HTTP API to inspect and control the running
+// server: sessions, bans, users and services
+type AdminConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // TCP address of the listener, default 127.0.0.1:9122
+	Socket  string `yaml:"socket"` // unix socket path, used instead of listen when set
+	Token   string `yaml:"token"`  // bearer token clients must send
+}
+
+// validate checks the admin API settings
+func (a *AdminConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Socket != "" {
+		// The socket is only reachable by local users with access to the
+		// file, a token is optional
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(a.Listen); err != nil {
+		return fmt.Errorf("invalid listen address '%s': %w", a.Listen, err)
+	}
+	if a.Token == "" {
+		return fmt.Errorf("a token is required on a TCP listener")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// AnonymousConfig enables classic anonymous FTP access to a public tree
+type AnonymousConfig struct {
+	Enabled      bool         `yaml:"enabled"`
+	Path         string       `yaml:"path"`          // public tree relative to data dir
+	Permissions  string       `yaml:"permissions"`   // "ro" (default) or "rw"
+	UploadDir    string       `yaml:"upload_dir"`    // optional writable directory inside path
+	DownloadRate int64        `yaml:"download_rate"` // bytes per second, zero means unlimited
+	UploadRate   int64        `yaml:"upload_rate"`   // bytes per second, zero means unlimited
+	MaxSessions  int          `yaml:"max_sessions"`  // concurrent anonymous sessions, zero means unlimited
+	UID          int          `yaml:"uid"`           // owner of uploaded files
+	GID          *int         `yaml:"gid"`           // defaults to the uid
+	Access       AccessConfig `yaml:"access"`
+}
+
+// anonymousNames are the login names that select anonymous access
+var anonymousNames = map[string]bool{"anonymous": true, "ftp": true}
+
+// IsAnonymousName returns true if username requests anonymous access
+func IsAnonymousName(username string) bool {
+	return anonymousNames[strings.ToLower(username)]
+}
+
+// AnonymousUser returns the user anonymous sessions run as, or nil if
+// anonymous access is disabled. Only valid after Validate.
+func (c *Config) AnonymousUser() *User {
+	return c.anonymous
+}
+
+// resolveAnonymous validates the anonymous section and builds its user
+func (c *Config) resolveAnonymous() error {
+	c.anonymous = nil
+	a := &c.Anonymous
+	if !a.Enabled {
+		return nil
+	}
+
+	if a.Path == "" {
+		a.Path = "/"
+	} else if !strings.HasPrefix(a.Path, "/") {
+		a.Path = "/" + a.Path
+	}
+	if a.Permissions == "" {
+		a.Permissions = "ro"
+	}
+	if a.Permissions != "ro" && a.Permissions != "rw" {
+		return fmt.Errorf("invalid anonymous permissions '%s', must be 'ro' or 'rw'", a.Permissions)
+	}
+	if a.DownloadRate < 0 || a.UploadRate < 0 || a.MaxSessions < 0 {
+		return fmt.Errorf("anonymous rate limits and max_sessions cannot be negative")
+	}
+	if err := a.Access.validate(); err != nil {
+		return fmt.Errorf("invalid anonymous access rules: %w", err)
+	}
+	if a.UID < 0 || (a.GID != nil && *a.GID < 0) {
+		return fmt.Errorf("anonymous uid and gid cannot be negative")
+	}
+
+	if err := os.MkdirAll(filepath.Join(c.Data, strings.TrimPrefix(a.Path, "/")), 0755); err != nil {
+		return fmt.Errorf("failed to create anonymous directory: %w", err)
+	}
+
+	user := &User{
+		Name:         "anonymous",
+		UID:          a.UID,
+		GID:          a.GID,
+		Path:         a.Path,
+		Permissions:  a.Permissions,
+		DownloadRate: a.DownloadRate,
+		UploadRate:   a.UploadRate,
+		Access:       a.Access,
+		Modes:        c.Modes,
+		Versioning:   c.Versioning,
+		Trash:        &c.Trash.Enabled,
+		Anonymous:    true,
+	}
+
+	// The upload directory is a writable mount inside the public tree
+	if a.UploadDir != "" {
+		user.Mounts = []Mount{{
+			Path:        a.UploadDir,
+			Source:      strings.TrimPrefix(filepath.Join(a.Path, a.UploadDir), "/"),
+			Permissions: "rw",
+		}}
+	}
+
+	if err := c.resolveMounts("anonymous", user); err != nil {
+		return err
+	}
+
+	c.anonymous = user
+	return nil
+}
@@ -6,25 +6,79 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the complete application configuration
 type Config struct {
-	Data     string           `yaml:"data"`
-	Users    map[string]*User `yaml:"users"`
-	Services ServiceConfig    `yaml:"services"`
-	Logging  LoggingConfig    `yaml:"logging"`
-	TLS      TLSConfig        `yaml:"tls"`
+	Data        string            `yaml:"data"`
+	State       string            `yaml:"state"` // persisted runtime state such as quota usage
+	Users       map[string]*User  `yaml:"users"`
+	Groups      map[string]*Group `yaml:"groups"`
+	Services    ServiceConfig     `yaml:"services"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	TransferLog TransferLogConfig `yaml:"transfer_log"` // who transferred what, when
+	AuditLog    LogFileConfig     `yaml:"audit_log"`    // logins, changes and denials
+	TLS         TLSConfig         `yaml:"tls"`
+	Security    SecurityConfig    `yaml:"security"`
+	Access      AccessConfig      `yaml:"access"` // global client network restrictions
+	Anonymous   AnonymousConfig   `yaml:"anonymous"`
+	Modes       ModeConfig        `yaml:"modes"` // permissions of new files and directories
+	Trash       TrashConfig       `yaml:"trash"`
+	Versioning  VersioningConfig  `yaml:"versioning"` // versions of overwritten files
+	Locking     LockingConfig     `yaml:"locking"`    // conflicting transfers of the same file
+	Metrics     MetricsConfig     `yaml:"metrics"`    // Prometheus metrics listener
+	Admin       AdminConfig       `yaml:"admin"`      // HTTP API to control the server
+	Reload      ReloadConfig      `yaml:"reload"`     // reloading the file while running
+	Supervisor  SupervisorConfig  `yaml:"supervisor"` // start failures and restarts of services
+	Shutdown    ShutdownConfig    `yaml:"shutdown"`   // draining sessions when stopping
+	RunAs       RunAsConfig       `yaml:"run_as"`     // privileges once the listeners are bound
+
+	// anonymous is the user built from the anonymous section by Validate
+	anonymous *User
+
+	// file is the path the configuration was loaded from, if any
+	file string
 }
 
 // User represents a user configuration
 type User struct {
-	Pass        string `yaml:"pass"`
-	UID         int    `yaml:"uid"`
-	Path        string `yaml:"path"`
-	Permissions string `yaml:"permissions"` // "ro" or "rw"
+	Name        string           `yaml:"-"` // filled from the users map key
+	Pass        string           `yaml:"pass"`
+	UID         int              `yaml:"uid"`
+	GID         *int             `yaml:"gid"`                // defaults to the uid
+	ExtraGIDs   []int            `yaml:"supplementary_gids"` // used by user helper processes
+	Path        string           `yaml:"path"`
+	Permissions string           `yaml:"permissions"` // "ro" or "rw"
+	Groups      []string         `yaml:"groups"`
+	Mounts      []Mount          `yaml:"mounts"`
+	Quota       Quota            `yaml:"quota"`
+	Access      AccessConfig     `yaml:"access"`
+	Storage     StorageConfig    `yaml:"storage"`
+	Modes       ModeConfig       `yaml:"modes"`      // defaults to the global modes
+	Versioning  VersioningConfig `yaml:"versioning"` // defaults to the global versioning
+
+	// Transfer rate limits in bytes per second, zero means unlimited
+	DownloadRate int64 `yaml:"download_rate"`
+	UploadRate   int64 `yaml:"upload_rate"`
+
+	// AtomicUploads overrides the service's atomic_uploads setting when set
+	AtomicUploads *bool `yaml:"atomic_uploads"`
+
+	// Trash overrides whether deletions go to the trash, defaults to the
+	// global trash setting
+	Trash *bool `yaml:"trash"`
+
+	// Disabled users cannot log in
+	Disabled bool `yaml:"disabled"`
+
+	// Anonymous is set for the guest user built from the anonymous section
+	Anonymous bool `yaml:"-"`
+
+	// mounts holds the user and group mounts resolved by Validate
+	mounts []Mount
 }
 
 // ServiceConfig contains all service configurations
@@ -37,10 +91,33 @@ type ServiceConfig struct {
 	TFTP  ProtocolConfig `yaml:"tftp"`
 }
 
+// protocols returns the basic settings of every service keyed by name
+func (s *ServiceConfig) protocols() map[string]*ProtocolConfig {
+	return map[string]*ProtocolConfig{
+		"ftp":   &s.FTP,
+		"ftps":  &s.FTPS.ProtocolConfig,
+		"sftp":  &s.SFTP.ProtocolConfig,
+		"http":  &s.HTTP.ProtocolConfig,
+		"https": &s.HTTPS.HTTPConfig.ProtocolConfig,
+		"tftp":  &s.TFTP,
+	}
+}
+
 // ProtocolConfig is basic protocol configuration
 type ProtocolConfig struct {
-	Enabled bool `yaml:"enabled"`
-	Port    int  `yaml:"port"`
+	Enabled       bool         `yaml:"enabled"`
+	Port          int          `yaml:"port"`
+	Access        AccessConfig `yaml:"access"`
+	AtomicUploads bool         `yaml:"atomic_uploads"` // write to a temp file, rename when complete
+}
+
+// UseAtomicUploads reports whether uploads by user through this service
+// should be atomic. The user's own setting wins over the service's.
+func (p *ProtocolConfig) UseAtomicUploads(user *User) bool {
+	if user != nil && user.AtomicUploads != nil {
+		return *user.AtomicUploads
+	}
+	return p.AtomicUploads
 }
 
 // FTPSConfig extends ProtocolConfig with TLS settings
@@ -72,8 +149,10 @@ type HTTPSConfig struct {
 
 // LoggingConfig contains logging configuration
 type LoggingConfig struct {
-	Level  string `yaml:"level"`  // debug, info, warn, error
-	Format string `yaml:"format"` // text, json
+	Level      string            `yaml:"level"`      // debug, info, warn, error
+	Format     string            `yaml:"format"`     // text, json
+	Subsystems map[string]string `yaml:"subsystems"` // levels of individual subsystems, e.g. tftp: debug
+	Outputs    []LogOutputConfig `yaml:"outputs"`    // where lines go, stdout when empty
 }
 
 // TLSConfig contains TLS settings for auto-generated certificates
@@ -85,8 +164,10 @@ type TLSConfig struct {
 // DefaultConfig returns a configuration with sane defaults
 func DefaultConfig() *Config {
 	return &Config{
-		Data:  "./data",
-		Users: make(map[string]*User),
+		Data:   "./data",
+		State:  "./state",
+		Users:  make(map[string]*User),
+		Groups: make(map[string]*Group),
 		Services: ServiceConfig{
 			FTP:   ProtocolConfig{Enabled: false, Port: 21},
 			FTPS:  FTPSConfig{ProtocolConfig: ProtocolConfig{Enabled: false, Port: 990}},
@@ -103,6 +184,58 @@ func DefaultConfig() *Config {
 			Hostname:     "localhost",
 			Organization: "FTP-AIO",
 		},
+		Security: SecurityConfig{
+			BruteForce: BruteForceConfig{
+				Enabled:         true,
+				Window:          10 * time.Minute,
+				MaxAttempts:     10,
+				BanDuration:     15 * time.Minute,
+				MaxBanDuration:  24 * time.Hour,
+				UserMaxAttempts: 20,
+				LockoutDuration: 15 * time.Minute,
+				Delay:           time.Second,
+				MaxDelay:        5 * time.Second,
+			},
+			Symlinks:            SymlinksWithinRoot,
+			MaxRecursiveEntries: 10000,
+		},
+		Trash: TrashConfig{
+			Enabled:       false,
+			Retention:     30 * 24 * time.Hour,
+			PurgeInterval: time.Hour,
+		},
+		Locking: LockingConfig{
+			Conflict: LockWait,
+			Timeout:  30 * time.Second,
+		},
+		TransferLog: TransferLogConfig{
+			LogFileConfig: LogFileConfig{MaxSize: 100 << 20, MaxAge: 24 * time.Hour, MaxBackups: 30},
+			Format:        TransferLogXferlog,
+		},
+		AuditLog: LogFileConfig{MaxSize: 100 << 20, MaxAge: 24 * time.Hour, MaxBackups: 30},
+		Metrics: MetricsConfig{
+			Enabled:    false,
+			Listen:     "127.0.0.1:9121",
+			Path:       "/metrics",
+			HealthPath: "/health",
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+			Listen:  "127.0.0.1:9122",
+		},
+		Reload: ReloadConfig{
+			Watch:    false,
+			Interval: 5 * time.Second,
+		},
+		Supervisor: SupervisorConfig{
+			StartFailure: StartFail,
+			Restart:      true,
+			MinBackoff:   time.Second,
+			MaxBackoff:   time.Minute,
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: 30 * time.Second,
+		},
 	}
 }
 
@@ -125,10 +258,17 @@ func LoadFromFile(filename string) (*Config, error) {
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	config.file = filename
 
 	return config, nil
 }
 
+// File returns the path the configuration was loaded from, or an empty
+// string if it was not loaded from a file
+func (c *Config) File() string {
+	return c.file
+}
+
 // ApplyEnvironmentVariables applies environment variables to the configuration
 func (c *Config) ApplyEnvironmentVariables() {
 	// Data directory
@@ -136,6 +276,11 @@ func (c *Config) ApplyEnvironmentVariables() {
 		c.Data = val
 	}
 
+	// State directory
+	if val := os.Getenv("AIO_STATE"); val != "" {
+		c.State = val
+	}
+
 	// Users from environment
 	if val := os.Getenv("AIO_USERS"); val != "" {
 		users, err := ParseUserString(val)
@@ -206,6 +351,11 @@ func (c *Config) ApplyEnvironmentVariables() {
 	if val := os.Getenv("AIO_LOG_LEVEL"); val != "" {
 		c.Logging.Level = val
 	}
+
+	// Privileges, as user or user:group
+	if val := os.Getenv("AIO_RUN_AS"); val != "" {
+		c.RunAs.User, c.RunAs.Group, _ = strings.Cut(val, ":")
+	}
 }
 
 // Validate validates the configuration
@@ -220,27 +370,74 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("failed to create data directory: %w", err)
 	}
 
+	// Create state directory if it doesn't exist
+	if c.State == "" {
+		return fmt.Errorf("state directory cannot be empty")
+	}
+	if err := os.MkdirAll(c.State, 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+
+	// Unset modes fall back to the built-in defaults
+	c.Modes.inherit(defaultModes)
+
+	if err := c.Trash.validate(); err != nil {
+		return fmt.Errorf("invalid trash settings: %w", err)
+	}
+	c.Versioning.inherit(defaultVersioning)
+	if err := c.Versioning.validate(); err != nil {
+		return fmt.Errorf("invalid versioning settings: %w", err)
+	}
+	if err := c.Locking.validate(); err != nil {
+		return fmt.Errorf("invalid locking settings: %w", err)
+	}
+	if err := c.Metrics.validate(); err != nil {
+		return fmt.Errorf("invalid metrics settings: %w", err)
+	}
+	if err := c.Admin.validate(); err != nil {
+		return fmt.Errorf("invalid admin settings: %w", err)
+	}
+	if err := c.Supervisor.validate(); err != nil {
+		return fmt.Errorf("invalid supervisor settings: %w", err)
+	}
+	if err := c.Shutdown.validate(); err != nil {
+		return fmt.Errorf("invalid shutdown settings: %w", err)
+	}
+	if err := c.Reload.validate(); err != nil {
+		return fmt.Errorf("invalid reload settings: %w", err)
+	}
+	if err := c.TransferLog.validate(c.State); err != nil {
+		return fmt.Errorf("invalid transfer log settings: %w", err)
+	}
+	if err := c.AuditLog.validate(c.State, "audit.log"); err != nil {
+		return fmt.Errorf("invalid audit log settings: %w", err)
+	}
+
+	// Validate groups
+	for name, group := range c.Groups {
+		if group == nil {
+			c.Groups[name] = &Group{}
+			continue
+		}
+		if err := group.Quota.validate(); err != nil {
+			return fmt.Errorf("invalid quota for group %s: %w", name, err)
+		}
+	}
+
 	// Validate users
-	if len(c.Users) == 0 {
+	if len(c.Users) == 0 && !c.Anonymous.Enabled {
 		return fmt.Errorf("at least one user must be configured")
 	}
 
 	for username, user := range c.Users {
-		if username == "" {
-			return fmt.Errorf("username cannot be empty")
-		}
-		if user.Pass == "" {
-			return fmt.Errorf("password cannot be empty for user %s", username)
-		}
-		if user.Permissions != "ro" && user.Permissions != "rw" {
-			return fmt.Errorf("invalid permissions '%s' for user %s, must be 'ro' or 'rw'", user.Permissions, username)
+		if err := c.ValidateUser(username, user); err != nil {
+			return err
 		}
+	}
 
-		// Ensure user path exists
-		userPath := filepath.Join(c.Data, strings.TrimPrefix(user.Path, "/"))
-		if err := os.MkdirAll(userPath, 0755); err != nil {
-			return fmt.Errorf("failed to create user directory for %s: %w", username, err)
-		}
+	// Build the anonymous user
+	if err := c.resolveAnonymous(); err != nil {
+		return err
 	}
 
 	// Validate that at least one service is enabled
@@ -251,11 +448,92 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("at least one service must be enabled")
 	}
 
-	// Validate log level
-	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
-	if !validLevels[c.Logging.Level] {
-		return fmt.Errorf("invalid log level '%s', must be one of: debug, info, warn, error", c.Logging.Level)
+	// Validate logging
+	if err := c.Logging.validate(); err != nil {
+		return err
+	}
+
+	// Validate client network restrictions
+	if err := c.Access.validate(); err != nil {
+		return fmt.Errorf("invalid global access rules: %w", err)
+	}
+	for name, service := range c.Services.protocols() {
+		if err := service.Access.validate(); err != nil {
+			return fmt.Errorf("invalid access rules for %s: %w", name, err)
+		}
+	}
+
+	// Validate login protection
+	if err := c.Security.BruteForce.validate(); err != nil {
+		return fmt.Errorf("invalid brute_force settings: %w", err)
+	}
+	if err := validateSymlinks(c.Security.Symlinks); err != nil {
+		return err
+	}
+	if c.Security.UserHelpers && os.Geteuid() != 0 {
+		return fmt.Errorf("user_helpers requires running as root")
+	}
+	if err := c.RunAs.validate(c.Security); err != nil {
+		return fmt.Errorf("invalid run_as settings: %w", err)
+	}
+	if c.Security.MaxRecursiveEntries < 0 {
+		return fmt.Errorf("max_recursive_entries cannot be negative")
 	}
 
 	return nil
 }
+
+// ValidateUser checks a user and fills in its defaults, directory and
+// mounts. Validate calls it for every configured user; users added while
+// running go through it as well.
+func (c *Config) ValidateUser(username string, user *User) error {
+	if username == "" {
+		return fmt.Errorf("username cannot be empty")
+	}
+	if user.Pass == "" {
+		return fmt.Errorf("password cannot be empty for user %s", username)
+	}
+	if user.Permissions != "ro" && user.Permissions != "rw" {
+		return fmt.Errorf("invalid permissions '%s' for user %s, must be 'ro' or 'rw'", user.Permissions, username)
+	}
+	if err := user.Quota.validate(); err != nil {
+		return fmt.Errorf("invalid quota for user %s: %w", username, err)
+	}
+	if err := user.Access.validate(); err != nil {
+		return fmt.Errorf("invalid access rules for user %s: %w", username, err)
+	}
+	if user.DownloadRate < 0 || user.UploadRate < 0 {
+		return fmt.Errorf("rate limits cannot be negative for user %s", username)
+	}
+	if err := user.Storage.validate(); err != nil {
+		return fmt.Errorf("invalid storage for user %s: %w", username, err)
+	}
+	if err := user.validateIDs(); err != nil {
+		return fmt.Errorf("invalid ids for user %s: %w", username, err)
+	}
+	user.Modes.inherit(c.Modes)
+	user.Versioning.inherit(c.Versioning)
+	if err := user.Versioning.validate(); err != nil {
+		return fmt.Errorf("invalid versioning for user %s: %w", username, err)
+	}
+	if user.Trash == nil {
+		user.Trash = &c.Trash.Enabled
+	}
+	user.Name = username
+
+	// Ensure user path exists, owned by the user when running as root.
+	// Not with run_as, as files are then written as the run_as user.
+	userPath := filepath.Join(c.Data, strings.TrimPrefix(user.Path, "/"))
+	_, statErr := os.Stat(userPath)
+	if err := os.MkdirAll(userPath, 0755); err != nil {
+		return fmt.Errorf("failed to create user directory for %s: %w", username, err)
+	}
+	if os.IsNotExist(statErr) && user.Path != "/" && os.Geteuid() == 0 && c.RunAs.User == "" {
+		if err := os.Chown(userPath, user.UID, user.GroupID()); err != nil {
+			return fmt.Errorf("failed to change owner of user directory for %s: %w", username, err)
+		}
+	}
+
+	// Resolve group and user mounts
+	return c.resolveMounts(username, user)
+}
@@ -13,6 +13,7 @@ const (
 // Default configuration values
 const (
 	DefaultDataDir     = "./data"
+	DefaultStateDir    = "./state"
 	DefaultLogLevel    = "info"
 	DefaultLogFormat   = "text"
 	DefaultTLSHostname = "localhost"
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Group represents a named set of users sharing virtual folders
+type Group struct {
+	Mounts []Mount `yaml:"mounts"`
+	Quota  Quota   `yaml:"quota"`
+}
+
+// Mount maps a directory on disk into a user's tree
+type Mount struct {
+	Path        string           `yaml:"path"`        // mount point relative to the user's home, e.g. /shared
+	Source      string           `yaml:"source"`      // directory on disk, relative paths are inside data
+	Permissions string           `yaml:"permissions"` // "ro" or "rw", defaults to the user's permissions
+	External    bool             `yaml:"external"`    // must be set when source lies outside data
+	Storage     StorageConfig    `yaml:"storage"`     // backend, the source only applies to local storage
+	Modes       ModeConfig       `yaml:"modes"`       // defaults to the user's modes
+	Versioning  VersioningConfig `yaml:"versioning"`  // defaults to the user's versioning
+}
+
+// IsReadOnly returns true if the mount only allows reading
+func (m *Mount) IsReadOnly() bool {
+	return m.Permissions == "ro"
+}
+
+// FindMount returns the mount containing the given data-relative path
+func (u *User) FindMount(requestPath string) (*Mount, bool) {
+	requestPath = path.Clean("/" + requestPath)
+	for i := range u.mounts {
+		m := &u.mounts[i]
+		if requestPath == m.Path || strings.HasPrefix(requestPath, m.Path+"/") {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// ResolvedMounts returns all of the user's mounts, including those of its
+// groups. Only valid after Validate.
+func (u *User) ResolvedMounts() []Mount {
+	return u.mounts
+}
+
+// MountsIn returns the mounts whose mount point is a direct child of dir
+func (u *User) MountsIn(dir string) []Mount {
+	dir = path.Clean("/" + dir)
+	var mounts []Mount
+	for _, m := range u.mounts {
+		if path.Dir(m.Path) == dir {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// HasMountsBelow returns true if any mount point lies inside dir
+func (u *User) HasMountsBelow(dir string) bool {
+	dir = path.Clean("/" + dir)
+	for _, m := range u.mounts {
+		if strings.HasPrefix(m.Path, dir+"/") || (dir == "/" && m.Path != "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveMounts merges the user's own mounts with those of its groups.
+// Mount points are stored relative to the data directory and sources as
+// absolute paths, so lookups never need the configuration again.
+func (c *Config) resolveMounts(username string, user *User) error {
+	byPath := make(map[string]Mount)
+
+	add := func(m Mount, owner string) error {
+		if err := m.Storage.validate(); err != nil {
+			return fmt.Errorf("mount %s in %s: %w", m.Path, owner, err)
+		}
+		if m.Path == "" || (m.Source == "" && m.Storage.IsLocal()) {
+			return fmt.Errorf("mount in %s needs both path and source", owner)
+		}
+		if m.Permissions == "" {
+			m.Permissions = user.Permissions
+		}
+		if m.Permissions != "ro" && m.Permissions != "rw" {
+			return fmt.Errorf("invalid permissions '%s' for mount %s in %s, must be 'ro' or 'rw'", m.Permissions, m.Path, owner)
+		}
+		m.Modes.inherit(user.Modes)
+		m.Versioning.inherit(user.Versioning)
+		if err := m.Versioning.validate(); err != nil {
+			return fmt.Errorf("invalid versioning for mount %s in %s: %w", m.Path, owner, err)
+		}
+
+		mountPoint := path.Clean("/" + m.Path)
+		if mountPoint == "/" {
+			return fmt.Errorf("mount in %s cannot replace the home directory", owner)
+		}
+		m.Path = path.Join(homePath(user), mountPoint)
+
+		if m.Storage.IsLocal() {
+			source, err := c.resolveMountSource(m)
+			if err != nil {
+				return fmt.Errorf("mount %s in %s: %w", mountPoint, owner, err)
+			}
+			m.Source = source
+		}
+
+		byPath[m.Path] = m
+		return nil
+	}
+
+	// Group mounts first so the user's own mounts take precedence
+	for _, name := range user.Groups {
+		group, exists := c.Groups[name]
+		if !exists {
+			return fmt.Errorf("user %s references unknown group '%s'", username, name)
+		}
+		for _, m := range group.Mounts {
+			if err := add(m, "group "+name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, m := range user.Mounts {
+		if err := add(m, "user "+username); err != nil {
+			return err
+		}
+	}
+
+	user.mounts = make([]Mount, 0, len(byPath))
+	for _, m := range byPath {
+		user.mounts = append(user.mounts, m)
+	}
+	// Longest mount point first so nested mounts win
+	sort.Slice(user.mounts, func(i, j int) bool {
+		return len(user.mounts[i].Path) > len(user.mounts[j].Path)
+	})
+
+	return nil
+}
+
+// resolveMountSource returns the absolute source directory of a mount
+func (c *Config) resolveMountSource(m Mount) (string, error) {
+	dataDir, err := filepath.Abs(c.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve data directory: %w", err)
+	}
+
+	source := m.Source
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(dataDir, source)
+	}
+	source = filepath.Clean(source)
+
+	inside := source == dataDir || strings.HasPrefix(source, dataDir+string(filepath.Separator))
+	if !inside && !m.External {
+		return "", fmt.Errorf("source %s is outside the data directory, set external: true to allow it", source)
+	}
+
+	if inside {
+		if err := os.MkdirAll(source, 0755); err != nil {
+			return "", fmt.Errorf("failed to create source directory: %w", err)
+		}
+	} else if info, err := os.Stat(source); err != nil {
+		return "", fmt.Errorf("external source %s is not accessible: %w", source, err)
+	} else if !info.IsDir() {
+		return "", fmt.Errorf("external source %s is not a directory", source)
+	}
+
+	return source, nil
+}
+
+// homePath returns the user's home relative to the data directory
+func homePath(user *User) string {
+	if user.Path == "" {
+		return "/"
+	}
+	return path.Clean("/" + user.Path)
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockingConfig controls what happens when transfers of the same file
+// conflict. Readers share a lock, writers need it exclusively.
+type LockingConfig struct {
+	Conflict string        `yaml:"conflict"` // wait (default), fail or snapshot
+	Timeout  time.Duration `yaml:"timeout"`  // longest wait for a lock, default 30s
+}
+
+// Conflict policies for file locks
+const (
+	LockWait     = "wait"     // wait for the other transfer up to the timeout
+	LockFail     = "fail"     // fail right away, FTP answers 450
+	LockSnapshot = "snapshot" // readers never wait and see the old version until an upload completes
+)
+
+// validate checks the locking settings
+func (l *LockingConfig) validate() error {
+	switch l.Conflict {
+	case "":
+		l.Conflict = LockWait
+	case LockWait, LockFail, LockSnapshot:
+	default:
+		return fmt.Errorf("unknown conflict policy '%s', must be one of: wait, fail, snapshot", l.Conflict)
+	}
+	if l.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogSubsystems are the parts of the server that can log at their own level
+var LogSubsystems = []string{"server", "ftp", "tftp", "auth", "fs", "metrics", "admin"}
+
+// validLogLevels are the accepted log levels
+var validLogLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// validate checks the log format and the global and subsystem levels
+func (l *LoggingConfig) validate() error {
+	if !validLogLevels[l.Level] {
+		return fmt.Errorf("invalid log level '%s', must be one of: debug, info, warn, error", l.Level)
+	}
+	switch l.Format {
+	case "":
+		l.Format = "text"
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid log format '%s', must be one of: text, json", l.Format)
+	}
+
+	if len(l.Outputs) == 0 {
+		l.Outputs = []LogOutputConfig{{Type: LogOutputStdout}}
+	}
+	for i := range l.Outputs {
+		if err := l.Outputs[i].validate(l.Format); err != nil {
+			return fmt.Errorf("invalid log output %d: %w", i+1, err)
+		}
+	}
+
+	names := make([]string, 0, len(l.Subsystems))
+	for name := range l.Subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !isLogSubsystem(name) {
+			return fmt.Errorf("unknown log subsystem '%s', must be one of: %s", name, strings.Join(LogSubsystems, ", "))
+		}
+		if level := l.Subsystems[name]; !validLogLevels[level] {
+			return fmt.Errorf("invalid log level '%s' for %s, must be one of: debug, info, warn, error", level, name)
+		}
+	}
+	return nil
+}
+
+// isLogSubsystem returns true if name is one of the LogSubsystems
+func isLogSubsystem(name string) bool {
+	for _, subsystem := range LogSubsystems {
+		if subsystem == name {
+			return true
+		}
+	}
+	return false
+}
+
+// LogOutputConfig is a destination of the log. Each output writes what the
+// global and subsystem levels let through, limited further by its own level.
+type LogOutputConfig struct {
+	Type   string `yaml:"type"`   // stdout, file, syslog or journald
+	Level  string `yaml:"level"`  // least severe level written here, default all
+	Format string `yaml:"format"` // text or json for stdout and file, defaults to logging.format
+
+	// file: rotated like the transfer and audit logs
+	Path       string        `yaml:"path"`
+	MaxSize    int64         `yaml:"max_size"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	MaxBackups int           `yaml:"max_backups"`
+
+	// syslog: RFC 5424 to a local socket or a remote host
+	Network  string `yaml:"network"`  // unixgram (default), unix, udp or tcp
+	Address  string `yaml:"address"`  // /dev/log by default, host:port for udp and tcp
+	Facility string `yaml:"facility"` // daemon by default
+	Tag      string `yaml:"tag"`      // application name, also used by journald
+}
+
+// Log output types
+const (
+	LogOutputStdout   = "stdout"
+	LogOutputFile     = "file"
+	LogOutputSyslog   = "syslog"
+	LogOutputJournald = "journald"
+)
+
+// validate checks an output, defaulting its format to the global one
+func (o *LogOutputConfig) validate(format string) error {
+	if o.Level != "" && !validLogLevels[o.Level] {
+		return fmt.Errorf("invalid log level '%s', must be one of: debug, info, warn, error", o.Level)
+	}
+	if o.Format == "" {
+		o.Format = format
+	}
+	if o.Format != "text" && o.Format != "json" {
+		return fmt.Errorf("invalid log format '%s', must be one of: text, json", o.Format)
+	}
+
+	switch o.Type {
+	case LogOutputStdout, LogOutputJournald:
+	case LogOutputFile:
+		if o.Path == "" {
+			return fmt.Errorf("file output needs a path")
+		}
+		if o.MaxSize < 0 || o.MaxAge < 0 || o.MaxBackups < 0 {
+			return fmt.Errorf("rotation limits cannot be negative")
+		}
+	case LogOutputSyslog:
+		switch o.Network {
+		case "", "unixgram", "unix":
+		case "udp", "tcp":
+			if o.Address == "" {
+				return fmt.Errorf("syslog over %s needs an address", o.Network)
+			}
+		default:
+			return fmt.Errorf("unknown syslog network '%s', must be one of: unixgram, unix, udp, tcp", o.Network)
+		}
+	default:
+		return fmt.Errorf("unknown type '%s', must be one of: stdout, file, syslog, journald", o.Type)
+	}
+	return nil
+}
+
+// LogFileConfig is a log file rotated by size and age
+type LogFileConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Path       string        `yaml:"path"`        // defaults to a file in the state directory
+	MaxSize    int64         `yaml:"max_size"`    // bytes before rotating, 0 = no limit
+	MaxAge     time.Duration `yaml:"max_age"`     // age before rotating, 0 = no limit
+	MaxBackups int           `yaml:"max_backups"` // rotated files kept, 0 = all
+}
+
+// TransferLogConfig records every transfer on every protocol
+type TransferLogConfig struct {
+	LogFileConfig `yaml:",inline"`
+	Format        string `yaml:"format"` // xferlog (default) or json
+}
+
+// Transfer log formats
+const (
+	TransferLogXferlog = "xferlog" // wu-ftpd xferlog lines
+	TransferLogJSON    = "json"    // one JSON object per line
+)
+
+// validate checks the log file settings, defaulting the path to name in
+// the state directory
+func (l *LogFileConfig) validate(stateDir, name string) error {
+	if l.Path == "" {
+		l.Path = filepath.Join(stateDir, name)
+	}
+	if l.MaxSize < 0 {
+		return fmt.Errorf("max_size cannot be negative")
+	}
+	if l.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	if l.MaxBackups < 0 {
+		return fmt.Errorf("max_backups cannot be negative")
+	}
+	return nil
+}
+
+// validate checks the transfer log settings
+func (t *TransferLogConfig) validate(stateDir string) error {
+	switch t.Format {
+	case "":
+		t.Format = TransferLogXferlog
+	case TransferLogXferlog, TransferLogJSON:
+	default:
+		return fmt.Errorf("unknown format '%s', must be one of: xferlog, json", t.Format)
+	}
+	return t.LogFileConfig.validate(stateDir, "xferlog")
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// MetricsConfig exposes counters of all services in the Prometheus text
+// format on a separate HTTP listener
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Listen  string `yaml:"listen"` // address of the listener, default 127.0.0.1:9121
+	Path    string `yaml:"path"`   // URL path of the metrics, default /metrics
+
+	// HealthPath answers 200 while every started service is running and
+	// 503 otherwise, with the state of each service
+	HealthPath string `yaml:"health_path"` // default /health
+}
+
+// validate checks the metrics settings
+func (m *MetricsConfig) validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(m.Listen); err != nil {
+		return fmt.Errorf("invalid listen address '%s': %w", m.Listen, err)
+	}
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if !strings.HasPrefix(m.HealthPath, "/") || m.HealthPath == m.Path {
+		return fmt.Errorf("health_path must start with / and differ from path")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Mode is a unix permission mode written in octal, such as "0644" or "2775"
+type Mode uint32
+
+// UnmarshalYAML parses the mode as octal whether or not it is quoted
+func (m *Mode) UnmarshalYAML(node *yaml.Node) error {
+	mode, err := ParseMode(node.Value)
+	if err != nil {
+		return err
+	}
+	*m = mode
+	return nil
+}
+
+// ParseMode parses an octal permission mode
+func ParseMode(s string) (Mode, error) {
+	value, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || value > 07777 {
+		return 0, fmt.Errorf("invalid mode '%s', must be octal like 0644", s)
+	}
+	return Mode(value), nil
+}
+
+// String formats the mode in octal
+func (m Mode) String() string {
+	return fmt.Sprintf("%04o", uint32(m))
+}
+
+// FileMode converts the mode to an os.FileMode, including the setuid,
+// setgid and sticky bits
+func (m Mode) FileMode() os.FileMode {
+	mode := os.FileMode(m & 0777)
+	if m&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// ModeConfig controls the permissions of new files and directories and
+// what SITE CHMOD may set. Unset fields are inherited: mounts from their
+// user, users from the global settings.
+type ModeConfig struct {
+	FileMode  *Mode `yaml:"file_mode"`  // new files before the umask, default 0666
+	DirMode   *Mode `yaml:"dir_mode"`   // new directories before the umask, default 0777
+	Umask     *Mode `yaml:"umask"`      // default 022
+	ChmodMask *Mode `yaml:"chmod_mask"` // bits users may set, default 0777
+}
+
+// defaultModes match what the server used before modes were configurable
+var defaultModes = ModeConfig{
+	FileMode:  modePtr(0666),
+	DirMode:   modePtr(0777),
+	Umask:     modePtr(0022),
+	ChmodMask: modePtr(0777),
+}
+
+func modePtr(m Mode) *Mode {
+	return &m
+}
+
+// inherit fills the unset fields from parent
+func (m *ModeConfig) inherit(parent ModeConfig) {
+	if m.FileMode == nil {
+		m.FileMode = parent.FileMode
+	}
+	if m.DirMode == nil {
+		m.DirMode = parent.DirMode
+	}
+	if m.Umask == nil {
+		m.Umask = parent.Umask
+	}
+	if m.ChmodMask == nil {
+		m.ChmodMask = parent.ChmodMask
+	}
+}
+
+// NewFileMode returns the mode new files are given
+func (m *ModeConfig) NewFileMode() os.FileMode {
+	return (*m.FileMode &^ *m.Umask).FileMode()
+}
+
+// NewDirMode returns the mode new directories are given
+func (m *ModeConfig) NewDirMode() os.FileMode {
+	return (*m.DirMode &^ *m.Umask).FileMode()
+}
+
+// CheckChmod returns an error if mode sets bits outside the chmod mask
+func (m *ModeConfig) CheckChmod(mode Mode) error {
+	if denied := mode &^ *m.ChmodMask; denied != 0 {
+		return fmt.Errorf("mode %s is not allowed, permitted bits are %s", mode, *m.ChmodMask)
+	}
+	return nil
+}
+
+// ModesFor returns the mode settings that apply to a data-relative path,
+// taking them from the mount the path is in
+func (u *User) ModesFor(requestPath string) ModeConfig {
+	if mount, ok := u.FindMount(requestPath); ok {
+		return mount.Modes
+	}
+	return u.Modes
+}
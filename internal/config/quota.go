@@ -0,0 +1,25 @@
+package config
+
+import "fmt"
+
+// Quota limits the storage a user or group may consume, zero means unlimited
+type Quota struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+	MaxFiles int64 `yaml:"max_files"`
+}
+
+// IsLimited returns true if the quota sets any limit
+func (q Quota) IsLimited() bool {
+	return q.MaxBytes > 0 || q.MaxFiles > 0
+}
+
+// validate checks that the quota limits are not negative
+func (q Quota) validate() error {
+	if q.MaxBytes < 0 {
+		return fmt.Errorf("max_bytes cannot be negative")
+	}
+	if q.MaxFiles < 0 {
+		return fmt.Errorf("max_files cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ReloadConfig controls reloading the configuration file while running.
+// SIGHUP always reloads it.
+type ReloadConfig struct {
+	Watch    bool          `yaml:"watch"`    // reload when the file changes
+	Interval time.Duration `yaml:"interval"` // how often the file is checked, default 5s
+}
+
+// validate checks the reload settings
+func (r *ReloadConfig) validate() error {
+	if r.Watch && r.Interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	return nil
+}
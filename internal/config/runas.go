@@ -0,0 +1,29 @@
+package config
+
+import "fmt"
+
+// RunAsConfig drops root privileges once the listeners are bound, so that
+// client input is never handled as root
+type RunAsConfig struct {
+	User  string `yaml:"user"`  // name or uid to run as, empty keeps the starting user
+	Group string `yaml:"group"` // name or gid, defaults to the user's primary group
+
+	// Landlock confines file access to the data, state and log directories
+	// and mounts, and read access to /etc (Linux 5.19 or later, built with
+	// CGO_ENABLED=0). Nothing can be executed, so it excludes user_helpers.
+	Landlock bool `yaml:"landlock"`
+}
+
+// validate checks the run_as settings against the other security settings
+func (r *RunAsConfig) validate(security SecurityConfig) error {
+	if r.Group != "" && r.User == "" {
+		return fmt.Errorf("a group needs a user")
+	}
+	if r.User != "" && security.UserHelpers {
+		return fmt.Errorf("user_helpers needs root to switch to each user, it cannot be combined with run_as")
+	}
+	if r.Landlock && security.UserHelpers {
+		return fmt.Errorf("user_helpers starts helper processes, which landlock does not allow")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// SecurityConfig contains settings protecting the login process and the
+// data directory
+type SecurityConfig struct {
+	BruteForce BruteForceConfig `yaml:"brute_force"`
+	Symlinks   string           `yaml:"symlinks"` // within_root (default), deny or follow
+
+	// UserHelpers runs each user's local file operations in a helper process
+	// with the user's uid and gids, so kernel permissions apply as well
+	UserHelpers bool `yaml:"user_helpers"`
+
+	// MaxRecursiveEntries caps how many entries a recursive delete or
+	// listing may touch, zero means unlimited
+	MaxRecursiveEntries int `yaml:"max_recursive_entries"`
+}
+
+// Symlink policies for local storage
+const (
+	SymlinksWithinRoot = "within_root" // follow links that stay inside the storage root
+	SymlinksDeny       = "deny"        // refuse any path that goes through a link
+	SymlinksFollow     = "follow"      // follow links anywhere on the host
+)
+
+// validateSymlinks checks the symlink policy name
+func validateSymlinks(policy string) error {
+	switch policy {
+	case "", SymlinksWithinRoot, SymlinksDeny, SymlinksFollow:
+		return nil
+	default:
+		return fmt.Errorf("unknown symlink policy '%s', must be one of: within_root, deny, follow", policy)
+	}
+}
+
+// AccessConfig restricts which client networks may connect. Deny rules
+// win over allow rules, and a non-empty allow list rejects everything else.
+type AccessConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// validate checks that all rules are valid CIDRs or IPs
+func (a *AccessConfig) validate() error {
+	if _, err := ParseCIDRs(a.Allow); err != nil {
+		return fmt.Errorf("invalid allow list: %w", err)
+	}
+	if _, err := ParseCIDRs(a.Deny); err != nil {
+		return fmt.Errorf("invalid deny list: %w", err)
+	}
+	return nil
+}
+
+// BruteForceConfig controls failed-login tracking, lockouts and IP bans
+type BruteForceConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Window          time.Duration `yaml:"window"`            // how long failures are remembered
+	MaxAttempts     int           `yaml:"max_attempts"`      // failures per IP before a ban
+	BanDuration     time.Duration `yaml:"ban_duration"`      // first ban, doubled for each repeat offence
+	MaxBanDuration  time.Duration `yaml:"max_ban_duration"`  // upper bound for escalating bans
+	UserMaxAttempts int           `yaml:"user_max_attempts"` // failures per username before a lockout
+	LockoutDuration time.Duration `yaml:"lockout_duration"`
+	Delay           time.Duration `yaml:"delay"`     // added to the response for every recent failure
+	MaxDelay        time.Duration `yaml:"max_delay"` // upper bound for the response delay
+	Exempt          []string      `yaml:"exempt"`    // trusted CIDRs that are never delayed or banned
+}
+
+// validate checks the brute-force settings
+func (b *BruteForceConfig) validate() error {
+	if !b.Enabled {
+		return nil
+	}
+	if b.Window <= 0 {
+		return fmt.Errorf("window must be positive")
+	}
+	if b.MaxAttempts < 0 || b.UserMaxAttempts < 0 {
+		return fmt.Errorf("max attempts cannot be negative")
+	}
+	if b.MaxBanDuration < b.BanDuration {
+		return fmt.Errorf("max_ban_duration cannot be shorter than ban_duration")
+	}
+	if _, err := ParseCIDRs(b.Exempt); err != nil {
+		return fmt.Errorf("invalid exempt list: %w", err)
+	}
+	return nil
+}
+
+// ParseCIDRs parses a list of CIDRs, accepting bare IPs as single hosts
+func ParseCIDRs(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s'", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ShutdownConfig controls how sessions are ended when the server stops
+type ShutdownConfig struct {
+	// DrainTimeout is how long transfers in progress may take to finish
+	// before their sessions are closed, default 30s. Zero closes them at once.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+// validate checks the shutdown settings
+func (s *ShutdownConfig) validate() error {
+	if s.DrainTimeout < 0 {
+		return fmt.Errorf("drain_timeout cannot be negative")
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import "fmt"
+
+// Storage driver names
+const (
+	StorageLocal  = "local"
+	StorageMemory = "memory"
+	StorageS3     = "s3"
+)
+
+// StorageConfig selects the storage backend of a user or mount
+type StorageConfig struct {
+	Driver string   `yaml:"driver"` // local (default), memory or s3
+	S3     S3Config `yaml:"s3"`
+}
+
+// S3Config contains settings for S3-compatible object storage
+type S3Config struct {
+	Endpoint  string `yaml:"endpoint"` // e.g. https://s3.amazonaws.com or http://localhost:9000
+	Region    string `yaml:"region"`
+	Bucket    string `yaml:"bucket"`
+	Prefix    string `yaml:"prefix"` // key prefix all objects live under, user homes below it
+	AccessKey string `yaml:"access_key"`
+	SecretKey string `yaml:"secret_key"`
+}
+
+// IsLocal returns true if the storage is the local disk
+func (s *StorageConfig) IsLocal() bool {
+	return s.Driver == "" || s.Driver == StorageLocal
+}
+
+// validate checks the driver name and its settings
+func (s *StorageConfig) validate() error {
+	switch s.Driver {
+	case "", StorageLocal, StorageMemory:
+		return nil
+	case StorageS3:
+		if s.S3.Endpoint == "" || s.S3.Bucket == "" {
+			return fmt.Errorf("s3 storage needs an endpoint and a bucket")
+		}
+		if s.S3.Region == "" {
+			s.S3.Region = "us-east-1"
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown storage driver '%s', must be one of: local, memory, s3", s.Driver)
+	}
+}
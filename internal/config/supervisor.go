@@ -0,0 +1,36 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// SupervisorConfig controls how the services are started and kept running
+type SupervisorConfig struct {
+	StartFailure string        `yaml:"start_failure"` // fail (default), continue or retry
+	Restart      bool          `yaml:"restart"`       // restart services that stop on their own
+	MinBackoff   time.Duration `yaml:"min_backoff"`   // first delay before a restart, default 1s
+	MaxBackoff   time.Duration `yaml:"max_backoff"`   // longest delay, default 1m
+}
+
+// Policies for services that cannot start
+const (
+	StartFail     = "fail"     // stop the server with an error
+	StartContinue = "continue" // run without the service
+	StartRetry    = "retry"    // run without the service, retrying it with backoff
+)
+
+// validate checks the supervisor settings
+func (s *SupervisorConfig) validate() error {
+	switch s.StartFailure {
+	case "":
+		s.StartFailure = StartFail
+	case StartFail, StartContinue, StartRetry:
+	default:
+		return fmt.Errorf("unknown start_failure policy '%s', must be one of: fail, continue, retry", s.StartFailure)
+	}
+	if s.MinBackoff <= 0 || s.MaxBackoff < s.MinBackoff {
+		return fmt.Errorf("min_backoff must be positive and no larger than max_backoff")
+	}
+	return nil
+}
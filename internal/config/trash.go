@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// TrashConfig makes deletions recoverable. Deleted files are moved into a
+// .trash directory at the root of their storage and purged after the
+// retention period.
+type TrashConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Retention     time.Duration `yaml:"retention"`      // how long deleted files are kept
+	PurgeInterval time.Duration `yaml:"purge_interval"` // how often expired files are purged
+}
+
+// validate checks the trash settings
+func (t *TrashConfig) validate() error {
+	if t.Retention <= 0 {
+		return fmt.Errorf("retention must be positive")
+	}
+	if t.PurgeInterval <= 0 {
+		return fmt.Errorf("purge_interval must be positive")
+	}
+	return nil
+}
+
+// UsesTrash returns true if the user's deletions go to the trash
+func (u *User) UsesTrash() bool {
+	return u.Trash != nil && *u.Trash
+}
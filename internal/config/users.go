@@ -57,6 +57,7 @@ func ParseUserString(userStr string) (map[string]*User, error) {
 		}
 
 		users[username] = &User{
+			Name:        username,
 			Pass:        password,
 			UID:         uid,
 			Path:        path,
@@ -67,6 +68,28 @@ func ParseUserString(userStr string) (map[string]*User, error) {
 	return users, nil
 }
 
+// validateIDs checks the user's numeric ids
+func (u *User) validateIDs() error {
+	if u.UID < 0 || u.GroupID() < 0 {
+		return fmt.Errorf("uid and gid cannot be negative")
+	}
+	for _, gid := range u.ExtraGIDs {
+		if gid < 0 {
+			return fmt.Errorf("supplementary gid %d cannot be negative", gid)
+		}
+	}
+	return nil
+}
+
+// GroupID returns the user's primary gid, which defaults to the uid when
+// unset. An explicit gid of 0 is kept.
+func (u *User) GroupID() int {
+	if u.GID == nil {
+		return u.UID
+	}
+	return *u.GID
+}
+
 // IsReadOnly returns true if the user has read-only permissions
 func (u *User) IsReadOnly() bool {
 	return u.Permissions == "ro"
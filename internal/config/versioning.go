@@ -0,0 +1,65 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// VersioningConfig keeps the previous content of overwritten files as
+// numbered versions in a hidden .versions directory next to them. Unset
+// fields are inherited: mounts from their user, users from the global
+// settings.
+type VersioningConfig struct {
+	Enabled     *bool          `yaml:"enabled"`      // default false
+	MaxVersions *int           `yaml:"max_versions"` // versions kept per file, zero means unlimited, default 10
+	MaxAge      *time.Duration `yaml:"max_age"`      // how long a version is kept once replaced, zero means forever
+}
+
+// defaultVersioning is used for settings left unset everywhere
+var defaultVersioning = VersioningConfig{
+	Enabled:     boolPtr(false),
+	MaxVersions: intPtr(10),
+	MaxAge:      durationPtr(0),
+}
+
+func boolPtr(b bool) *bool                       { return &b }
+func intPtr(i int) *int                          { return &i }
+func durationPtr(d time.Duration) *time.Duration { return &d }
+
+// inherit fills the unset fields from parent
+func (v *VersioningConfig) inherit(parent VersioningConfig) {
+	if v.Enabled == nil {
+		v.Enabled = parent.Enabled
+	}
+	if v.MaxVersions == nil {
+		v.MaxVersions = parent.MaxVersions
+	}
+	if v.MaxAge == nil {
+		v.MaxAge = parent.MaxAge
+	}
+}
+
+// validate checks the versioning settings once they are inherited
+func (v *VersioningConfig) validate() error {
+	if *v.MaxVersions < 0 {
+		return fmt.Errorf("max_versions cannot be negative")
+	}
+	if *v.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative")
+	}
+	return nil
+}
+
+// IsEnabled returns true if overwritten files keep their old versions
+func (v *VersioningConfig) IsEnabled() bool {
+	return v.Enabled != nil && *v.Enabled
+}
+
+// VersioningFor returns the versioning settings that apply to a
+// data-relative path, taking them from the mount the path is in
+func (u *User) VersioningFor(requestPath string) VersioningConfig {
+	if mount, ok := u.FindMount(requestPath); ok {
+		return mount.Versioning
+	}
+	return u.Versioning
+}
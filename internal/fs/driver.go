@@ -0,0 +1,115 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// Driver is a storage backend underneath FileSystem. Names are slash
+// separated, cleaned and relative to the driver root, which is "/".
+// Missing files are reported with errors matching os.ErrNotExist.
+type Driver interface {
+	// Stat returns information about a file or directory
+	Stat(name string) (os.FileInfo, error)
+	// List returns the entries of a directory
+	List(name string) ([]os.FileInfo, error)
+	// Open opens a file for reading starting at offset. A negative length
+	// reads until the end of the file.
+	Open(name string, offset, length int64) (io.ReadCloser, error)
+	// Create creates or truncates a file for writing
+	Create(name string) (io.WriteCloser, error)
+	// Rename moves a file or directory
+	Rename(from, to string) error
+	// Remove removes a file or an empty directory
+	Remove(name string) error
+	// Mkdir creates a directory along with any missing parents
+	Mkdir(name string) error
+}
+
+// chowner is implemented by drivers whose files have owners
+type chowner interface {
+	// Chown changes the owner of a file or directory
+	Chown(name string, uid, gid int) error
+}
+
+// chmoder is implemented by drivers whose files have permission modes
+type chmoder interface {
+	// Chmod changes the mode of a file or directory
+	Chmod(name string, mode os.FileMode) error
+}
+
+// NewDriver creates the driver selected by a storage configuration. root is
+// the local directory used by the local driver and symlinks its policy.
+func NewDriver(cfg config.StorageConfig, root, symlinks string) (Driver, error) {
+	switch cfg.Driver {
+	case "", config.StorageLocal:
+		return NewLocalDriver(root, symlinks), nil
+	case config.StorageMemory:
+		return NewMemoryDriver(), nil
+	case config.StorageS3:
+		return NewS3Driver(cfg.S3), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver '%s'", cfg.Driver)
+	}
+}
+
+// fileInfo is a plain os.FileInfo for drivers without native file info
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() any           { return nil }
+
+// newFileInfo creates file info for a regular file or directory
+func newFileInfo(name string, size int64, modTime time.Time, isDir bool) *fileInfo {
+	mode := os.FileMode(0644)
+	if isDir {
+		mode = os.ModeDir | 0755
+	}
+	return &fileInfo{name: name, size: size, mode: mode, modTime: modTime}
+}
+
+// walk calls fn for every file and directory below name, depth first.
+// Symlinked directories are not descended into, and neither are
+// directories for which fn returns fs.SkipDir.
+func walk(driver Driver, name string, fn func(name string, info os.FileInfo) error) error {
+	entries, err := driver.List(name)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range entries {
+		child := path.Join(name, info.Name())
+		if err := fn(child, info); err != nil {
+			if err == fs.SkipDir && info.IsDir() {
+				continue
+			}
+			return err
+		}
+		if info.IsDir() && info.Mode()&os.ModeSymlink == 0 {
+			if err := walk(driver, child, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// notExist builds an error for a missing name that matches os.ErrNotExist
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,153 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+// testDriver runs the behaviour every Driver must share against d, which
+// must start out empty
+func testDriver(t *testing.T, d Driver) {
+	t.Helper()
+
+	write := func(name, content string) {
+		t.Helper()
+		w, err := d.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		if _, err := io.WriteString(w, content); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close %s: %v", name, err)
+		}
+	}
+	read := func(name string, offset, length int64) string {
+		t.Helper()
+		r, err := d.Open(name, offset, length)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer r.Close()
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		return string(content)
+	}
+	list := func(name string) []string {
+		t.Helper()
+		infos, err := d.List(name)
+		if err != nil {
+			t.Fatalf("list %s: %v", name, err)
+		}
+		names := make([]string, 0, len(infos))
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		slices.Sort(names)
+		return names
+	}
+	missing := func(name string) {
+		t.Helper()
+		if _, err := d.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("stat %s: got %v, want a missing file", name, err)
+		}
+	}
+
+	missing("/missing.txt")
+	if _, err := d.Open("/missing.txt", 0, -1); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("open of a missing file: got %v, want a missing file", err)
+	}
+
+	// Files and directories
+	if err := d.Mkdir("/dir/sub"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	write("/dir/sub/file.txt", "hello world")
+	write("/dir/top.txt", "top")
+
+	info, err := d.Stat("/dir/sub/file.txt")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.IsDir() || info.Size() != 11 || info.Name() != "file.txt" {
+		t.Errorf("stat of a file: got %s, dir %v, size %d", info.Name(), info.IsDir(), info.Size())
+	}
+	if info, err := d.Stat("/dir"); err != nil || !info.IsDir() {
+		t.Errorf("stat of a directory: got %v, %v", info, err)
+	}
+	if got, want := list("/dir"), []string{"sub", "top.txt"}; !slices.Equal(got, want) {
+		t.Errorf("list: got %v, want %v", got, want)
+	}
+	if got := list("/"); !slices.Contains(got, "dir") {
+		t.Errorf("list of the root: got %v, want dir in it", got)
+	}
+
+	// Ranges
+	for _, tc := range []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, "hello world"},
+		{6, -1, "world"},
+		{0, 5, "hello"},
+		{6, 3, "wor"},
+		{11, -1, ""},
+	} {
+		if got := read("/dir/sub/file.txt", tc.offset, tc.length); got != tc.want {
+			t.Errorf("read at %d for %d: got %q, want %q", tc.offset, tc.length, got, tc.want)
+		}
+	}
+
+	// Overwriting replaces the whole content
+	write("/dir/sub/file.txt", "bye")
+	if got := read("/dir/sub/file.txt", 0, -1); got != "bye" {
+		t.Errorf("read after overwrite: got %q", got)
+	}
+
+	// Empty directories are kept
+	if err := d.Mkdir("/empty"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if info, err := d.Stat("/empty"); err != nil || !info.IsDir() {
+		t.Errorf("stat of an empty directory: got %v, %v", info, err)
+	}
+	if got := list("/empty"); len(got) != 0 {
+		t.Errorf("list of an empty directory: got %v", got)
+	}
+
+	// Renaming files and directories with their content
+	if err := d.Rename("/dir/top.txt", "/dir/sub/moved.txt"); err != nil {
+		t.Fatalf("rename of a file: %v", err)
+	}
+	missing("/dir/top.txt")
+	if got := read("/dir/sub/moved.txt", 0, -1); got != "top" {
+		t.Errorf("read after rename: got %q", got)
+	}
+	if err := d.Rename("/dir/sub", "/renamed"); err != nil {
+		t.Fatalf("rename of a directory: %v", err)
+	}
+	missing("/dir/sub/file.txt")
+	if got, want := list("/renamed"), []string{"file.txt", "moved.txt"}; !slices.Equal(got, want) {
+		t.Errorf("list after rename: got %v, want %v", got, want)
+	}
+
+	// Only empty directories can be removed
+	if err := d.Remove("/renamed"); err == nil {
+		t.Errorf("remove of a directory with files succeeded")
+	}
+	for _, name := range []string{"/renamed/file.txt", "/renamed/moved.txt", "/renamed", "/empty"} {
+		if err := d.Remove(name); err != nil {
+			t.Errorf("remove %s: %v", name, err)
+		}
+		missing(name)
+	}
+	if err := d.Remove("/missing.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("remove of a missing file: got %v, want a missing file", err)
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Merith-TK/ftp-aio/internal/auth"
@@ -23,16 +25,40 @@ type FileInfo struct {
 
 // FileSystem provides file system operations with user isolation
 type FileSystem struct {
-	dataDir string
-	auth    *auth.Authenticator
+	config   *config.Config
+	dataDir  string
+	auth     *auth.Authenticator
+	quota    *QuotaTracker
+	symlinks string // symlink policy of local storage
+	helpers  bool   // run local storage operations in per-user helpers
+	chown    bool   // hand new files to their user, needs root
+	hashes   hashCache
+	locks    lockManager
+
+	// drivers caches storage backends so in-memory and remote state is shared
+	drivers      map[string]Driver
+	driversMutex sync.Mutex
 }
 
 // NewFileSystem creates a new file system instance
-func NewFileSystem(dataDir string, authenticator *auth.Authenticator) *FileSystem {
-	return &FileSystem{
-		dataDir: dataDir,
-		auth:    authenticator,
+func NewFileSystem(cfg *config.Config, authenticator *auth.Authenticator) (*FileSystem, error) {
+	fs := &FileSystem{
+		config:   cfg,
+		dataDir:  cfg.Data,
+		auth:     authenticator,
+		symlinks: cfg.Security.Symlinks,
+		helpers:  cfg.Security.UserHelpers,
+		chown:    os.Geteuid() == 0 && !cfg.Security.UserHelpers && cfg.RunAs.User == "", // root is given up with run_as
+		drivers:  make(map[string]Driver),
 	}
+
+	quota, err := NewQuotaTracker(cfg, fs.scanUsage)
+	if err != nil {
+		return nil, err
+	}
+	fs.quota = quota
+
+	return fs, nil
 }
 
 // ListDirectory lists files in a directory for a given user
@@ -42,28 +68,52 @@ func (fs *FileSystem) ListDirectory(user *config.User, path string) ([]FileInfo,
 		return nil, err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolve(user, path)
+	if err != nil {
+		return nil, err
+	}
 
 	// Read directory
-	entries, err := os.ReadDir(fullPath)
+	entries, err := driver.List(name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list directory: %w", err)
 	}
 
+	// Mount points shadow any real entry with the same name
+	mounts := make(map[string]config.Mount)
+	for _, mount := range user.MountsIn(path) {
+		mounts[filepath.Base(mount.Path)] = mount
+	}
+
 	var files []FileInfo
-	for _, entry := range entries {
-		info, err := entry.Info()
+	for _, info := range entries {
+		if _, isMount := mounts[info.Name()]; isMount {
+			continue
+		}
+		// Hide uploads that are still in progress, versions and the trash
+		if IsUploadTemp(info.Name()) || info.Name() == versionsDir || isTrashName(cleanName(name+"/"+info.Name())) {
+			continue
+		}
+		files = append(files, toFileInfo(info))
+	}
+
+	// Mounts always appear as directories
+	for name, mount := range mounts {
+		driver, err := fs.mountDriver(user, mount)
 		if err != nil {
-			continue // Skip files we can't stat
+			continue
+		}
+		info, err := driver.Stat("/")
+		if err != nil {
+			continue // Skip mounts whose source is gone
 		}
 
 		files = append(files, FileInfo{
-			Name:    info.Name(),
-			Size:    info.Size(),
-			Mode:    info.Mode(),
+			Name:    name,
+			Mode:    info.Mode() | os.ModeDir,
 			ModTime: info.ModTime(),
-			IsDir:   info.IsDir(),
+			IsDir:   true,
 		})
 	}
 
@@ -72,45 +122,131 @@ func (fs *FileSystem) ListDirectory(user *config.User, path string) ([]FileInfo,
 
 // ReadFile reads a file for a given user
 func (fs *FileSystem) ReadFile(user *config.User, path string) (io.ReadCloser, error) {
+	return fs.ReadFileRange(user, path, 0, -1)
+}
+
+// ReadFileRange reads length bytes of a file starting at offset for a given
+// user. A negative length reads until the end of the file.
+func (fs *FileSystem) ReadFileRange(user *config.User, path string, offset, length int64) (io.ReadCloser, error) {
 	// Check read permission
 	if err := auth.CheckPermission(user, fs.dataDir, path, auth.PermissionRead); err != nil {
 		return nil, err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolve(user, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Readers share the file, but not with an upload in progress
+	release, err := fs.lock(driver, name, false)
+	if err != nil {
+		return nil, err
+	}
 
 	// Open file
-	file, err := os.Open(fullPath)
+	file, err := driver.Open(name, offset, length)
 	if err != nil {
+		release()
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 
-	return file, nil
+	return &lockedReader{ReadCloser: file, release: release}, nil
 }
 
 // WriteFile writes a file for a given user
-func (fs *FileSystem) WriteFile(user *config.User, path string) (io.WriteCloser, error) {
+func (fs *FileSystem) WriteFile(user *config.User, path string, opts WriteOptions) (FileWriter, error) {
 	// Check write permission
 	if err := auth.CheckPermission(user, fs.dataDir, path, auth.PermissionWrite); err != nil {
 		return nil, err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Uploads have the file to themselves until they complete. Under the
+	// snapshot policy they always go through a temp file, so readers keep
+	// seeing the old version meanwhile.
+	release, err := fs.lock(driver, name, true)
+	if err != nil {
+		return nil, err
+	}
+	if fs.config.Locking.Conflict == config.LockSnapshot {
+		opts.Atomic = true
+	}
+
+	u, err := fs.createUpload(user, driver, name, path, opts)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	u.release = release
 
+	return u, nil
+}
+
+// createUpload creates the file or temp file an upload writes to
+func (fs *FileSystem) createUpload(user *config.User, driver Driver, name, path string, opts WriteOptions) (*upload, error) {
 	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+	modes := user.ModesFor(path)
+	if err := fs.mkdir(user, driver, filepath.Dir(name), modes.NewDirMode()); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Create file
-	file, err := os.Create(fullPath)
+	u := &upload{fs: fs, user: user, driver: driver, name: name, path: path}
+	var err error
+
+	// New files count against the file quota
+	mode := modes.NewFileMode()
+	if info, err := driver.Stat(name); err == nil && info.Mode().IsRegular() {
+		u.oldSize, u.existed = info.Size(), true
+		mode = info.Mode() // Replacing a file keeps its mode
+	} else if err := fs.quota.Reserve(user, 0, 1); err != nil {
+		return nil, err
+	}
+
+	// Keep the old content as a version instead of truncating it. Atomic
+	// uploads do this when they complete.
+	versioning := user.VersioningFor(path)
+	u.versioned = u.existed && versioning.IsEnabled()
+	var version string
+	if u.versioned && !opts.Atomic {
+		if version, err = fs.keepVersion(user, driver, name, path); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create the file, or a hidden temp file for atomic uploads
+	target := name
+	if opts.Atomic {
+		u.temp = uploadTempName(name)
+		target = u.temp
+	}
+	u.file, err = driver.Create(target)
+	if err == nil && (!u.existed || opts.Atomic || u.versioned) {
+		if err = fs.setAttributes(user, driver, target, mode); err != nil {
+			u.file.Close()
+			driver.Remove(target)
+		}
+	}
 	if err != nil {
+		fs.restoreVersion(user, driver, version, name)
+		if !u.existed {
+			fs.quota.Release(user, 0, 1)
+		}
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
 
-	return file, nil
+	// Overwriting in place truncates the old content right away
+	if !opts.Atomic {
+		fs.quota.Release(user, u.oldSize, 0)
+	}
+
+	return u, nil
 }
 
 // DeleteFile deletes a file for a given user
@@ -120,14 +256,44 @@ func (fs *FileSystem) DeleteFile(user *config.User, path string) error {
 		return err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	if fs.isMountPoint(user, path) {
+		return fmt.Errorf("cannot delete mount point %s", path)
+	}
+
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return err
+	}
+
+	// Wait for transfers of the file to finish
+	release, err := fs.lock(driver, name, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	info, err := driver.Stat(name)
+	if err != nil {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	// Keep the file around for a while if the user has a trash
+	if user.UsesTrash() && !info.IsDir() {
+		return fs.moveToTrash(user, driver, name, path, info)
+	}
 
 	// Delete file
-	if err := os.Remove(fullPath); err != nil {
+	if err := driver.Remove(name); err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
 
+	// Return the freed space to the user's quotas
+	if info.Mode().IsRegular() {
+		fs.quota.Release(user, info.Size(), 1)
+		fs.quota.Save()
+	}
+
 	return nil
 }
 
@@ -138,11 +304,15 @@ func (fs *FileSystem) CreateDirectory(user *config.User, path string) error {
 		return err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return err
+	}
 
 	// Create directory
-	if err := os.MkdirAll(fullPath, 0755); err != nil {
+	modes := user.ModesFor(path)
+	if err := fs.mkdir(user, driver, name, modes.NewDirMode()); err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
@@ -156,17 +326,198 @@ func (fs *FileSystem) RemoveDirectory(user *config.User, path string) error {
 		return err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	if fs.isMountPoint(user, path) {
+		return fmt.Errorf("cannot remove mount point %s", path)
+	}
+
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return err
+	}
+
+	entries, listErr := driver.List(name)
+	onlyVersions := listErr == nil && len(entries) == 1 && entries[0].Name() == versionsDir
+
+	// Keep the directory around for a while if the user has a trash. It
+	// must be empty all the same, but for old versions, which go with it.
+	if user.UsesTrash() {
+		info, err := driver.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to remove directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", path)
+		}
+		if listErr == nil && len(entries) > 0 && !onlyVersions {
+			return fmt.Errorf("failed to remove directory: directory not empty")
+		}
+		return fs.moveToTrash(user, driver, name, path, info)
+	}
+
+	// Old versions of the files that were in the directory go with it
+	if onlyVersions {
+		versions := cleanName(name + "/" + versionsDir)
+		usage := treeUsage(driver, versions)
+		if err := removeAll(driver, versions); err != nil {
+			return fmt.Errorf("failed to remove directory: %w", err)
+		}
+		fs.quota.Release(user, usage.Bytes, usage.Files)
+		fs.quota.Save()
+	}
 
 	// Remove directory
-	if err := os.Remove(fullPath); err != nil {
+	if err := driver.Remove(name); err != nil {
 		return fmt.Errorf("failed to remove directory: %w", err)
 	}
 
 	return nil
 }
 
+// Rename moves a file or directory for a given user. Both paths must live
+// on the same storage backend.
+func (fs *FileSystem) Rename(user *config.User, from, to string) error {
+	// Moving needs delete rights on the source and write rights on the target
+	if err := auth.CheckPermission(user, fs.dataDir, from, auth.PermissionDelete); err != nil {
+		return err
+	}
+	if err := auth.CheckPermission(user, fs.dataDir, to, auth.PermissionWrite); err != nil {
+		return err
+	}
+
+	if fs.isMountPoint(user, from) || fs.isMountPoint(user, to) {
+		return fmt.Errorf("cannot rename mount points")
+	}
+
+	fromDriver, fromName, err := fs.resolveWrite(user, from)
+	if err != nil {
+		return err
+	}
+	toDriver, toName, err := fs.resolveWrite(user, to)
+	if err != nil {
+		return err
+	}
+	if fromDriver != toDriver {
+		return fmt.Errorf("cannot rename across storage backends")
+	}
+
+	// Wait for transfers of the source and of a target being replaced
+	release, err := fs.lockAll(fromDriver, []string{fromName, toName})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// A file replaced by the move is versioned like an overwrite, and
+	// stops counting against the quotas once it is gone. Another name of
+	// the file being moved is not replaced.
+	var replaced os.FileInfo
+	sameFile := lockKey(fromDriver, fromName) == lockKey(fromDriver, toName)
+	if info, err := fromDriver.Stat(toName); err == nil && info.Mode().IsRegular() && !sameFile {
+		replaced = info
+	}
+	movesFile := false
+	if info, err := fromDriver.Stat(fromName); err == nil && info.Mode().IsRegular() {
+		movesFile = !sameFile
+	}
+
+	var version string
+	versioning := user.VersioningFor(to)
+	if replaced != nil && versioning.IsEnabled() {
+		if version, err = fs.keepVersion(user, fromDriver, toName, to); err != nil {
+			return err
+		}
+	}
+
+	if err := fromDriver.Rename(fromName, toName); err != nil {
+		fs.restoreVersion(user, fromDriver, version, toName)
+		return fmt.Errorf("failed to rename: %w", err)
+	}
+
+	if replaced != nil {
+		fs.quota.Release(user, replaced.Size(), 1)
+	}
+	// The versions of a file go with it. Those of a directory's files are
+	// inside it and move anyway.
+	if movesFile {
+		fs.moveVersions(user, fromDriver, fromName, toName, to)
+	}
+	fs.quota.Save()
+
+	return nil
+}
+
+// CopyFile copies a file on the server for a given user. The copy is
+// written like an atomic upload, so quotas, modes and versioning apply to
+// it and a failed copy leaves nothing behind.
+func (fs *FileSystem) CopyFile(user *config.User, from, to string) error {
+	if filepath.Clean("/"+from) == filepath.Clean("/"+to) {
+		return fmt.Errorf("cannot copy %s onto itself", from)
+	}
+
+	info, err := fs.GetFileInfo(user, from)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return fmt.Errorf("cannot copy directory %s", from)
+	}
+
+	reader, err := fs.ReadFile(user, from)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writer, err := fs.WriteFile(user, to, WriteOptions{Atomic: true})
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		writer.Abort()
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to copy: %w", err)
+	}
+
+	return nil
+}
+
+// Chmod changes the mode of a file or directory for a given user, limited
+// to the bits allowed by the chmod mask that applies to the path
+func (fs *FileSystem) Chmod(user *config.User, path string, mode config.Mode) error {
+	// Changing modes needs write rights
+	if err := auth.CheckPermission(user, fs.dataDir, path, auth.PermissionWrite); err != nil {
+		return err
+	}
+
+	modes := user.ModesFor(path)
+	if err := modes.CheckChmod(mode); err != nil {
+		return err
+	}
+
+	if fs.isMountPoint(user, path) {
+		return fmt.Errorf("cannot change the mode of mount point %s", path)
+	}
+
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return err
+	}
+
+	moded, ok := driver.(chmoder)
+	if !ok {
+		return fmt.Errorf("storage does not support file modes")
+	}
+	if err := moded.Chmod(name, mode.FileMode()); err != nil {
+		return fmt.Errorf("failed to change mode: %w", err)
+	}
+
+	return nil
+}
+
 // GetFileInfo gets information about a file or directory
 func (fs *FileSystem) GetFileInfo(user *config.User, path string) (*FileInfo, error) {
 	// Check read permission
@@ -174,38 +525,186 @@ func (fs *FileSystem) GetFileInfo(user *config.User, path string) (*FileInfo, er
 		return nil, err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolve(user, path)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get file info
-	info, err := os.Stat(fullPath)
+	info, err := driver.Stat(name)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	return &FileInfo{
-		Name:    info.Name(),
-		Size:    info.Size(),
-		Mode:    info.Mode(),
-		ModTime: info.ModTime(),
-		IsDir:   info.IsDir(),
-	}, nil
+	fileInfo := toFileInfo(info)
+	return &fileInfo, nil
 }
 
-// getFullPath converts a user-relative path to a full filesystem path
-func (fs *FileSystem) getFullPath(user *config.User, path string) string {
-	// Get user's root path
-	userRoot := auth.GetUserRootPath(user, fs.dataDir)
+// resolve maps a data-relative path to the storage backend holding it and
+// the name within that backend, following the user's mounts. The trash at
+// the root of each backend can't be reached this way.
+func (fs *FileSystem) resolve(user *config.User, path string) (Driver, string, error) {
+	driver, name, err := fs.backend(user, path)
+	if err == nil && isTrashName(name) {
+		return nil, "", notExist("open", path)
+	}
+	return driver, name, err
+}
 
+// backend maps a data-relative path to its storage backend and name
+func (fs *FileSystem) backend(user *config.User, path string) (Driver, string, error) {
 	// Clean the path
-	path = filepath.Clean(path)
-	if path == "." || path == "/" {
-		return userRoot
+	path = filepath.Clean("/" + path)
+
+	// Paths inside a mount resolve against the mount's backend
+	if mount, ok := user.FindMount(path); ok {
+		driver, err := fs.mountDriver(user, *mount)
+		return driver, cleanName(strings.TrimPrefix(path, mount.Path)), err
 	}
 
-	// Remove leading slash and join with user root
-	path = strings.TrimPrefix(path, "/")
-	return filepath.Join(userRoot, path)
+	// Every backend holds the user's home at its root, so nothing outside
+	// the home can be reached through it
+	home := filepath.Clean("/" + user.Path)
+	if home != "/" {
+		path = strings.TrimPrefix(path, home)
+	}
+
+	key, root, storage := "user:"+user.Name, "", user.Storage
+	switch {
+	case storage.IsLocal():
+		key, root = "home:"+home, user.GetFullPath(fs.dataDir)
+	case storage.Driver == config.StorageS3:
+		// Users may share a bucket and prefix, each home lives below it
+		storage.S3.Prefix = filepath.ToSlash(filepath.Join(storage.S3.Prefix, home))
+	}
+	driver, err := fs.driver(user, key, storage, root)
+	return driver, cleanName(path), err
+}
+
+// mountDriver returns the storage backend of a mount
+func (fs *FileSystem) mountDriver(user *config.User, mount config.Mount) (Driver, error) {
+	return fs.driver(user, "mount:"+mount.Source, mount.Storage, mount.Source)
+}
+
+// driver returns the cached backend for key, creating it on first use.
+// With user helpers enabled local storage gets one backend per user.
+func (fs *FileSystem) driver(user *config.User, key string, storage config.StorageConfig, root string) (Driver, error) {
+	helper := fs.helpers && storage.IsLocal()
+	if storage.Driver == config.StorageS3 {
+		key = "s3:" + storage.S3.Endpoint + "/" + storage.S3.Bucket + "/" + storage.S3.Prefix
+	} else if helper {
+		key = "helper:" + user.Name + ":" + root
+	}
+
+	fs.driversMutex.Lock()
+	defer fs.driversMutex.Unlock()
+
+	if driver, exists := fs.drivers[key]; exists {
+		return driver, nil
+	}
+
+	if helper {
+		driver := NewHelperDriver(root, fs.symlinks, user.UID, user.GroupID(), user.ExtraGIDs)
+		fs.drivers[key] = driver
+		return driver, nil
+	}
+
+	driver, err := NewDriver(storage, root, fs.symlinks)
+	if err != nil {
+		return nil, err
+	}
+	fs.drivers[key] = driver
+	return driver, nil
+}
+
+// mkdir creates a directory with any missing parents and gives the new
+// directories their owner and mode
+func (fs *FileSystem) mkdir(user *config.User, driver Driver, name string, mode os.FileMode) error {
+	var missing []string
+	if _, ok := driver.(chmoder); ok {
+		for dir := cleanName(name); ; dir = path.Dir(dir) {
+			if _, err := driver.Stat(dir); err == nil || dir == "/" {
+				break
+			}
+			missing = append(missing, dir)
+		}
+	}
+
+	if err := driver.Mkdir(name); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := fs.setAttributes(user, driver, missing[i], mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setAttributes gives a newly created file or directory its mode, and hands
+// it to user when running as root. Storage without owners or modes is left
+// alone. The owner goes first since chown clears the setgid bit.
+func (fs *FileSystem) setAttributes(user *config.User, driver Driver, name string, mode os.FileMode) error {
+	if owned, ok := driver.(chowner); ok && fs.chown {
+		if err := owned.Chown(name, user.UID, user.GroupID()); err != nil {
+			return fmt.Errorf("failed to change owner: %w", err)
+		}
+	}
+	if moded, ok := driver.(chmoder); ok {
+		if err := moded.Chmod(name, mode); err != nil {
+			return fmt.Errorf("failed to change mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// isMountPoint reports whether path is exactly one of the user's mount points
+func (fs *FileSystem) isMountPoint(user *config.User, path string) bool {
+	mount, ok := user.FindMount(path)
+	return ok && mount.Path == filepath.Clean("/"+path)
+}
+
+// scanUsage walks the user's home to compute its storage usage
+func (fs *FileSystem) scanUsage(user *config.User) Usage {
+	var usage Usage
+
+	driver, name, err := fs.resolve(user, user.Path)
+	if err != nil {
+		return usage
+	}
+
+	walk(driver, name, func(child string, info os.FileInfo) error {
+		// Trashed files don't count against quotas, old versions do
+		if isTrashName(child) {
+			return filepath.SkipDir
+		}
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+			usage.Files++
+		}
+		return nil
+	})
+
+	return usage
+}
+
+// treeUsage returns the size and number of the regular files below name
+func treeUsage(driver Driver, name string) Usage {
+	var usage Usage
+	walk(driver, name, func(_ string, info os.FileInfo) error {
+		if info.Mode().IsRegular() {
+			usage.Bytes += info.Size()
+			usage.Files++
+		}
+		return nil
+	})
+	return usage
+}
+
+// QuotaUsage returns the storage currently charged to a user
+func (fs *FileSystem) QuotaUsage(user *config.User) Usage {
+	return fs.quota.Usage(user)
 }
 
 // GetFileSize gets the size of a file for a given user
@@ -215,11 +714,14 @@ func (fs *FileSystem) GetFileSize(user *config.User, path string) (int64, error)
 		return 0, err
 	}
 
-	// Get the actual filesystem path
-	fullPath := fs.getFullPath(user, path)
+	// Get the storage backend and name
+	driver, name, err := fs.resolve(user, path)
+	if err != nil {
+		return 0, err
+	}
 
 	// Get file info
-	info, err := os.Stat(fullPath)
+	info, err := driver.Stat(name)
 	if err != nil {
 		return 0, err
 	}
@@ -231,3 +733,14 @@ func (fs *FileSystem) GetFileSize(user *config.User, path string) (int64, error)
 
 	return info.Size(), nil
 }
+
+// toFileInfo converts driver file info to FileInfo
+func toFileInfo(info os.FileInfo) FileInfo {
+	return FileInfo{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+		IsDir:   info.IsDir(),
+	}
+}
@@ -0,0 +1,145 @@
+package fs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// newTestFileSystem creates a file system over temporary data and state
+// directories with a single user, bob, whose home is /bob and whose quota
+// is tracked. configure may change the configuration before it is
+// validated.
+func newTestFileSystem(t *testing.T, configure func(cfg *config.Config)) (*FileSystem, *config.User) {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.Data, cfg.State = t.TempDir(), t.TempDir()
+	cfg.Services.FTP.Enabled = true
+	cfg.Users = map[string]*config.User{
+		"bob": {Pass: "x", Path: "/bob", Permissions: "rw", Quota: config.Quota{MaxBytes: 1 << 20}},
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFileSystem(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fsys, cfg.Users["bob"]
+}
+
+// writeFile writes content to path as user
+func writeFile(t *testing.T, fsys *FileSystem, user *config.User, path, content string) {
+	t.Helper()
+	w, err := fsys.WriteFile(user, path, WriteOptions{})
+	if err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	io.WriteString(w, content)
+	if err := w.Close(); err != nil {
+		t.Fatalf("close %s: %v", path, err)
+	}
+}
+
+// readFile returns the content of path as user
+func readFile(t *testing.T, fsys *FileSystem, user *config.User, path string) string {
+	t.Helper()
+	r, err := fsys.ReadFile(user, path)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read %s: %v", path, err)
+	}
+	return string(content)
+}
+
+// enableVersioning keeps overwritten files as versions
+func enableVersioning(cfg *config.Config) {
+	enabled := true
+	cfg.Versioning.Enabled = &enabled
+}
+
+func TestRenameOverFile(t *testing.T) {
+	for _, tc := range []struct {
+		what      string
+		configure func(cfg *config.Config)
+		want      Usage
+	}{
+		{"replaced file is gone", nil, Usage{Bytes: 5, Files: 1}},
+		{"replaced file is versioned", enableVersioning, Usage{Bytes: 12, Files: 2}},
+	} {
+		t.Run(tc.what, func(t *testing.T) {
+			fsys, bob := newTestFileSystem(t, tc.configure)
+			writeFile(t, fsys, bob, "/bob/a.txt", "aaaaa")
+			writeFile(t, fsys, bob, "/bob/b.txt", "bbbbbbb")
+
+			if err := fsys.Rename(bob, "/bob/a.txt", "/bob/b.txt"); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, fsys, bob, "/bob/b.txt"); got != "aaaaa" {
+				t.Errorf("renamed file: got %q", got)
+			}
+			if tc.configure != nil {
+				if got := readFile(t, fsys, bob, "/bob/.versions/b.txt@v1"); got != "bbbbbbb" {
+					t.Errorf("version of the replaced file: got %q", got)
+				}
+			}
+			if got := fsys.QuotaUsage(bob); got != tc.want {
+				t.Errorf("usage: got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRenameMovesVersions(t *testing.T) {
+	fsys, bob := newTestFileSystem(t, enableVersioning)
+	writeFile(t, fsys, bob, "/bob/a.txt", "a1")
+	writeFile(t, fsys, bob, "/bob/a.txt", "a2")
+	writeFile(t, fsys, bob, "/bob/b.txt", "b1")
+	writeFile(t, fsys, bob, "/bob/b.txt", "b2")
+	before := fsys.QuotaUsage(bob)
+
+	if err := fsys.CreateDirectory(bob, "/bob/sub"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.Rename(bob, "/bob/b.txt", "/bob/sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, fsys, bob, "/bob/sub/.versions/c.txt@v1"); got != "b1" {
+		t.Errorf("moved version: got %q", got)
+	}
+	if _, err := fsys.GetFileInfo(bob, "/bob/.versions/b.txt@v1"); err == nil {
+		t.Errorf("version is still under the old name")
+	}
+	if got := fsys.QuotaUsage(bob); got != before {
+		t.Errorf("usage after moving versions: got %+v, want %+v", got, before)
+	}
+
+	// Moved onto a file, its versions follow those of the replaced file
+	if err := fsys.Rename(bob, "/bob/a.txt", "/bob/sub/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"/bob/sub/c.txt":              "a2",
+		"/bob/sub/.versions/c.txt@v1": "b1",
+		"/bob/sub/.versions/c.txt@v2": "b2",
+		"/bob/sub/.versions/c.txt@v3": "a1",
+	} {
+		if got := readFile(t, fsys, bob, name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+	if _, err := fsys.GetFileInfo(bob, "/bob/.versions"); err == nil {
+		t.Errorf("empty versions directory was left behind")
+	}
+	if got := fsys.QuotaUsage(bob); got != before {
+		t.Errorf("usage after replacing a file: got %+v, want %+v", got, before)
+	}
+}
@@ -0,0 +1,178 @@
+package fs
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/auth"
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+)
+
+// Hash algorithms supported by HashFile, named as in the FTP HASH draft
+const (
+	HashMD5    = "MD5"
+	HashSHA1   = "SHA-1"
+	HashSHA256 = "SHA-256"
+	HashCRC32  = "CRC32"
+)
+
+// HashAlgorithms lists the supported hash algorithms
+var HashAlgorithms = []string{HashSHA1, HashSHA256, HashMD5, HashCRC32}
+
+// hashCacheMinSize is the smallest file whose hashes are cached, smaller
+// files are cheap enough to hash again
+const hashCacheMinSize = 1 << 20
+
+// hashCacheMaxEntries bounds the memory used by the hash cache
+const hashCacheMaxEntries = 10000
+
+// ParseHashAlgorithm returns the canonical name of a hash algorithm,
+// ignoring case and the dash in the SHA names
+func ParseHashAlgorithm(name string) (string, error) {
+	for _, algorithm := range HashAlgorithms {
+		if strings.EqualFold(name, algorithm) || strings.EqualFold(name, strings.ReplaceAll(algorithm, "-", "")) {
+			return algorithm, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported hash algorithm '%s'", name)
+}
+
+// newHash creates a hash for a canonical algorithm name
+func newHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case HashMD5:
+		return md5.New()
+	case HashSHA1:
+		return sha1.New()
+	case HashCRC32:
+		return crc32.NewIEEE()
+	default:
+		return sha256.New()
+	}
+}
+
+// hashKey identifies a file and algorithm in the hash cache
+type hashKey struct {
+	driver    Driver
+	name      string
+	algorithm string
+}
+
+// hashEntry is a cached hash, valid while the file keeps its size and
+// modification time
+type hashEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// hashCache remembers the hashes of large files
+type hashCache struct {
+	mutex   sync.Mutex
+	entries map[hashKey]hashEntry
+}
+
+// get returns a cached hash if the file hasn't changed since
+func (c *hashCache) get(key hashKey, size int64, modTime time.Time) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || entry.size != size || !entry.modTime.Equal(modTime) {
+		return "", false
+	}
+	return entry.sum, true
+}
+
+// put caches a hash, dropping an arbitrary entry when the cache is full
+func (c *hashCache) put(key hashKey, entry hashEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[hashKey]hashEntry)
+	}
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= hashCacheMaxEntries {
+		for old := range c.entries {
+			delete(c.entries, old)
+			break
+		}
+	}
+	c.entries[key] = entry
+}
+
+// HashFile computes the hash of a file for a given user with one of the
+// HashAlgorithms and returns it in hex along with the file size. Hashes of
+// large files are cached until the file's size or modification time change.
+func (fs *FileSystem) HashFile(user *config.User, path, algorithm string) (string, int64, error) {
+	// Check read permission
+	if err := auth.CheckPermission(user, fs.dataDir, path, auth.PermissionRead); err != nil {
+		return "", 0, err
+	}
+
+	algorithm, err := ParseHashAlgorithm(algorithm)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Get the storage backend and name
+	driver, name, err := fs.resolve(user, path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	info, err := driver.Stat(name)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("%s is not a file", path)
+	}
+
+	key := hashKey{driver: driver, name: name, algorithm: algorithm}
+	if sum, ok := fs.hashes.get(key, info.Size(), info.ModTime()); ok {
+		metrics.HashCache.Inc("hit")
+		return sum, info.Size(), nil
+	}
+	if info.Size() >= hashCacheMinSize {
+		metrics.HashCache.Inc("miss")
+	}
+
+	release, err := fs.lock(driver, name, false)
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	file, err := driver.Open(name, 0, -1)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	h := newHash(algorithm)
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to hash file: %w", err)
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	// Only cache what was hashed if the file didn't change meanwhile
+	if size >= hashCacheMinSize && size == info.Size() {
+		if after, err := driver.Stat(name); err == nil && after.Size() == size && after.ModTime().Equal(info.ModTime()) {
+			fs.hashes.put(key, hashEntry{size: size, modTime: info.ModTime(), sum: sum})
+		}
+	}
+
+	return sum, size, nil
+}
@@ -0,0 +1,401 @@
+package fs
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// HelperCommand is the hidden command line the daemon starts user helper
+// processes with
+const HelperCommand = "fs-helper"
+
+// helperChunk caps the data moved in one read request
+const helperChunk = 256 * 1024
+
+// helperErrors are the errors whose identity survives the process boundary
+var helperErrors = []error{fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, ErrSymlinkDenied, io.EOF}
+
+// helperRequest is one operation sent to a helper process
+type helperRequest struct {
+	Op     string
+	Name   string
+	To     string
+	Handle uint64
+	Offset int64
+	Length int64
+	Mode   os.FileMode
+	Data   []byte
+}
+
+// helperResponse is the result of a helper operation
+type helperResponse struct {
+	Infos  []helperInfo
+	Handle uint64
+	Data   []byte
+	N      int
+	Err    string
+	Kind   int // index into helperErrors plus one, zero for other errors
+}
+
+// helperInfo is os.FileInfo in a form gob can carry
+type helperInfo struct {
+	Name    string
+	Size    int64
+	Mode    os.FileMode
+	ModTime time.Time
+}
+
+// helperError is an error returned by a helper process
+type helperError struct {
+	msg  string
+	kind error
+}
+
+func (e *helperError) Error() string { return e.msg }
+func (e *helperError) Unwrap() error { return e.kind }
+
+// HelperDriver runs local storage operations in a helper process that has
+// switched to a user's uid and gids, so the kernel checks every access
+// against that user as well. The process is started on first use and
+// restarted if it goes away.
+type HelperDriver struct {
+	root     string
+	symlinks string
+	uid, gid int
+	groups   []int
+
+	mutex   sync.Mutex
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	encoder *gob.Encoder
+	decoder *gob.Decoder
+}
+
+// NewHelperDriver creates a driver for a local directory whose operations
+// run as the given user
+func NewHelperDriver(root, symlinks string, uid, gid int, groups []int) *HelperDriver {
+	return &HelperDriver{root: root, symlinks: symlinks, uid: uid, gid: gid, groups: groups}
+}
+
+// Root returns the local directory the driver serves
+func (d *HelperDriver) Root() string {
+	return d.root
+}
+
+// Stat returns information about a file or directory
+func (d *HelperDriver) Stat(name string) (os.FileInfo, error) {
+	resp, err := d.call(helperRequest{Op: "stat", Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Infos[0].fileInfo(), nil
+}
+
+// List returns the entries of a directory
+func (d *HelperDriver) List(name string) ([]os.FileInfo, error) {
+	resp, err := d.call(helperRequest{Op: "list", Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, len(resp.Infos))
+	for i, info := range resp.Infos {
+		infos[i] = info.fileInfo()
+	}
+	return infos, nil
+}
+
+// Open opens a file for reading starting at offset
+func (d *HelperDriver) Open(name string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := d.call(helperRequest{Op: "open", Name: name, Offset: offset, Length: length})
+	if err != nil {
+		return nil, err
+	}
+	return &helperFile{driver: d, handle: resp.Handle}, nil
+}
+
+// Create creates or truncates a file for writing
+func (d *HelperDriver) Create(name string) (io.WriteCloser, error) {
+	resp, err := d.call(helperRequest{Op: "create", Name: name})
+	if err != nil {
+		return nil, err
+	}
+	return &helperFile{driver: d, handle: resp.Handle}, nil
+}
+
+// Rename moves a file or directory
+func (d *HelperDriver) Rename(from, to string) error {
+	_, err := d.call(helperRequest{Op: "rename", Name: from, To: to})
+	return err
+}
+
+// Remove removes a file or an empty directory
+func (d *HelperDriver) Remove(name string) error {
+	_, err := d.call(helperRequest{Op: "remove", Name: name})
+	return err
+}
+
+// Mkdir creates a directory along with any missing parents
+func (d *HelperDriver) Mkdir(name string) error {
+	_, err := d.call(helperRequest{Op: "mkdir", Name: name})
+	return err
+}
+
+// Chmod changes the mode of a file or directory
+func (d *HelperDriver) Chmod(name string, mode os.FileMode) error {
+	_, err := d.call(helperRequest{Op: "chmod", Name: name, Mode: mode})
+	return err
+}
+
+// call sends one request to the helper, starting it if needed
+func (d *HelperDriver) call(req helperRequest) (*helperResponse, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.cmd == nil {
+		if err := d.start(); err != nil {
+			return nil, fmt.Errorf("failed to start user helper: %w", err)
+		}
+	}
+
+	var resp helperResponse
+	if err := d.encoder.Encode(&req); err != nil {
+		d.stop()
+		return nil, fmt.Errorf("user helper failed: %w", err)
+	}
+	if err := d.decoder.Decode(&resp); err != nil {
+		d.stop()
+		return nil, fmt.Errorf("user helper failed: %w", err)
+	}
+
+	if resp.Err != "" {
+		err := &helperError{msg: resp.Err}
+		if resp.Kind > 0 && resp.Kind <= len(helperErrors) {
+			err.kind = helperErrors[resp.Kind-1]
+		}
+		return &resp, err
+	}
+	return &resp, nil
+}
+
+// start launches the helper process with the user's credentials
+func (d *HelperDriver) start() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(executable, HelperCommand, "--root", d.root, "--symlinks", d.symlinks)
+	cmd.Stderr = os.Stderr
+	if err := setCredentials(cmd, d.uid, d.gid, d.groups); err != nil {
+		return err
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	d.cmd, d.stdin = cmd, stdin
+	d.encoder, d.decoder = gob.NewEncoder(stdin), gob.NewDecoder(stdout)
+	return nil
+}
+
+// stop closes the pipe to a broken helper so it exits, and forgets it
+func (d *HelperDriver) stop() {
+	d.stdin.Close()
+	go d.cmd.Wait()
+	d.cmd = nil
+}
+
+// helperFile is a file opened inside a helper process
+type helperFile struct {
+	driver *HelperDriver
+	handle uint64
+	closed bool
+}
+
+// Read reads the next chunk of the file
+func (f *helperFile) Read(p []byte) (int, error) {
+	size := min(len(p), helperChunk)
+	resp, err := f.driver.call(helperRequest{Op: "read", Handle: f.handle, Length: int64(size)})
+	if resp != nil && len(resp.Data) > 0 {
+		return copy(p, resp.Data), nil
+	}
+	if errors.Is(err, io.EOF) {
+		return 0, io.EOF
+	}
+	return 0, err
+}
+
+// Write writes p to the file
+func (f *helperFile) Write(p []byte) (int, error) {
+	resp, err := f.driver.call(helperRequest{Op: "write", Handle: f.handle, Data: p})
+	if err != nil {
+		if resp != nil {
+			return resp.N, err
+		}
+		return 0, err
+	}
+	return resp.N, nil
+}
+
+// Sync commits the file's contents to disk
+func (f *helperFile) Sync() error {
+	_, err := f.driver.call(helperRequest{Op: "sync", Handle: f.handle})
+	return err
+}
+
+// Close closes the file inside the helper
+func (f *helperFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	_, err := f.driver.call(helperRequest{Op: "close", Handle: f.handle})
+	return err
+}
+
+// fileInfo converts transported file information back to os.FileInfo
+func (i helperInfo) fileInfo() os.FileInfo {
+	return &fileInfo{name: i.Name, size: i.Size, mode: i.Mode, modTime: i.ModTime}
+}
+
+// ServeHelper runs the helper side of a HelperDriver, serving requests for
+// a local directory from r and answering on w until r is closed
+func ServeHelper(root, symlinks string, r io.Reader, w io.Writer) error {
+	server := &helperServer{
+		driver: NewLocalDriver(root, symlinks),
+		files:  make(map[uint64]io.Closer),
+	}
+
+	decoder, encoder := gob.NewDecoder(r), gob.NewEncoder(w)
+	for {
+		var req helperRequest
+		if err := decoder.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		resp := server.handle(req)
+		if err := encoder.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// helperServer executes requests inside a helper process
+type helperServer struct {
+	driver *LocalDriver
+	files  map[uint64]io.Closer
+	next   uint64
+}
+
+// handle executes a single request
+func (s *helperServer) handle(req helperRequest) *helperResponse {
+	resp := &helperResponse{}
+	var err error
+
+	switch req.Op {
+	case "stat":
+		var info os.FileInfo
+		if info, err = s.driver.Stat(req.Name); err == nil {
+			resp.Infos = []helperInfo{newHelperInfo(info)}
+		}
+	case "list":
+		var infos []os.FileInfo
+		if infos, err = s.driver.List(req.Name); err == nil {
+			for _, info := range infos {
+				resp.Infos = append(resp.Infos, newHelperInfo(info))
+			}
+		}
+	case "open":
+		var reader io.ReadCloser
+		if reader, err = s.driver.Open(req.Name, req.Offset, req.Length); err == nil {
+			resp.Handle = s.add(reader)
+		}
+	case "create":
+		var writer io.WriteCloser
+		if writer, err = s.driver.Create(req.Name); err == nil {
+			resp.Handle = s.add(writer)
+		}
+	case "read":
+		reader, ok := s.files[req.Handle].(io.Reader)
+		if !ok {
+			err = fs.ErrClosed
+			break
+		}
+		buffer := make([]byte, min(req.Length, helperChunk))
+		var n int
+		n, err = reader.Read(buffer)
+		resp.Data = buffer[:n]
+	case "write":
+		writer, ok := s.files[req.Handle].(io.Writer)
+		if !ok {
+			err = fs.ErrClosed
+			break
+		}
+		resp.N, err = writer.Write(req.Data)
+	case "sync":
+		if syncer, ok := s.files[req.Handle].(interface{ Sync() error }); ok {
+			err = syncer.Sync()
+		}
+	case "close":
+		file, ok := s.files[req.Handle]
+		if !ok {
+			err = fs.ErrClosed
+			break
+		}
+		delete(s.files, req.Handle)
+		err = file.Close()
+	case "rename":
+		err = s.driver.Rename(req.Name, req.To)
+	case "remove":
+		err = s.driver.Remove(req.Name)
+	case "mkdir":
+		err = s.driver.Mkdir(req.Name)
+	case "chmod":
+		err = s.driver.Chmod(req.Name, req.Mode)
+	default:
+		err = fmt.Errorf("unknown helper operation '%s'", req.Op)
+	}
+
+	if err != nil {
+		resp.Err = err.Error()
+		for i, kind := range helperErrors {
+			if errors.Is(err, kind) {
+				resp.Kind = i + 1
+				break
+			}
+		}
+	}
+	return resp
+}
+
+// add registers an open file and returns its handle
+func (s *helperServer) add(file io.Closer) uint64 {
+	s.next++
+	s.files[s.next] = file
+	return s.next
+}
+
+// newHelperInfo converts os.FileInfo for transport
+func newHelperInfo(info os.FileInfo) helperInfo {
+	return helperInfo{Name: info.Name(), Size: info.Size(), Mode: info.Mode(), ModTime: info.ModTime()}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package fs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// setCredentials is not available without unix credentials
+func setCredentials(cmd *exec.Cmd, uid, gid int, groups []int) error {
+	return fmt.Errorf("user helpers are not supported on this platform")
+}
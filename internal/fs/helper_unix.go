@@ -0,0 +1,19 @@
+//go:build unix
+
+package fs
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCredentials makes cmd run with the given uid, gid and supplementary groups
+func setCredentials(cmd *exec.Cmd, uid, gid int, groups []int) error {
+	credential := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	for _, group := range groups {
+		credential.Groups = append(credential.Groups, uint32(group))
+	}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: credential}
+	return nil
+}
@@ -0,0 +1,281 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// ErrSymlinkDenied is returned for paths through a symlink when the
+// symlink policy is deny
+var ErrSymlinkDenied = errors.New("symbolic links are not allowed")
+
+// LocalDriver stores files in a directory on the local disk. Unless the
+// symlink policy is follow, every path is resolved beneath the root so
+// neither ".." nor a symlink can reach outside of it.
+type LocalDriver struct {
+	root     string
+	symlinks string
+}
+
+// localRoot is the set of operations the local driver runs against its
+// root directory. It is satisfied by *os.Root and by hostRoot.
+type localRoot interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	Open(name string) (*os.File, error)
+	Create(name string) (*os.File, error)
+	Rename(from, to string) error
+	Remove(name string) error
+	MkdirAll(name string, perm os.FileMode) error
+	Chown(name string, uid, gid int) error
+	Chmod(name string, mode os.FileMode) error
+	Close() error
+}
+
+// NewLocalDriver creates a driver rooted at a local directory using one of
+// the config.Symlinks* policies
+func NewLocalDriver(root, symlinks string) *LocalDriver {
+	return &LocalDriver{root: root, symlinks: symlinks}
+}
+
+// Root returns the local directory the driver serves
+func (d *LocalDriver) Root() string {
+	return d.root
+}
+
+// Stat returns information about a file or directory
+func (d *LocalDriver) Stat(name string) (os.FileInfo, error) {
+	root, name, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	return root.Stat(name)
+}
+
+// List returns the entries of a directory. Symlinks are reported with the
+// information of their target and keep os.ModeSymlink so walks can skip
+// them. Links that can't be followed under the policy are left out.
+func (d *LocalDriver) List(name string) ([]os.FileInfo, error) {
+	root, name, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	dir, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue // Skip files we can't stat
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if d.symlinks == config.SymlinksDeny {
+				continue
+			}
+			target, err := root.Stat(path.Join(name, entry.Name()))
+			if err != nil {
+				continue // Dangling or pointing outside the root
+			}
+			info = &fileInfo{
+				name:    entry.Name(),
+				size:    target.Size(),
+				mode:    target.Mode() | os.ModeSymlink,
+				modTime: target.ModTime(),
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Open opens a file for reading starting at offset
+func (d *LocalDriver) Open(name string, offset, length int64) (io.ReadCloser, error) {
+	root, name, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	file, err := root.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if length >= 0 {
+		return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+	}
+	return file, nil
+}
+
+// Create creates or truncates a file for writing
+func (d *LocalDriver) Create(name string) (io.WriteCloser, error) {
+	root, name, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	return root.Create(name)
+}
+
+// Rename moves a file or directory
+func (d *LocalDriver) Rename(from, to string) error {
+	root, from, err := d.open(from)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	to, err = d.relative(root, to)
+	if err != nil {
+		return err
+	}
+	return root.Rename(from, to)
+}
+
+// Remove removes a file or an empty directory
+func (d *LocalDriver) Remove(name string) error {
+	root, name, err := d.open(name)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return root.Remove(name)
+}
+
+// Mkdir creates a directory along with any missing parents
+func (d *LocalDriver) Mkdir(name string) error {
+	root, name, err := d.open(name)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return root.MkdirAll(name, 0755)
+}
+
+// Chown changes the owner of a file or directory
+func (d *LocalDriver) Chown(name string, uid, gid int) error {
+	root, name, err := d.open(name)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return root.Chown(name, uid, gid)
+}
+
+// Chmod changes the mode of a file or directory
+func (d *LocalDriver) Chmod(name string, mode os.FileMode) error {
+	root, name, err := d.open(name)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	return root.Chmod(name, mode)
+}
+
+// open opens the driver root for a single operation and converts name to
+// a path relative to it. The caller must close the returned root.
+func (d *LocalDriver) open(name string) (localRoot, string, error) {
+	var root localRoot = hostRoot(d.root)
+	if d.symlinks != config.SymlinksFollow {
+		r, err := os.OpenRoot(d.root)
+		if err != nil {
+			return nil, "", err
+		}
+		root = r
+	}
+
+	name, err := d.relative(root, name)
+	if err != nil {
+		root.Close()
+		return nil, "", err
+	}
+	return root, name, nil
+}
+
+// relative converts a driver name to a path relative to the root. Under
+// the deny policy every existing component of the path is checked so the
+// operation never passes through a symlink.
+func (d *LocalDriver) relative(root localRoot, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return ".", nil
+	}
+
+	if d.symlinks == config.SymlinksDeny {
+		current := ""
+		for _, part := range strings.Split(name, "/") {
+			current = path.Join(current, part)
+			info, err := root.Lstat(current)
+			if errors.Is(err, fs.ErrNotExist) {
+				break // Nothing below a missing component can be a link
+			}
+			if err != nil {
+				return "", err
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return "", &fs.PathError{Op: "open", Path: "/" + name, Err: ErrSymlinkDenied}
+			}
+		}
+	}
+	return name, nil
+}
+
+// hostRoot runs operations directly on the host file system below a
+// directory, following symlinks wherever they point
+type hostRoot string
+
+func (r hostRoot) path(name string) string {
+	return filepath.Join(string(r), filepath.FromSlash(name))
+}
+
+func (r hostRoot) Stat(name string) (os.FileInfo, error)  { return os.Stat(r.path(name)) }
+func (r hostRoot) Lstat(name string) (os.FileInfo, error) { return os.Lstat(r.path(name)) }
+func (r hostRoot) Open(name string) (*os.File, error)     { return os.Open(r.path(name)) }
+func (r hostRoot) Create(name string) (*os.File, error)   { return os.Create(r.path(name)) }
+func (r hostRoot) Rename(from, to string) error           { return os.Rename(r.path(from), r.path(to)) }
+func (r hostRoot) Remove(name string) error               { return os.Remove(r.path(name)) }
+func (r hostRoot) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(r.path(name), perm)
+}
+func (r hostRoot) Chown(name string, uid, gid int) error { return os.Chown(r.path(name), uid, gid) }
+func (r hostRoot) Chmod(name string, mode os.FileMode) error {
+	return os.Chmod(r.path(name), mode)
+}
+func (r hostRoot) Close() error { return nil }
+
+// limitedReadCloser closes the underlying file of a limited reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
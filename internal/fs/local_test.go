@@ -0,0 +1,212 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// secret is the content of the files outside the driver root
+const secret = "secret"
+
+// newLocalFixture lays out a driver root next to a sibling whose name
+// starts with the root's, with links inside the root pointing to both:
+//
+//	base/secret.txt
+//	base/data2/secret.txt
+//	base/data/sub/inside.txt
+//	base/data/in-link  -> sub
+//	base/data/out-link -> base/data2
+//	base/data/up-link  -> ../data2
+//	base/data/dir-link -> ..
+func newLocalFixture(tb testing.TB) (base, root string) {
+	tb.Helper()
+	base = tb.TempDir()
+	root = filepath.Join(base, "data")
+
+	for _, dir := range []string{filepath.Join(root, "sub"), filepath.Join(base, "data2")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(base, "secret.txt"):          secret,
+		filepath.Join(base, "data2", "secret.txt"): secret,
+		filepath.Join(root, "sub", "inside.txt"):   "inside",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"in-link":  "sub",
+		"out-link": filepath.Join(base, "data2"),
+		"up-link":  "../data2",
+		"dir-link": "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			tb.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+	return base, root
+}
+
+// throughLink reports whether resolving name below root passes through a
+// symbolic link on the host
+func throughLink(root, name string) bool {
+	current := root
+	for _, part := range strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return false
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshot lists everything outside root below base, with modes and file
+// contents
+func snapshot(tb testing.TB, base, root string) []string {
+	tb.Helper()
+	var entries []string
+	err := filepath.Walk(base, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if name == root {
+			return filepath.SkipDir
+		}
+		entry := name + " " + info.Mode().String()
+		if info.Mode().IsRegular() {
+			content, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			entry += "=" + string(content)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return entries
+}
+
+// readAll opens name through the driver and returns its content
+func readAll(d *LocalDriver, name string) (string, error) {
+	info, err := d.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+	r, err := d.Open(name, 0, -1)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	return string(content), err
+}
+
+// FuzzLocalDriver checks that no driver name reaches outside the root
+// under the within_root and deny policies, that deny never passes through
+// a link, and that follow only leaves the root through a link
+func FuzzLocalDriver(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"/",
+		".",
+		"..",
+		"../secret.txt",
+		"../../secret.txt",
+		"/../data2/secret.txt",
+		"../data2/secret.txt",
+		"sub/../../data2/secret.txt",
+		"sub/inside.txt",
+		"/sub/./inside.txt",
+		"//sub//inside.txt",
+		"in-link/inside.txt",
+		"out-link/secret.txt",
+		"up-link/secret.txt",
+		"dir-link/secret.txt",
+		"dir-link/data2/secret.txt",
+		"in-link/../../secret.txt",
+		"data2/secret.txt",
+		"/data2/secret.txt",
+		"new/file.txt",
+		"out-link/new.txt",
+		"dir-link/new.txt",
+		"sub\x00/inside.txt",
+	} {
+		f.Add(seed)
+	}
+
+	base, root := newLocalFixture(f)
+	outside := snapshot(f, base, root)
+
+	drivers := map[string]*LocalDriver{
+		config.SymlinksWithinRoot: NewLocalDriver(root, config.SymlinksWithinRoot),
+		config.SymlinksDeny:       NewLocalDriver(root, config.SymlinksDeny),
+		config.SymlinksFollow:     NewLocalDriver(root, config.SymlinksFollow),
+	}
+
+	f.Fuzz(func(t *testing.T, name string) {
+		linked := throughLink(root, name)
+
+		for policy, d := range drivers {
+			content, err := readAll(d, name)
+			switch {
+			case policy == config.SymlinksDeny && linked && err == nil:
+				t.Errorf("%s: read %q through a link", policy, name)
+			case policy == config.SymlinksFollow && linked:
+				// Following links anywhere is what the policy is for
+			case err == nil && content == secret:
+				t.Errorf("%s: read %q from outside the root", policy, name)
+			}
+		}
+
+		// Writes may change the root, but nothing outside of it. Follow
+		// is left out, as it writes through links by design.
+		for _, policy := range []string{config.SymlinksWithinRoot, config.SymlinksDeny} {
+			d := drivers[policy]
+			if w, err := d.Create(name); err == nil {
+				w.Write([]byte("written"))
+				w.Close()
+			}
+			d.Mkdir(path.Join(name, "dir"))
+			d.Rename(name, "renamed")
+			d.Rename("renamed", name)
+			d.Chmod(name, 0750)
+
+			if after := snapshot(t, base, root); !slices.Equal(outside, after) {
+				t.Fatalf("%s: %q changed files outside the root:\nbefore %v\nafter  %v", policy, name, outside, after)
+			}
+		}
+	})
+}
+
+func TestLocalDriver(t *testing.T) {
+	for _, policy := range []string{config.SymlinksWithinRoot, config.SymlinksDeny, config.SymlinksFollow} {
+		t.Run(policy, func(t *testing.T) {
+			testDriver(t, NewLocalDriver(t.TempDir(), policy))
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+)
+
+// ErrLocked is returned when a file is in use by a conflicting transfer
+var ErrLocked = errors.New("file is in use")
+
+// lockManager hands out shared locks to readers and exclusive locks to
+// writers. Locks are keyed by where a file really lives, so transfers over
+// every protocol, by every user and through every mount see each other.
+type lockManager struct {
+	mutex sync.Mutex
+	locks map[string]*fileLock
+}
+
+// fileLock is the state of one locked file
+type fileLock struct {
+	readers  int
+	writer   bool
+	waiting  int           // writers waiting, new readers queue behind them
+	released chan struct{} // closed and replaced whenever a holder lets go
+}
+
+// rootedDriver is implemented by drivers storing files in a local directory
+type rootedDriver interface {
+	// Root returns the local directory the driver serves
+	Root() string
+}
+
+// objectDriver is implemented by drivers storing files as objects that
+// other driver instances can reach too
+type objectDriver interface {
+	// ObjectID returns where the object behind name lives
+	ObjectID(name string) string
+}
+
+// lockKey identifies where a file really lives: its real local path for
+// local storage, the object for object storage, otherwise the shared
+// driver instance and name. Links and mounts leading to the same file
+// share its key.
+func lockKey(driver Driver, name string) string {
+	switch d := driver.(type) {
+	case rootedDriver:
+		return "local:" + realPath(filepath.Join(d.Root(), filepath.FromSlash(name)))
+	case objectDriver:
+		return "object:" + d.ObjectID(name)
+	}
+	return fmt.Sprintf("%p:%s", driver, name)
+}
+
+// realPath resolves the symbolic links in a local path. Parts that don't
+// exist yet are kept below the real path of their closest existing parent.
+func realPath(name string) string {
+	rest := ""
+	for {
+		if real, err := filepath.EvalSymlinks(name); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(name)
+		if parent == name {
+			return filepath.Join(name, rest)
+		}
+		rest = filepath.Join(filepath.Base(name), rest)
+		name = parent
+	}
+}
+
+// acquire takes a lock, waiting up to timeout for conflicting holders when
+// wait is set. It returns a function that releases the lock.
+func (m *lockManager) acquire(key string, exclusive, wait bool, timeout time.Duration) (func(), error) {
+	deadline := time.Now().Add(timeout)
+	queued := false
+
+	for {
+		m.mutex.Lock()
+		if m.locks == nil {
+			m.locks = make(map[string]*fileLock)
+		}
+		l, exists := m.locks[key]
+		if !exists {
+			l = &fileLock{released: make(chan struct{})}
+			m.locks[key] = l
+		}
+		if queued {
+			l.waiting--
+			queued = false
+		}
+
+		if exclusive && !l.writer && l.readers == 0 {
+			l.writer = true
+			m.mutex.Unlock()
+			return m.releaser(key, true), nil
+		}
+		if !exclusive && !l.writer && l.waiting == 0 {
+			l.readers++
+			m.mutex.Unlock()
+			return m.releaser(key, false), nil
+		}
+
+		remaining := time.Until(deadline)
+		if !wait || remaining <= 0 {
+			m.mutex.Unlock()
+			return nil, ErrLocked
+		}
+		if exclusive {
+			l.waiting++
+			queued = true
+		}
+		released := l.released
+		m.mutex.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-released:
+			timer.Stop()
+		case <-timer.C:
+			if queued {
+				m.dequeue(key)
+			}
+			return nil, ErrLocked
+		}
+	}
+}
+
+// dequeue removes a writer that gave up waiting
+func (m *lockManager) dequeue(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if l, exists := m.locks[key]; exists {
+		l.waiting--
+		m.wake(key, l)
+	}
+}
+
+// releaser returns a function releasing a lock once
+func (m *lockManager) releaser(key string, exclusive bool) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+
+			l := m.locks[key]
+			if exclusive {
+				l.writer = false
+			} else {
+				l.readers--
+			}
+			m.wake(key, l)
+		})
+	}
+}
+
+// wake lets everyone waiting on a lock try again and forgets the lock once
+// nobody holds or wants it. The caller must hold the mutex.
+func (m *lockManager) wake(key string, l *fileLock) {
+	close(l.released)
+	l.released = make(chan struct{})
+	if !l.writer && l.readers == 0 && l.waiting == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// lock takes a lock on a file under the configured conflict policy. With
+// the snapshot policy readers don't lock at all, as uploads only replace
+// the file once they complete.
+func (fs *FileSystem) lock(driver Driver, name string, exclusive bool) (func(), error) {
+	policy := fs.config.Locking
+	if policy.Conflict == config.LockSnapshot && !exclusive {
+		return func() {}, nil
+	}
+
+	return fs.lockByKey(lockKey(driver, name), name, exclusive)
+}
+
+// lockByKey takes the lock with the given key, naming the file name in
+// errors
+func (fs *FileSystem) lockByKey(key, name string, exclusive bool) (func(), error) {
+	policy := fs.config.Locking
+	wait := policy.Conflict != config.LockFail
+	release, err := fs.locks.acquire(key, exclusive, wait, policy.Timeout)
+	if err != nil {
+		metrics.LockConflicts.Inc()
+		return nil, fmt.Errorf("%w: %s", err, name)
+	}
+	return release, nil
+}
+
+// lockAll takes exclusive locks on several files of one driver, in key
+// order so that two callers never wait on each other. Names leading to
+// the same file are locked once. Either every lock is taken or none is.
+func (fs *FileSystem) lockAll(driver Driver, names []string) (func(), error) {
+	keys := make(map[string]string, len(names))
+	for _, name := range names {
+		keys[lockKey(driver, name)] = name
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	var releases []func()
+	releaseAll := func() {
+		for i := len(releases) - 1; i >= 0; i-- {
+			releases[i]()
+		}
+	}
+	for _, key := range sorted {
+		release, err := fs.lockByKey(key, keys[key], true)
+		if err != nil {
+			releaseAll()
+			return nil, err
+		}
+		releases = append(releases, release)
+	}
+	return releaseAll, nil
+}
+
+// lockedReader releases its lock when closed
+type lockedReader struct {
+	io.ReadCloser
+	release func()
+}
+
+// Close closes the file and releases the lock
+func (r *lockedReader) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
@@ -0,0 +1,70 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+func TestLockKeyFindsTheRealFile(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "dir", "file.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, target := range map[string]string{"dir-link": "dir", "file-link": "dir/file.txt"} {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symlinks are not supported: %v", err)
+		}
+	}
+
+	d := NewLocalDriver(root, config.SymlinksFollow)
+	mount := NewLocalDriver(filepath.Join(root, "dir"), config.SymlinksFollow)
+	bucket := config.S3Config{Endpoint: "http://s3.example/", Bucket: "files", Prefix: "homes"}
+	home := bucket
+	home.Prefix = "homes/bob"
+
+	for _, tc := range []struct {
+		what      string
+		driver    Driver
+		name      string
+		other     Driver
+		otherName string
+	}{
+		{"link to the file", d, "/dir/file.txt", d, "/file-link"},
+		{"link to the directory", d, "/dir/file.txt", d, "/dir-link/file.txt"},
+		{"new file through a link", d, "/dir/new.txt", d, "/dir-link/new.txt"},
+		{"mount of the directory", d, "/dir/file.txt", mount, "/file.txt"},
+		{"prefixes of one bucket", NewS3Driver(bucket), "/bob/file.txt", NewS3Driver(home), "/file.txt"},
+	} {
+		if a, b := lockKey(tc.driver, tc.name), lockKey(tc.other, tc.otherName); a != b {
+			t.Errorf("%s: %s and %s have different keys %q and %q", tc.what, tc.name, tc.otherName, a, b)
+		}
+	}
+	if lockKey(d, "/dir/file.txt") == lockKey(d, "/dir/new.txt") {
+		t.Errorf("different files share a key")
+	}
+
+	// Writers through the link and the real name exclude each other
+	fsys := &FileSystem{config: &config.Config{Locking: config.LockingConfig{Conflict: config.LockFail}}}
+	release, err := fsys.lock(d, "/dir/file.txt", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.lock(d, "/file-link", false); !errors.Is(err, ErrLocked) {
+		t.Errorf("read through a link during a write: got %v, want %v", err, ErrLocked)
+	}
+	release()
+
+	// Renaming a file onto a link to it only locks it once
+	release, err = fsys.lockAll(d, []string{"/dir/file.txt", "/file-link"})
+	if err != nil {
+		t.Fatalf("lock of two names of one file: %v", err)
+	}
+	release()
+}
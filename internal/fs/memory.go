@@ -0,0 +1,216 @@
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryNode is a file or directory held by the memory driver
+type memoryNode struct {
+	data    []byte
+	isDir   bool
+	modTime time.Time
+}
+
+// MemoryDriver keeps files in memory. Contents are lost on restart, which
+// makes it useful for tests and scratch space.
+type MemoryDriver struct {
+	mutex sync.RWMutex
+	nodes map[string]*memoryNode
+}
+
+// NewMemoryDriver creates an empty in-memory driver
+func NewMemoryDriver() *MemoryDriver {
+	return &MemoryDriver{
+		nodes: map[string]*memoryNode{"/": {isDir: true, modTime: time.Now()}},
+	}
+}
+
+// Stat returns information about a file or directory
+func (d *MemoryDriver) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	node, exists := d.nodes[name]
+	if !exists {
+		return nil, notExist("stat", name)
+	}
+	return node.info(name), nil
+}
+
+// List returns the entries of a directory
+func (d *MemoryDriver) List(name string) ([]os.FileInfo, error) {
+	name = cleanName(name)
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	node, exists := d.nodes[name]
+	if !exists {
+		return nil, notExist("list", name)
+	}
+	if !node.isDir {
+		return nil, fmt.Errorf("list %s: not a directory", name)
+	}
+
+	var infos []os.FileInfo
+	for child, node := range d.nodes {
+		if child != "/" && path.Dir(child) == name {
+			infos = append(infos, node.info(child))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Open opens a file for reading starting at offset
+func (d *MemoryDriver) Open(name string, offset, length int64) (io.ReadCloser, error) {
+	name = cleanName(name)
+
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	node, exists := d.nodes[name]
+	if !exists {
+		return nil, notExist("open", name)
+	}
+	if node.isDir {
+		return nil, fmt.Errorf("open %s: is a directory", name)
+	}
+
+	data := node.data
+	offset = min(max(offset, 0), int64(len(data)))
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// Create creates or truncates a file for writing. The content becomes
+// visible when the writer is closed.
+func (d *MemoryDriver) Create(name string) (io.WriteCloser, error) {
+	name = cleanName(name)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if parent, exists := d.nodes[path.Dir(name)]; !exists || !parent.isDir {
+		return nil, notExist("create", name)
+	}
+	if node, exists := d.nodes[name]; exists && node.isDir {
+		return nil, fmt.Errorf("create %s: is a directory", name)
+	}
+	d.nodes[name] = &memoryNode{modTime: time.Now()}
+
+	return &memoryWriter{driver: d, name: name}, nil
+}
+
+// Rename moves a file or directory along with its children
+func (d *MemoryDriver) Rename(from, to string) error {
+	from, to = cleanName(from), cleanName(to)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.nodes[from]; !exists {
+		return notExist("rename", from)
+	}
+	if parent, exists := d.nodes[path.Dir(to)]; !exists || !parent.isDir {
+		return notExist("rename", to)
+	}
+	if from == "/" || strings.HasPrefix(to, from+"/") {
+		return fmt.Errorf("rename %s: cannot move a directory into itself", from)
+	}
+
+	for name, node := range d.nodes {
+		if name == from || strings.HasPrefix(name, from+"/") {
+			delete(d.nodes, name)
+			d.nodes[to+strings.TrimPrefix(name, from)] = node
+		}
+	}
+	return nil
+}
+
+// Remove removes a file or an empty directory
+func (d *MemoryDriver) Remove(name string) error {
+	name = cleanName(name)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, exists := d.nodes[name]; !exists || name == "/" {
+		return notExist("remove", name)
+	}
+	for child := range d.nodes {
+		if strings.HasPrefix(child, name+"/") {
+			return fmt.Errorf("remove %s: directory not empty", name)
+		}
+	}
+	delete(d.nodes, name)
+	return nil
+}
+
+// Mkdir creates a directory along with any missing parents
+func (d *MemoryDriver) Mkdir(name string) error {
+	name = cleanName(name)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Collect the missing directories, then create them from the top down
+	var missing []string
+	for dir := name; ; dir = path.Dir(dir) {
+		if node, exists := d.nodes[dir]; exists {
+			if !node.isDir {
+				return fmt.Errorf("mkdir %s: not a directory", dir)
+			}
+			break
+		}
+		missing = append(missing, dir)
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		d.nodes[missing[i]] = &memoryNode{isDir: true, modTime: time.Now()}
+	}
+	return nil
+}
+
+// info returns file info for the node stored under name
+func (n *memoryNode) info(name string) os.FileInfo {
+	return newFileInfo(path.Base(name), int64(len(n.data)), n.modTime, n.isDir)
+}
+
+// memoryWriter buffers a file until it is closed
+type memoryWriter struct {
+	driver *MemoryDriver
+	name   string
+	buffer bytes.Buffer
+}
+
+// Write appends to the buffered content
+func (w *memoryWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+// Close stores the buffered content in the driver
+func (w *memoryWriter) Close() error {
+	w.driver.mutex.Lock()
+	defer w.driver.mutex.Unlock()
+
+	w.driver.nodes[w.name] = &memoryNode{data: w.buffer.Bytes(), modTime: time.Now()}
+	return nil
+}
+
+// cleanName normalises a driver name to a rooted, cleaned path
+func cleanName(name string) string {
+	return path.Clean("/" + name)
+}
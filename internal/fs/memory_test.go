@@ -0,0 +1,36 @@
+package fs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestMemoryDriver(t *testing.T) {
+	testDriver(t, NewMemoryDriver())
+}
+
+func TestMemoryDriverContentVisibleOnClose(t *testing.T) {
+	d := NewMemoryDriver()
+
+	w, err := d.Create("/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "pending")
+	if info, err := d.Stat("/file.txt"); err != nil || info.Size() != 0 {
+		t.Errorf("stat before close: got %v, %v, want an empty file", info, err)
+	}
+	w.Close()
+	if info, err := d.Stat("/file.txt"); err != nil || info.Size() != 7 {
+		t.Errorf("stat after close: got %v, %v, want 7 bytes", info, err)
+	}
+
+	if _, err := d.Create("/missing/file.txt"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("create without a parent: got %v, want a missing file", err)
+	}
+	if err := d.Rename("/", "/elsewhere"); err == nil {
+		t.Errorf("rename of the root succeeded")
+	}
+}
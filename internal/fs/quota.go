@@ -0,0 +1,244 @@
+package fs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+)
+
+// ErrQuotaExceeded is returned when a write would exceed a user or group quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// quotaFile is the name of the usage file inside the state directory
+const quotaFile = "quota.json"
+
+// Usage is the storage consumed by a user or group
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// QuotaTracker keeps incremental usage counters for every limited user and
+// group and persists them in the state directory so they survive restarts.
+// Saves merge with changes other processes, such as admin commands, wrote
+// to the file in the meantime.
+type QuotaTracker struct {
+	config *config.Config
+	path   string
+	scan   func(user *config.User) Usage
+	mutex  sync.Mutex
+	usage  map[string]*Usage
+	saved  map[string]Usage // usage as last read from or written to the file
+}
+
+// NewQuotaTracker creates a tracker and loads previously persisted usage.
+// scan computes the usage of users seen for the first time.
+func NewQuotaTracker(cfg *config.Config, scan func(user *config.User) Usage) (*QuotaTracker, error) {
+	q := &QuotaTracker{
+		config: cfg,
+		path:   filepath.Join(cfg.State, quotaFile),
+		scan:   scan,
+		usage:  make(map[string]*Usage),
+	}
+
+	saved, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	q.saved = saved
+	for key, usage := range saved {
+		q.usage[key] = &usage
+	}
+
+	return q, nil
+}
+
+// read loads the usage file
+func (q *QuotaTracker) read() (map[string]Usage, error) {
+	usage := make(map[string]Usage)
+
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return usage, nil
+		}
+		return nil, fmt.Errorf("failed to read quota usage: %w", err)
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("failed to parse quota usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// Usage returns the current usage for a user
+func (q *QuotaTracker) Usage(user *config.User) Usage {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return *q.load(userKey(user.Name), user)
+}
+
+// Reserve charges bytes and files to every limited quota of the user,
+// failing without charging anything if one of them would be exceeded
+func (q *QuotaTracker) Reserve(user *config.User, bytes, files int64) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	entries := q.entries(user)
+	if bytes > 0 || files > 0 {
+		for _, e := range entries {
+			if e.quota.MaxBytes > 0 && e.usage.Bytes+bytes > e.quota.MaxBytes {
+				metrics.QuotaRejections.Inc(user.Name)
+				return fmt.Errorf("%w for %s: %d of %d bytes used", ErrQuotaExceeded, e.key, e.usage.Bytes, e.quota.MaxBytes)
+			}
+			if e.quota.MaxFiles > 0 && e.usage.Files+files > e.quota.MaxFiles {
+				metrics.QuotaRejections.Inc(user.Name)
+				return fmt.Errorf("%w for %s: %d of %d files used", ErrQuotaExceeded, e.key, e.usage.Files, e.quota.MaxFiles)
+			}
+		}
+	}
+
+	for _, e := range entries {
+		e.usage.add(bytes, files)
+	}
+
+	return nil
+}
+
+// Release returns bytes and files to every limited quota of the user
+func (q *QuotaTracker) Release(user *config.User, bytes, files int64) {
+	q.Reserve(user, -bytes, -files)
+}
+
+// Charge adds bytes and files to every limited quota of the user even if
+// that exceeds a limit, for restoring data that was already accepted once
+func (q *QuotaTracker) Charge(user *config.User, bytes, files int64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, e := range q.entries(user) {
+		e.usage.add(bytes, files)
+	}
+}
+
+// Save persists the current usage to the state directory
+func (q *QuotaTracker) Save() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	// Apply what other processes changed since our last read or write
+	onDisk, err := q.read()
+	if err != nil {
+		return err
+	}
+	for key, disk := range onDisk {
+		usage, exists := q.usage[key]
+		if !exists {
+			q.usage[key] = &disk
+			continue
+		}
+		if base, known := q.saved[key]; known {
+			usage.add(disk.Bytes-base.Bytes, disk.Files-base.Files)
+		}
+	}
+
+	data, err := json.MarshalIndent(q.usage, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode quota usage: %w", err)
+	}
+
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quota usage: %w", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("failed to write quota usage: %w", err)
+	}
+
+	q.saved = make(map[string]Usage, len(q.usage))
+	for key, usage := range q.usage {
+		q.saved[key] = *usage
+	}
+
+	return nil
+}
+
+// add changes the usage, never going below zero
+func (u *Usage) add(bytes, files int64) {
+	u.Bytes = max(u.Bytes+bytes, 0)
+	u.Files = max(u.Files+files, 0)
+}
+
+// quotaEntry pairs a quota limit with its live usage counter
+type quotaEntry struct {
+	key   string
+	quota config.Quota
+	usage *Usage
+}
+
+// entries returns the limited quotas that apply to a user. Must be called
+// with the mutex held.
+func (q *QuotaTracker) entries(user *config.User) []quotaEntry {
+	var entries []quotaEntry
+
+	if user.Quota.IsLimited() {
+		key := userKey(user.Name)
+		entries = append(entries, quotaEntry{key: key, quota: user.Quota, usage: q.load(key, user)})
+	}
+
+	for _, name := range user.Groups {
+		group, exists := q.config.Groups[name]
+		if !exists || !group.Quota.IsLimited() {
+			continue
+		}
+		key := groupKey(name)
+		entries = append(entries, quotaEntry{key: key, quota: group.Quota, usage: q.load(key, nil)})
+	}
+
+	return entries
+}
+
+// load returns the usage counter for key, scanning the disk the first time
+// a key is seen. Must be called with the mutex held.
+func (q *QuotaTracker) load(key string, user *config.User) *Usage {
+	if usage, exists := q.usage[key]; exists {
+		return usage
+	}
+
+	usage := &Usage{}
+	if user != nil {
+		*usage = q.scan(user)
+	} else {
+		// Groups start out with the combined usage of their members
+		name := key[len("group:"):]
+		for _, member := range q.config.Users {
+			for _, g := range member.Groups {
+				if g == name {
+					u := q.scan(member)
+					usage.Bytes += u.Bytes
+					usage.Files += u.Files
+				}
+			}
+		}
+	}
+
+	q.usage[key] = usage
+	return usage
+}
+
+// userKey returns the usage key for a user
+func userKey(name string) string {
+	return "user:" + name
+}
+
+// groupKey returns the usage key for a group
+func groupKey(name string) string {
+	return "group:" + name
+}
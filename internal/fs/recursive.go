@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Merith-TK/ftp-aio/internal/auth"
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// ErrTooManyEntries is returned when a recursive operation would touch
+// more entries than security.max_recursive_entries allows
+var ErrTooManyEntries = errors.New("too many entries")
+
+// DirectoryListing is the content of one directory in a recursive listing
+type DirectoryListing struct {
+	Path  string // relative to the listed directory, "." for itself
+	Files []FileInfo
+}
+
+// ListDirectoryRecursive lists a directory and every directory below it,
+// depth first, for a given user. Symlinked directories are not followed
+// and subdirectories that can't be listed are skipped.
+func (fs *FileSystem) ListDirectoryRecursive(user *config.User, path string) ([]DirectoryListing, error) {
+	limit := fs.config.Security.MaxRecursiveEntries
+	var listings []DirectoryListing
+	entries := 0
+
+	var list func(dir, relative string) error
+	list = func(dir, relative string) error {
+		files, err := fs.ListDirectory(user, dir)
+		if err != nil {
+			return err
+		}
+		entries += len(files)
+		if limit > 0 && entries > limit {
+			return fmt.Errorf("%w: more than %d", ErrTooManyEntries, limit)
+		}
+		listings = append(listings, DirectoryListing{Path: relative, Files: files})
+
+		for _, file := range files {
+			if !file.IsDir || file.Mode&os.ModeSymlink != 0 {
+				continue
+			}
+			child := file.Name
+			if relative != "." {
+				child = relative + "/" + file.Name
+			}
+			err := list(cleanName(dir+"/"+file.Name), child)
+			if errors.Is(err, ErrTooManyEntries) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := list(path, "."); err != nil {
+		return nil, err
+	}
+	return listings, nil
+}
+
+// RemoveDirectoryAll removes a directory and everything below it for a
+// given user and returns how many entries were removed. A tree with more
+// entries than security.max_recursive_entries is left alone. With a trash
+// the whole tree is moved there instead.
+func (fs *FileSystem) RemoveDirectoryAll(user *config.User, path string) (int, error) {
+	// Check delete permission
+	if err := auth.CheckPermission(user, fs.dataDir, path, auth.PermissionDelete); err != nil {
+		return 0, err
+	}
+
+	path = filepath.Clean("/" + path)
+	if path == filepath.Clean("/"+user.Path) {
+		return 0, fmt.Errorf("cannot remove the home directory")
+	}
+	if fs.isMountPoint(user, path) {
+		return 0, fmt.Errorf("cannot remove mount point %s", path)
+	}
+	if user.HasMountsBelow(path) {
+		return 0, fmt.Errorf("cannot remove %s, it contains mount points", path)
+	}
+
+	// Get the storage backend and name
+	driver, name, err := fs.resolveWrite(user, path)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := driver.Stat(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove directory: %w", err)
+	}
+	if !info.IsDir() {
+		return 0, fmt.Errorf("%s is not a directory", path)
+	}
+
+	// Collect the tree first so nothing is removed when it is too large
+	type entry struct {
+		name string
+		info os.FileInfo
+	}
+	limit := fs.config.Security.MaxRecursiveEntries
+	var children []entry
+	err = walk(driver, name, func(child string, info os.FileInfo) error {
+		children = append(children, entry{child, info})
+		if limit > 0 && len(children) > limit {
+			return fmt.Errorf("%w: more than %d", ErrTooManyEntries, limit)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Wait for transfers of the files in the tree to finish, and keep new
+	// ones out until it is gone
+	var files []string
+	for _, child := range children {
+		if !child.info.IsDir() {
+			files = append(files, child.name)
+		}
+	}
+	release, err := fs.lockAll(driver, files)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	if user.UsesTrash() {
+		if err := fs.moveToTrash(user, driver, name, path, info); err != nil {
+			return 0, err
+		}
+		return len(children) + 1, nil
+	}
+
+	// Walk is depth first, so deleting backwards empties directories before
+	// they are removed. Freed space is returned as it goes.
+	defer fs.quota.Save()
+	removed := 0
+	for i := len(children) - 1; i >= 0; i-- {
+		child := children[i]
+		if err := driver.Remove(child.name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return removed, fmt.Errorf("failed to remove %s: %w", child.name, err)
+		}
+		if child.info.Mode().IsRegular() {
+			fs.quota.Release(user, child.info.Size(), 1)
+		}
+		removed++
+	}
+
+	if err := driver.Remove(name); err != nil {
+		return removed, fmt.Errorf("failed to remove directory: %w", err)
+	}
+	return removed + 1, nil
+}
@@ -0,0 +1,535 @@
+package fs
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// s3Timeout bounds waiting for the headers of an answer and every read of
+// its body, on top of the connect timeouts of the default transport.
+// Transfers as a whole may take as long as they need.
+var s3Timeout = time.Minute
+
+// S3Driver stores files in an S3-compatible bucket. Directories are key
+// prefixes, explicit empty directories are kept as "name/" marker objects.
+type S3Driver struct {
+	config config.S3Config
+	client *http.Client
+	prefix string
+}
+
+// NewS3Driver creates a driver for an S3-compatible bucket
+func NewS3Driver(cfg config.S3Config) *S3Driver {
+	prefix := strings.Trim(cfg.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3Timeout
+
+	return &S3Driver{
+		config: cfg,
+		client: &http.Client{Transport: transport},
+		prefix: prefix,
+	}
+}
+
+// s3Object is an entry of a ListObjectsV2 response
+type s3Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// s3ListResult is the body of a ListObjectsV2 response
+type s3ListResult struct {
+	Contents       []s3Object `xml:"Contents"`
+	CommonPrefixes []struct {
+		Prefix string `xml:"Prefix"`
+	} `xml:"CommonPrefixes"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Stat returns information about a file or directory
+func (d *S3Driver) Stat(name string) (os.FileInfo, error) {
+	name = cleanName(name)
+	if name == "/" {
+		return newFileInfo("/", 0, time.Time{}, true), nil
+	}
+
+	resp, err := d.do(http.MethodHead, d.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+		return newFileInfo(path.Base(name), resp.ContentLength, modTime, false), nil
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		return nil, fmt.Errorf("stat %s: unexpected status %s", name, resp.Status)
+	}
+
+	// Not an object, it may still be a directory prefix
+	result, err := d.list(d.key(name)+"/", "/", "", 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, notExist("stat", name)
+	}
+	return newFileInfo(path.Base(name), 0, time.Time{}, true), nil
+}
+
+// List returns the entries of a directory
+func (d *S3Driver) List(name string) ([]os.FileInfo, error) {
+	name = cleanName(name)
+	prefix := d.dirKey(name)
+
+	var infos []os.FileInfo
+	found := name == "/"
+	token := ""
+	for {
+		result, err := d.list(prefix, "/", token, 1000)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range result.Contents {
+			found = true
+			child := strings.TrimPrefix(obj.Key, prefix)
+			if child == "" {
+				continue // Directory marker of name itself
+			}
+			infos = append(infos, newFileInfo(child, obj.Size, obj.LastModified, false))
+		}
+		for _, p := range result.CommonPrefixes {
+			found = true
+			child := strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/")
+			infos = append(infos, newFileInfo(child, 0, time.Time{}, true))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	if !found {
+		return nil, notExist("list", name)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// Open opens a file for reading starting at offset using a range request
+func (d *S3Driver) Open(name string, offset, length int64) (io.ReadCloser, error) {
+	name = cleanName(name)
+
+	headers := http.Header{}
+	if offset > 0 || length >= 0 {
+		end := ""
+		if length >= 0 {
+			end = fmt.Sprint(offset + length - 1)
+		}
+		headers.Set("Range", fmt.Sprintf("bytes=%d-%s", offset, end))
+	}
+
+	resp, err := d.do(http.MethodGet, d.key(name), nil, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, notExist("open", name)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("open %s: unexpected status %s", name, resp.Status)
+	}
+}
+
+// Create creates or replaces an object. Data is spooled to a temporary
+// file and uploaded when the writer is closed.
+func (d *S3Driver) Create(name string) (io.WriteCloser, error) {
+	spool, err := os.CreateTemp("", "ftp-aio-s3-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create spool file: %w", err)
+	}
+	return &s3Writer{driver: d, name: cleanName(name), spool: spool}, nil
+}
+
+// Rename copies a file or every object below a directory, then removes
+// the originals
+func (d *S3Driver) Rename(from, to string) error {
+	from, to = cleanName(from), cleanName(to)
+
+	info, err := d.Stat(from)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return d.move(d.key(from), d.key(to))
+	}
+
+	fromPrefix, toPrefix := d.dirKey(from), d.dirKey(to)
+	token := ""
+	for {
+		result, err := d.list(fromPrefix, "", token, 1000)
+		if err != nil {
+			return err
+		}
+		for _, obj := range result.Contents {
+			if err := d.move(obj.Key, toPrefix+strings.TrimPrefix(obj.Key, fromPrefix)); err != nil {
+				return err
+			}
+		}
+		if !result.IsTruncated {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Remove removes an object or an empty directory marker
+func (d *S3Driver) Remove(name string) error {
+	name = cleanName(name)
+
+	info, err := d.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	key := d.key(name)
+	if info.IsDir() {
+		entries, err := d.List(name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return fmt.Errorf("remove %s: directory not empty", name)
+		}
+		key = d.dirKey(name)
+	}
+	return d.delete(key)
+}
+
+// Mkdir creates a directory marker object
+func (d *S3Driver) Mkdir(name string) error {
+	name = cleanName(name)
+	if name == "/" {
+		return nil
+	}
+	return d.put(d.dirKey(name), strings.NewReader(""), 0)
+}
+
+// ObjectID returns the endpoint, bucket and key of the object behind name,
+// which drivers for other prefixes of the bucket share
+func (d *S3Driver) ObjectID(name string) string {
+	return strings.TrimSuffix(d.config.Endpoint, "/") + "/" + d.config.Bucket + "/" + d.key(name)
+}
+
+// key converts a driver name to an object key
+func (d *S3Driver) key(name string) string {
+	return d.prefix + strings.TrimPrefix(cleanName(name), "/")
+}
+
+// dirKey converts a directory name to the prefix of its children
+func (d *S3Driver) dirKey(name string) string {
+	if cleanName(name) == "/" {
+		return d.prefix
+	}
+	return d.key(name) + "/"
+}
+
+// list runs a ListObjectsV2 request
+func (d *S3Driver) list(prefix, delimiter, token string, maxKeys int) (*s3ListResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	query.Set("max-keys", fmt.Sprint(maxKeys))
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := d.do(http.MethodGet, "", query, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list %s: unexpected status %s", prefix, resp.Status)
+	}
+
+	var result s3ListResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("list %s: %w", prefix, err)
+	}
+	return &result, nil
+}
+
+// put uploads an object
+func (d *S3Driver) put(key string, body io.Reader, size int64) error {
+	resp, err := d.do(http.MethodPut, key, nil, nil, &s3Body{reader: body, size: size})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("put %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+// move copies an object to a new key and deletes the original
+func (d *S3Driver) move(from, to string) error {
+	headers := http.Header{}
+	headers.Set("x-amz-copy-source", "/"+d.config.Bucket+"/"+s3Escape(from, false))
+
+	resp, err := d.do(http.MethodPut, to, nil, headers, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("copy %s: unexpected status %s", from, resp.Status)
+	}
+
+	return d.delete(from)
+}
+
+// delete removes an object
+func (d *S3Driver) delete(key string) error {
+	resp, err := d.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("delete %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+// s3Body is a request body of known size
+type s3Body struct {
+	reader io.Reader
+	size   int64
+}
+
+// do signs and sends a request against the bucket using path-style URLs
+func (d *S3Driver) do(method, key string, query url.Values, headers http.Header, body *s3Body) (*http.Response, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(d.config.Endpoint, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+
+	escapedPath := endpoint.EscapedPath() + "/" + s3Escape(d.config.Bucket, false)
+	if key != "" {
+		escapedPath += "/" + s3Escape(key, false)
+	}
+	rawQuery := s3CanonicalQuery(query)
+	target := endpoint.Scheme + "://" + endpoint.Host + escapedPath
+	if rawQuery != "" {
+		target += "?" + rawQuery
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = body.reader
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = body.size
+		if body.size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	d.sign(req, escapedPath, rawQuery, time.Now().UTC())
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("s3 %s request failed: %w", method, err)
+	}
+	resp.Body = &s3Response{body: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// s3Response gives up on a response body that stalls for s3Timeout. Only
+// the time spent inside Read counts, so slow consumers are not cut off.
+type s3Response struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Read reads from the body, cancelling the request if nothing arrives
+func (r *s3Response) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(s3Timeout, r.cancel)
+	defer timer.Stop()
+	return r.body.Read(p)
+}
+
+// Close closes the body and releases the request
+func (r *s3Response) Close() error {
+	defer r.cancel()
+	return r.body.Close()
+}
+
+// sign adds an AWS Signature Version 4 authorization header to req
+func (d *S3Driver) sign(req *http.Request, escapedPath, rawQuery string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+
+	// Sign the host and every x-amz-* header
+	signed := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapedPath,
+		rawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	scope := date + "/" + d.config.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+d.config.SecretKey), date)
+	key = hmacSHA256(key, d.config.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		d.config.AccessKey, scope, signedHeaders, signature))
+}
+
+// hmacSHA256 computes an HMAC-SHA256 of data
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes a value as required by Signature Version 4,
+// keeping slashes unless encodeSlash is set
+func s3Escape(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Writer spools an upload to disk and sends it on Close
+type s3Writer struct {
+	driver *S3Driver
+	name   string
+	spool  *os.File
+}
+
+// Write appends to the spool file
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.spool.Write(p)
+}
+
+// Close uploads the spooled data and removes the spool file
+func (w *s3Writer) Close() error {
+	defer os.Remove(w.spool.Name())
+	defer w.spool.Close()
+
+	size, err := w.spool.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := w.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.driver.put(w.driver.key(w.name), w.spool, size)
+}
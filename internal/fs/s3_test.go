@@ -0,0 +1,453 @@
+package fs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// s3StandIn is a bucket served over HTTP that checks Signature Version 4
+// on every request. It pages listings two entries at a time so clients
+// have to follow continuation tokens.
+type s3StandIn struct {
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+// newS3StandIn starts a stand-in and returns a configuration pointing to it
+func newS3StandIn(t *testing.T) (*s3StandIn, config.S3Config) {
+	t.Helper()
+	s := &s3StandIn{
+		bucket:    "files",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "eu-west-1",
+		objects:   make(map[string][]byte),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	return s, config.S3Config{
+		Endpoint:  server.URL,
+		Region:    s.region,
+		Bucket:    s.bucket,
+		AccessKey: s.accessKey,
+		SecretKey: s.secretKey,
+	}
+}
+
+// keys returns the stored object keys in order
+func (s *s3StandIn) keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (s *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.verify(r); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	bucketPath := "/" + s.bucket
+	if r.URL.Path != bucketPath && !strings.HasPrefix(r.URL.Path, bucketPath+"/") {
+		http.Error(w, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, bucketPath), "/")
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r.URL.Query())
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, exists := s.objects[key]
+		if !exists {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		status := http.StatusOK
+		if ranged := r.Header.Get("Range"); ranged != "" {
+			var err error
+			if data, err = byteRange(data, ranged); err != nil {
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			status = http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		source, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, exists := s.objects[strings.TrimPrefix(source, bucketPath+"/")]
+		if !exists {
+			http.Error(w, "no such key", http.StatusNotFound)
+			return
+		}
+		s.objects[key] = data
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.objects[key] = data
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "not implemented", http.StatusNotImplemented)
+	}
+}
+
+// list answers a ListObjectsV2 request
+func (s *s3StandIn) list(w http.ResponseWriter, query url.Values) {
+	type object struct {
+		Key          string
+		Size         int
+		LastModified time.Time
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	var result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []object
+		CommonPrefixes        []commonPrefix
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+	}
+
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+	maxKeys = min(max(maxKeys, 1), 2)
+
+	// The token is the last key, or the last common prefix marked with a
+	// leading "/" as keys below it were returned with it
+	token := query.Get("continuation-token")
+	marker, afterPrefix := strings.CutPrefix(token, "/")
+
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	entries := 0
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= marker || (afterPrefix && strings.HasPrefix(key, marker)) {
+			continue
+		}
+
+		entry, rolledUp := key, false
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			entry, rolledUp = key[:len(prefix)+i+len(delimiter)], true
+		}
+
+		if entries == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = token
+			break
+		}
+		if rolledUp {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+			token = "/" + entry
+		} else {
+			result.Contents = append(result.Contents, object{Key: key, Size: len(s.objects[key]), LastModified: time.Now().UTC()})
+			token = key
+		}
+		marker, afterPrefix = entry, rolledUp
+		entries++
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// verify checks the Signature Version 4 authorization of a request
+func (s *s3StandIn) verify(r *http.Request) error {
+	fields := map[string]string{}
+	scheme, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if scheme != "AWS4-HMAC-SHA256" {
+		return fmt.Errorf("unsupported authorization scheme %q", scheme)
+	}
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		fields[name] = value
+	}
+
+	credential := strings.Split(fields["Credential"], "/")
+	if len(credential) != 5 || credential[0] != s.accessKey || credential[2] != s.region || credential[3] != "s3" || credential[4] != "aws4_request" {
+		return fmt.Errorf("invalid credential %q", fields["Credential"])
+	}
+	amzDate := r.Header.Get("x-amz-date")
+	signedAt, err := time.Parse("20060102T150405Z", amzDate)
+	if err != nil || time.Since(signedAt).Abs() > 15*time.Minute || credential[1] != amzDate[:8] {
+		return fmt.Errorf("invalid request date %q", amzDate)
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !slices.Contains(signed, required) {
+			return fmt.Errorf("%s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+
+	query := r.URL.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, awsQueryEscape(name)+"="+awsQueryEscape(value))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(pairs, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		r.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := strings.Join(credential[1:], "/")
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range credential[1:] {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+	if want := hex.EncodeToString(mac.Sum(nil)); !hmac.Equal([]byte(want), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+// awsQueryEscape encodes a query parameter the way Signature Version 4
+// expects, with spaces as %20
+func awsQueryEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+// byteRange returns the part of data selected by a "bytes=first-last"
+// Range header, where last is optional
+func byteRange(data []byte, header string) ([]byte, error) {
+	first, last, _ := strings.Cut(strings.TrimPrefix(header, "bytes="), "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start >= len(data) {
+		return nil, fmt.Errorf("range %q not satisfiable", header)
+	}
+	end := len(data) - 1
+	if last != "" {
+		if end, err = strconv.Atoi(last); err != nil {
+			return nil, fmt.Errorf("invalid range %q", header)
+		}
+		end = min(end, len(data)-1)
+	}
+	return data[start : end+1], nil
+}
+
+func TestS3Driver(t *testing.T) {
+	standIn, cfg := newS3StandIn(t)
+	cfg.Prefix = "/base/"
+
+	testDriver(t, NewS3Driver(cfg))
+
+	// Everything testDriver left behind lives under the prefix
+	if err := NewS3Driver(cfg).Mkdir("/with space/and+plus"); err != nil {
+		t.Fatalf("mkdir with escaped characters: %v", err)
+	}
+	for _, key := range standIn.keys() {
+		if !strings.HasPrefix(key, "base/") {
+			t.Errorf("object %q is outside the prefix", key)
+		}
+	}
+}
+
+func TestS3DriverListsEveryPage(t *testing.T) {
+	_, cfg := newS3StandIn(t)
+	d := NewS3Driver(cfg)
+
+	var want []string
+	for i := range 7 {
+		name := fmt.Sprintf("file%d.txt", i)
+		w, err := d.Create("/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		want = append(want, name)
+	}
+	d.Mkdir("/dir/a")
+	d.Mkdir("/dir/b")
+	want = append([]string{"dir"}, want...)
+
+	infos, err := d.List("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, info.Name())
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("list: got %v, want %v", got, want)
+	}
+}
+
+func TestS3DriverRejectedSignature(t *testing.T) {
+	_, cfg := newS3StandIn(t)
+	cfg.SecretKey = "wrong"
+
+	_, err := NewS3Driver(cfg).Stat("/file.txt")
+	if err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stat with a wrong secret: got %v, want an error", err)
+	}
+}
+
+func TestS3DriverStalledResponse(t *testing.T) {
+	defer func(timeout time.Duration) { s3Timeout = timeout }(s3Timeout)
+	s3Timeout = 200 * time.Millisecond
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			<-done // Never answers
+			return
+		}
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-done // Never sends the rest
+	}))
+	defer server.Close()
+	defer close(done)
+
+	d := NewS3Driver(config.S3Config{Endpoint: server.URL, Bucket: "files", Region: "us-east-1"})
+
+	start := time.Now()
+	if _, err := d.Stat("/file.txt"); err == nil {
+		t.Errorf("stat without an answer succeeded")
+	}
+
+	r, err := d.Open("/file.txt", 0, -1)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); err == nil {
+		t.Errorf("read of a stalled body succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("stalled requests took %v to give up", elapsed)
+	}
+}
+
+func TestS3StorageKeepsHomesApart(t *testing.T) {
+	standIn, s3 := newS3StandIn(t)
+	s3.Prefix = "shared"
+	storage := config.StorageConfig{Driver: config.StorageS3, S3: s3}
+
+	cfg := config.DefaultConfig()
+	cfg.Data, cfg.State = t.TempDir(), t.TempDir()
+	cfg.Services.FTP.Enabled = true
+	cfg.Users = map[string]*config.User{
+		"alice": {Pass: "x", Path: "/alice", Permissions: "rw", Storage: storage},
+		"bob":   {Pass: "x", Path: "/bob", Permissions: "rw", Storage: storage},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := NewFileSystem(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := cfg.Users["alice"], cfg.Users["bob"]
+
+	w, err := fsys.WriteFile(alice, "/alice/notes.txt", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(w, "alice's notes")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := fsys.ListDirectory(bob, "/bob")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		t.Errorf("bob's home lists %s", file.Name)
+	}
+	if _, err := fsys.ReadFile(bob, "/bob/notes.txt"); err == nil {
+		t.Errorf("bob can read alice's notes")
+	}
+
+	r, err := fsys.ReadFile(alice, "/alice/notes.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if content, _ := io.ReadAll(r); string(content) != "alice's notes" {
+		t.Errorf("alice reads %q", content)
+	}
+	if got, want := standIn.keys(), []string{"shared/alice/notes.txt"}; !slices.Equal(got, want) {
+		t.Errorf("objects: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,323 @@
+package fs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// trashDir is the directory at the root of every storage backend that
+// holds deleted files, one subdirectory per user
+const trashDir = "/.trash"
+
+// trashStateDir is the directory inside the state directory that holds
+// the metadata of trashed files
+const trashStateDir = "trash"
+
+// TrashEntry describes a deleted file or directory kept in the trash
+type TrashEntry struct {
+	ID      string    `json:"id"`
+	User    string    `json:"user"`
+	Path    string    `json:"path"` // original data-relative path
+	Deleted time.Time `json:"deleted"`
+	Size    int64     `json:"size"`
+	Files   int64     `json:"files"`
+	Dir     bool      `json:"dir"`
+}
+
+// isTrashName reports whether a driver name is inside the trash
+func isTrashName(name string) bool {
+	return name == trashDir || strings.HasPrefix(name, trashDir+"/")
+}
+
+// trashName returns where an entry is kept inside its storage backend
+func (e *TrashEntry) trashName() string {
+	return path.Join(trashDir, e.User, e.ID)
+}
+
+// moveToTrash moves name to the user's trash and records where it came
+// from. The freed space is returned to the user's quotas.
+func (fs *FileSystem) moveToTrash(user *config.User, driver Driver, name, requestPath string, info os.FileInfo) error {
+	random := make([]byte, 4)
+	rand.Read(random)
+
+	entry := &TrashEntry{
+		ID:      fmt.Sprintf("%d-%s", time.Now().Unix(), hex.EncodeToString(random)),
+		User:    user.Name,
+		Path:    filepath.Clean("/" + requestPath),
+		Deleted: time.Now(),
+		Dir:     info.IsDir(),
+	}
+	if info.Mode().IsRegular() {
+		entry.Size, entry.Files = info.Size(), 1
+	} else if info.IsDir() {
+		usage := treeUsage(driver, name)
+		entry.Size, entry.Files = usage.Bytes, usage.Files
+	}
+
+	if err := driver.Mkdir(path.Dir(entry.trashName())); err != nil {
+		return fmt.Errorf("failed to create trash: %w", err)
+	}
+	// The entry is written first, so nothing lands in the trash that can
+	// be neither restored nor purged
+	if err := fs.saveTrashEntry(entry); err != nil {
+		return err
+	}
+	if err := driver.Rename(name, entry.trashName()); err != nil {
+		fs.removeTrashEntry(entry.ID)
+		return fmt.Errorf("failed to move to trash: %w", err)
+	}
+
+	metrics.Trashed.Inc()
+	fs.quota.Release(user, entry.Size, entry.Files)
+	fs.quota.Save()
+	return nil
+}
+
+// ListTrash returns the trashed files of a user, or of everyone if
+// username is empty, oldest first
+func (fs *FileSystem) ListTrash(username string) ([]TrashEntry, error) {
+	dir := filepath.Join(fs.config.State, trashStateDir)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	var entries []TrashEntry
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), ".json")
+		if !ok {
+			continue
+		}
+		entry, err := fs.loadTrashEntry(id)
+		if err != nil {
+			continue // Skip unreadable entries
+		}
+		if username == "" || entry.User == username {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Deleted.Before(entries[j].Deleted)
+	})
+	return entries, nil
+}
+
+// RestoreTrash moves a trashed file back to its original path, or to to if
+// it is not empty. The target must not exist and must live on the same
+// storage backend as the original path.
+func (fs *FileSystem) RestoreTrash(id, to string) (*TrashEntry, error) {
+	entry, err := fs.loadTrashEntry(id)
+	if err != nil {
+		return nil, err
+	}
+	user, err := fs.trashUser(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	driver, _, err := fs.resolve(user, entry.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	target := entry.Path
+	if to != "" {
+		target = filepath.Clean("/" + to)
+	}
+	targetDriver, targetName, err := fs.resolveWrite(user, target)
+	if err != nil {
+		return nil, err
+	}
+	if targetDriver != driver {
+		return nil, fmt.Errorf("cannot restore %s across storage backends", target)
+	}
+	if _, err := driver.Stat(targetName); err == nil {
+		return nil, fmt.Errorf("cannot restore: %s already exists", target)
+	}
+
+	modes := user.ModesFor(target)
+	if err := fs.mkdir(user, driver, path.Dir(targetName), modes.NewDirMode()); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := driver.Rename(entry.trashName(), targetName); err != nil {
+		return nil, fmt.Errorf("failed to restore: %w", err)
+	}
+	fs.removeTrashEntry(entry.ID)
+
+	// Restored data counts again, even if that goes over the quota
+	fs.quota.Charge(user, entry.Size, entry.Files)
+	fs.quota.Save()
+
+	entry.Path = target
+	return entry, nil
+}
+
+// PurgeTrash permanently deletes trashed files deleted before cutoff and
+// returns how many entries were removed
+func (fs *FileSystem) PurgeTrash(cutoff time.Time) (int, error) {
+	entries, err := fs.ListTrash("")
+	if err != nil {
+		return 0, err
+	}
+
+	var errs []error
+	purged := 0
+	for _, entry := range entries {
+		if !entry.Deleted.Before(cutoff) {
+			continue
+		}
+		if err := fs.purgeTrashEntry(&entry); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", entry.ID, err))
+			continue
+		}
+		purged++
+	}
+
+	return purged, errors.Join(errs...)
+}
+
+// RunTrashPurge purges expired trash entries every purge interval until
+// ctx is done
+func (fs *FileSystem) RunTrashPurge(ctx context.Context, logger *utils.Logger) {
+	ticker := time.NewTicker(fs.config.Trash.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := fs.PurgeTrash(time.Now().Add(-fs.config.Trash.Retention))
+		if purged > 0 {
+			logger.Info("Purged %d expired trash entries", purged)
+		}
+		if err != nil {
+			logger.Warn("Failed to purge trash: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeTrashEntry deletes a trashed file and its metadata
+func (fs *FileSystem) purgeTrashEntry(entry *TrashEntry) error {
+	user, err := fs.trashUser(entry)
+	if err != nil {
+		return err
+	}
+	driver, _, err := fs.resolve(user, entry.Path)
+	if err != nil {
+		return err
+	}
+
+	if err := removeAll(driver, entry.trashName()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return fs.removeTrashEntry(entry.ID)
+}
+
+// trashUser returns the configured user a trash entry belongs to
+func (fs *FileSystem) trashUser(entry *TrashEntry) (*config.User, error) {
+	if user, exists := fs.config.Users[entry.User]; exists {
+		return user, nil
+	}
+	if anonymous := fs.config.AnonymousUser(); anonymous != nil && anonymous.Name == entry.User {
+		return anonymous, nil
+	}
+	return nil, fmt.Errorf("user %s no longer exists", entry.User)
+}
+
+// trashEntryPath returns the metadata file of a trash entry
+func (fs *FileSystem) trashEntryPath(id string) string {
+	return filepath.Join(fs.config.State, trashStateDir, filepath.Base(id)+".json")
+}
+
+// loadTrashEntry reads the metadata of a trash entry
+func (fs *FileSystem) loadTrashEntry(id string) (*TrashEntry, error) {
+	data, err := os.ReadFile(fs.trashEntryPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("trash entry %s not found", id)
+		}
+		return nil, fmt.Errorf("failed to read trash entry: %w", err)
+	}
+
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse trash entry %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// saveTrashEntry writes the metadata of a trash entry
+func (fs *FileSystem) saveTrashEntry(entry *TrashEntry) error {
+	if err := os.MkdirAll(filepath.Join(fs.config.State, trashStateDir), 0755); err != nil {
+		return fmt.Errorf("failed to create trash state: %w", err)
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trash entry: %w", err)
+	}
+
+	path := fs.trashEntryPath(entry.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write trash entry: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write trash entry: %w", err)
+	}
+	return nil
+}
+
+// removeTrashEntry deletes the metadata of a trash entry
+func (fs *FileSystem) removeTrashEntry(id string) error {
+	if err := os.Remove(fs.trashEntryPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove trash entry: %w", err)
+	}
+	return nil
+}
+
+// removeAll removes name and everything below it
+func removeAll(driver Driver, name string) error {
+	info, err := driver.Stat(name)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		var children []string
+		walk(driver, name, func(child string, _ os.FileInfo) error {
+			children = append(children, child)
+			return nil
+		})
+		// Walk is depth first, so deleting backwards empties directories
+		// before they are removed
+		for i := len(children) - 1; i >= 0; i-- {
+			if err := driver.Remove(children[i]); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return driver.Remove(name)
+}
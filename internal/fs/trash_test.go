@@ -0,0 +1,68 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+func enableTrash(cfg *config.Config) {
+	cfg.Trash.Enabled = true
+}
+
+func TestTrashKeepsFileWhenEntryCannotBeSaved(t *testing.T) {
+	fsys, bob := newTestFileSystem(t, enableTrash)
+	writeFile(t, fsys, bob, "/bob/notes.txt", "notes")
+	before := fsys.QuotaUsage(bob)
+
+	// A file where the entries go makes saving one fail
+	if err := os.WriteFile(filepath.Join(fsys.config.State, trashStateDir), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fsys.DeleteFile(bob, "/bob/notes.txt"); err == nil {
+		t.Fatal("delete succeeded without a trash entry")
+	}
+
+	if got := readFile(t, fsys, bob, "/bob/notes.txt"); got != "notes" {
+		t.Errorf("file after a failed delete: got %q", got)
+	}
+	if after := fsys.QuotaUsage(bob); after != before {
+		t.Errorf("usage after a failed delete: got %+v, want %+v", after, before)
+	}
+}
+
+func TestRemoveDirectoryUsesTrash(t *testing.T) {
+	fsys, bob := newTestFileSystem(t, enableTrash)
+	for _, dir := range []string{"/bob/empty", "/bob/full"} {
+		if err := fsys.CreateDirectory(bob, dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, fsys, bob, "/bob/full/notes.txt", "notes")
+
+	if err := fsys.RemoveDirectory(bob, "/bob/full"); err == nil {
+		t.Errorf("removal of a directory with files succeeded")
+	}
+	if err := fsys.RemoveDirectory(bob, "/bob/empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fsys.GetFileInfo(bob, "/bob/empty"); err == nil {
+		t.Errorf("removed directory is still there")
+	}
+
+	entries, err := fsys.ListTrash("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "/bob/empty" || !entries[0].Dir {
+		t.Fatalf("trash: got %+v, want the removed directory", entries)
+	}
+	if _, err := fsys.RestoreTrash(entries[0].ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsys.GetFileInfo(bob, "/bob/empty"); err != nil || !info.IsDir {
+		t.Errorf("restored directory: got %+v, %v", info, err)
+	}
+}
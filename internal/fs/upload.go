@@ -0,0 +1,144 @@
+package fs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// uploadPrefix marks the hidden temp files of atomic uploads
+const uploadPrefix = ".ftp-aio-upload-"
+
+// WriteOptions control how WriteFile stores an upload
+type WriteOptions struct {
+	// Atomic streams the upload into a hidden temp file in the same
+	// directory and only renames it into place once the upload completes
+	Atomic bool
+}
+
+// FileWriter is an upload in progress. Close completes the upload, Abort
+// gives up on it and discards the data where possible.
+type FileWriter interface {
+	io.WriteCloser
+	Abort() error
+}
+
+// IsUploadTemp returns true if name is the temp file of an atomic upload
+func IsUploadTemp(name string) bool {
+	return strings.HasPrefix(path.Base(name), uploadPrefix)
+}
+
+// upload writes a file through a storage driver while charging the data
+// against the user's quotas
+type upload struct {
+	fs        *FileSystem
+	user      *config.User
+	driver    Driver
+	name      string
+	path      string // data-relative path of the target
+	temp      string // temp file name, empty when writing in place
+	file      io.WriteCloser
+	written   int64
+	oldSize   int64 // size of the file being replaced
+	existed   bool
+	versioned bool   // the replaced file is kept as a version
+	release   func() // releases the file lock
+	done      bool
+}
+
+// uploadTempName returns a hidden temp name next to name
+func uploadTempName(name string) string {
+	random := make([]byte, 6)
+	rand.Read(random)
+	return path.Join(path.Dir(name), uploadPrefix+path.Base(name)+"-"+hex.EncodeToString(random))
+}
+
+// Write reserves quota before passing the data on
+func (u *upload) Write(p []byte) (int, error) {
+	if err := u.fs.quota.Reserve(u.user, int64(len(p)), 0); err != nil {
+		return 0, err
+	}
+
+	n, err := u.file.Write(p)
+	u.written += int64(n)
+	if n < len(p) {
+		u.fs.quota.Release(u.user, int64(len(p)-n), 0)
+	}
+	return n, err
+}
+
+// Close completes the upload. Atomic uploads are synced to disk and
+// renamed over the target.
+func (u *upload) Close() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	defer u.fs.quota.Save()
+	defer u.release()
+
+	if u.temp == "" {
+		return u.file.Close()
+	}
+
+	if syncer, ok := u.file.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			u.discard()
+			return err
+		}
+	}
+	if err := u.file.Close(); err != nil {
+		u.discard()
+		return err
+	}
+
+	var version string
+	if u.versioned {
+		var err error
+		if version, err = u.fs.keepVersion(u.user, u.driver, u.name, u.path); err != nil {
+			u.discard()
+			return err
+		}
+	}
+	if err := u.driver.Rename(u.temp, u.name); err != nil {
+		u.fs.restoreVersion(u.user, u.driver, version, u.name)
+		u.discard()
+		return err
+	}
+
+	// The replaced file only stops counting once the new one is in place
+	if u.existed {
+		u.fs.quota.Release(u.user, u.oldSize, 0)
+	}
+	return nil
+}
+
+// Abort gives up on the upload. Atomic uploads remove their temp file and
+// leave any existing target untouched, in-place uploads keep what was written.
+func (u *upload) Abort() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	defer u.fs.quota.Save()
+	defer u.release()
+
+	err := u.file.Close()
+	if u.temp != "" {
+		u.discard()
+	}
+	return err
+}
+
+// discard removes the temp file and returns its quota
+func (u *upload) discard() {
+	u.driver.Remove(u.temp)
+	u.fs.quota.Release(u.user, u.written, 0)
+	if !u.existed {
+		u.fs.quota.Release(u.user, 0, 1)
+	}
+}
@@ -0,0 +1,213 @@
+package fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+)
+
+// versionsDir is the hidden directory next to versioned files that holds
+// their previous versions, named like "file.txt@v3"
+const versionsDir = ".versions"
+
+// versionSeparator joins a file name and its version number
+const versionSeparator = "@v"
+
+// fileVersion is one stored version of a file
+type fileVersion struct {
+	name   string
+	number int
+	info   os.FileInfo
+}
+
+// isVersionName reports whether a driver name is a versions directory or
+// inside one
+func isVersionName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == versionsDir {
+			return true
+		}
+	}
+	return false
+}
+
+// versionName returns the name version n of a file is stored under
+func versionName(name string, n int) string {
+	return path.Join(path.Dir(name), versionsDir, path.Base(name)+versionSeparator+strconv.Itoa(n))
+}
+
+// listVersions returns the stored versions of a file, oldest first
+func listVersions(driver Driver, name string) ([]fileVersion, error) {
+	dir := path.Join(path.Dir(name), versionsDir)
+	entries, err := driver.List(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	prefix := path.Base(name) + versionSeparator
+	var versions []fileVersion
+	for _, info := range entries {
+		suffix, ok := strings.CutPrefix(info.Name(), prefix)
+		if !ok || !info.Mode().IsRegular() {
+			continue
+		}
+		n, err := strconv.Atoi(suffix)
+		if err != nil || n < 1 {
+			continue
+		}
+		versions = append(versions, fileVersion{name: path.Join(dir, info.Name()), number: n, info: info})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].number < versions[j].number
+	})
+	return versions, nil
+}
+
+// keepVersion moves the current content of name into its versions
+// directory as the next version, then prunes versions beyond the limits
+// that apply to requestPath. It returns the name of the new version, or
+// an empty name if there was no file to keep.
+func (fs *FileSystem) keepVersion(user *config.User, driver Driver, name, requestPath string) (string, error) {
+	current, err := driver.Stat(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	versions, err := listVersions(driver, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to list versions: %w", err)
+	}
+	next := 1
+	if len(versions) > 0 {
+		next = versions[len(versions)-1].number + 1
+	}
+
+	// Versions count against the owner's quotas like any other file, less
+	// the older versions that keeping this one prunes
+	expired := expiredVersions(versions, current, user.VersioningFor(requestPath), time.Now())
+	bytes, files := current.Size(), int64(1)
+	for _, v := range expired {
+		bytes -= v.info.Size()
+		files--
+	}
+	if err := fs.quota.Reserve(user, bytes, files); err != nil {
+		return "", fmt.Errorf("cannot keep version: %w", err)
+	}
+
+	modes := user.ModesFor(requestPath)
+	if err := fs.mkdir(user, driver, path.Join(path.Dir(name), versionsDir), modes.NewDirMode()); err != nil {
+		fs.quota.Release(user, bytes, files)
+		return "", fmt.Errorf("failed to create versions directory: %w", err)
+	}
+	version := versionName(name, next)
+	if err := driver.Rename(name, version); err != nil {
+		fs.quota.Release(user, bytes, files)
+		return "", fmt.Errorf("failed to keep version: %w", err)
+	}
+	metrics.VersionsKept.Inc()
+
+	// Pruning is best effort, a version left behind stays charged
+	for _, v := range expired {
+		if err := driver.Remove(v.name); err != nil {
+			fs.quota.Charge(user, v.info.Size(), 1)
+		}
+	}
+	return version, nil
+}
+
+// moveVersions moves the stored versions of a file renamed from from to
+// to along with it, numbered after any versions to already has. A version
+// that cannot be moved is removed, so that none is left charged to the
+// user where it can no longer be found.
+func (fs *FileSystem) moveVersions(user *config.User, driver Driver, from, to, requestPath string) {
+	versions, err := listVersions(driver, from)
+	if err != nil || len(versions) == 0 {
+		return
+	}
+	next := 1
+	existing, err := listVersions(driver, to)
+	if len(existing) > 0 {
+		next = existing[len(existing)-1].number + 1
+	}
+
+	modes := user.ModesFor(requestPath)
+	if err == nil {
+		err = fs.mkdir(user, driver, path.Join(path.Dir(to), versionsDir), modes.NewDirMode())
+	}
+	for _, v := range versions {
+		if err == nil && driver.Rename(v.name, versionName(to, next)) == nil {
+			next++
+			continue
+		}
+		if driver.Remove(v.name) == nil {
+			fs.quota.Release(user, v.info.Size(), 1)
+		}
+	}
+
+	// Left empty, the old versions directory goes too
+	driver.Remove(path.Join(path.Dir(from), versionsDir))
+}
+
+// expiredVersions returns the older versions beyond the count and age
+// limits once a new version is kept. The new version is not in versions
+// and always survives. A version's age counts from when it was replaced,
+// which is when its successor was written.
+func expiredVersions(versions []fileVersion, replaced os.FileInfo, settings config.VersioningConfig, now time.Time) []fileVersion {
+	maxVersions, maxAge := *settings.MaxVersions, *settings.MaxAge
+
+	var expired []fileVersion
+	for i, version := range versions {
+		successor := replaced.ModTime()
+		if i+1 < len(versions) {
+			successor = versions[i+1].info.ModTime()
+		}
+
+		// The new version takes one of the kept slots
+		tooMany := maxVersions > 0 && len(versions)-i >= maxVersions
+		tooOld := maxAge > 0 && now.Sub(successor) > maxAge
+		if tooMany || tooOld {
+			expired = append(expired, version)
+		}
+	}
+	return expired
+}
+
+// restoreVersion moves a version kept by keepVersion back in place after
+// the replacement failed, and takes back what keeping it charged
+func (fs *FileSystem) restoreVersion(user *config.User, driver Driver, version, name string) {
+	if version == "" {
+		return
+	}
+	info, err := driver.Stat(version)
+	if err != nil {
+		return
+	}
+	if driver.Rename(version, name) == nil {
+		fs.quota.Release(user, info.Size(), 1)
+	}
+}
+
+// resolveWrite resolves a path that is about to be changed. Versions
+// directories are maintained by the server and can only be read.
+func (fs *FileSystem) resolveWrite(user *config.User, path string) (Driver, string, error) {
+	driver, name, err := fs.resolve(user, path)
+	if err == nil && isVersionName(name) {
+		return nil, "", &os.PathError{Op: "write", Path: path, Err: os.ErrPermission}
+	}
+	return driver, name, err
+}
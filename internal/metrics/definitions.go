@@ -0,0 +1,60 @@
+package metrics
+
+// durationBuckets are the histogram buckets for transfer durations in seconds
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// Session and login metrics, labelled by service ("ftp", "tftp")
+var (
+	ActiveSessions = NewGaugeVec("ftpaio_active_sessions",
+		"Sessions currently open, for TFTP the transfers in progress.", "service")
+	Logins = NewCounterVec("ftpaio_logins_total",
+		"Login attempts by result: success, failure, denied, banned or locked_out.", "service", "result")
+	Errors = NewCounterVec("ftpaio_errors_total",
+		"Error responses sent to clients by protocol response code.", "service", "code")
+)
+
+// Service metrics, labelled by service name
+var (
+	ServiceUp = NewGaugeVec("ftpaio_service_up",
+		"1 while a service is running, 0 while it is starting, failed or stopped.", "service")
+	ServiceRestarts = NewCounterVec("ftpaio_service_restarts_total",
+		"Restarts of services that stopped on their own or failed to start.", "service")
+)
+
+// Transfer metrics, direction is "in" for uploads and "out" for downloads
+var (
+	TransferBytes = NewCounterVec("ftpaio_transfer_bytes_total",
+		"Bytes transferred by service, user and direction.", "service", "user", "direction")
+	TransferDuration = NewHistogramVec("ftpaio_transfer_duration_seconds",
+		"Duration of completed and failed transfers.", durationBuckets, "service", "direction")
+)
+
+// Protocol specific metrics
+var (
+	PassivePorts = NewGaugeVec("ftpaio_ftp_passive_ports",
+		"Size of the FTP passive port pool, 0 when any port may be used.")
+	PassivePortsInUse = NewGaugeVec("ftpaio_ftp_passive_ports_in_use",
+		"FTP passive data ports currently listening.")
+	TFTPRetransmits = NewCounterVec("ftpaio_tftp_retransmits_total",
+		"TFTP packets sent again after a timeout.")
+)
+
+// File system metrics
+var (
+	LockConflicts = NewCounterVec("ftpaio_lock_conflicts_total",
+		"Transfers refused because the file was in use.")
+	QuotaRejections = NewCounterVec("ftpaio_quota_rejections_total",
+		"Writes refused because they would exceed a quota.", "user")
+	HashCache = NewCounterVec("ftpaio_hash_cache_total",
+		"Hash cache lookups by result: hit or miss.", "result")
+	VersionsKept = NewCounterVec("ftpaio_versions_kept_total",
+		"Previous versions kept when files were overwritten.")
+	Trashed = NewCounterVec("ftpaio_trashed_total",
+		"Files and directories moved to the trash.")
+)
+
+// Directions of a transfer
+const (
+	In  = "in"
+	Out = "out"
+)
@@ -0,0 +1,281 @@
+// Package metrics collects counters, gauges and histograms and exposes them
+// in the Prometheus text format. Metrics register themselves with the
+// package when created, so instrumented code only needs the variables
+// declared in this package.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metric is anything that can write itself in the text format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// registry holds every metric created by this package
+var registry struct {
+	mutex   sync.Mutex
+	metrics []metric
+}
+
+// register adds a metric to the registry
+func register(m metric) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+// Write writes all metrics in the Prometheus text format, sorted by name
+func Write(w io.Writer) {
+	registry.mutex.Lock()
+	metrics := append([]metric(nil), registry.metrics...)
+	registry.mutex.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].name() < metrics[j].name()
+	})
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics over HTTP
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w)
+	})
+}
+
+// desc is the name, help text and label names shared by all metric kinds
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
+}
+
+func (d *desc) name() string { return d.metricName }
+
+// header writes the HELP and TYPE lines
+func (d *desc) header(w io.Writer, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, d.help, d.metricName, kind)
+}
+
+// key joins label values into a map key
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s wants %d label values, got %d", d.metricName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats label values as {name="value",...}, with extra pairs
+// appended, or nothing if there are no labels
+func (d *desc) labelPairs(values []string, extra ...string) string {
+	var pairs []string
+	for i, label := range d.labels {
+		pairs = append(pairs, label+`="`+escape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes a label value
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue formats a sample value
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// series is the value of one label combination
+type series struct {
+	labels []string
+	value  float64
+}
+
+// valueVec holds the values of a counter or gauge by label values
+type valueVec struct {
+	desc
+	kind   string
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+func newValueVec(kind, name, help string, labels []string) *valueVec {
+	v := &valueVec{desc: desc{metricName: name, help: help, labels: labels}, kind: kind, series: make(map[string]*series)}
+	register(v)
+	return v
+}
+
+// add changes the value of a label combination
+func (v *valueVec) add(delta float64, values []string, set bool) {
+	key := v.key(values)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	s, exists := v.series[key]
+	if !exists {
+		s = &series{labels: append([]string(nil), values...)}
+		v.series[key] = s
+	}
+	if set {
+		s.value = delta
+	} else {
+		s.value += delta
+	}
+}
+
+func (v *valueVec) write(w io.Writer) {
+	v.header(w, v.kind)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	// Metrics without labels always report a value
+	if len(v.labels) == 0 && len(v.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.metricName)
+	}
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := v.series[key]
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, v.labelPairs(s.labels), formatValue(s.value))
+	}
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct{ v *valueVec }
+
+// NewCounterVec creates and registers a counter
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{newValueVec("counter", name, help, labels)}
+}
+
+// Inc adds one to the counter for the label values
+func (c *CounterVec) Inc(values ...string) { c.v.add(1, values, false) }
+
+// Add adds a non-negative amount to the counter for the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	if delta > 0 {
+		c.v.add(delta, values, false)
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct{ v *valueVec }
+
+// NewGaugeVec creates and registers a gauge
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{newValueVec("gauge", name, help, labels)}
+}
+
+// Set sets the gauge for the label values
+func (g *GaugeVec) Set(value float64, values ...string) { g.v.add(value, values, true) }
+
+// Inc adds one to the gauge for the label values
+func (g *GaugeVec) Inc(values ...string) { g.v.add(1, values, false) }
+
+// Dec subtracts one from the gauge for the label values
+func (g *GaugeVec) Dec(values ...string) { g.v.add(-1, values, false) }
+
+// histogramSeries holds the observations of one label combination
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mutex   sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+// NewHistogramVec creates and registers a histogram with the given upper
+// bucket bounds in increasing order
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{metricName: name, help: help, labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	register(h)
+	return h
+}
+
+// Observe records a value for the label values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	key := h.key(values)
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w, "histogram")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.labels, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, h.labelPairs(s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, h.labelPairs(s.labels), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, h.labelPairs(s.labels), s.count)
+	}
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/auth"
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// AdminServer serves the HTTP API to inspect and control the running
+// server. Every request needs the configured bearer token.
+type AdminServer struct {
+	config        *config.Config
+	logger        *utils.Logger
+	manager       *Manager
+	authenticator *auth.Authenticator
+	server        *http.Server
+	listener      net.Listener
+}
+
+// userRequest is the body of a request adding a user
+type userRequest struct {
+	Name         string   `json:"name"`
+	Pass         string   `json:"pass"`
+	UID          int      `json:"uid"`
+	GID          *int     `json:"gid"`
+	Path         string   `json:"path"`
+	Permissions  string   `json:"permissions"`
+	Groups       []string `json:"groups"`
+	DownloadRate int64    `json:"download_rate"`
+	UploadRate   int64    `json:"upload_rate"`
+	Disabled     bool     `json:"disabled"`
+}
+
+// userInfo describes a user, without the password
+type userInfo struct {
+	Name        string   `json:"name"`
+	Path        string   `json:"path"`
+	Permissions string   `json:"permissions"`
+	Groups      []string `json:"groups,omitempty"`
+	Disabled    bool     `json:"disabled"`
+}
+
+// banRequest is the body of a request banning an address
+type banRequest struct {
+	IP       string `json:"ip"`
+	Duration string `json:"duration"` // such as "1h", empty for the escalating ban duration
+	Reason   string `json:"reason"`
+}
+
+// NewAdminServer creates a new admin API server
+func NewAdminServer(cfg *config.Config, logger *utils.Logger, manager *Manager, authenticator *auth.Authenticator) *AdminServer {
+	s := &AdminServer{
+		config:        cfg,
+		logger:        logger.Subsystem("admin"),
+		manager:       manager,
+		authenticator: authenticator,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleListSessions)
+	mux.HandleFunc("DELETE /sessions/{id}", s.handleKickSession)
+	mux.HandleFunc("GET /bans", s.handleListBans)
+	mux.HandleFunc("POST /bans", s.handleBan)
+	mux.HandleFunc("DELETE /bans/{ip}", s.handleUnban)
+	mux.HandleFunc("GET /users", s.handleListUsers)
+	mux.HandleFunc("POST /users", s.handleAddUser)
+	mux.HandleFunc("DELETE /users/{name}", s.handleRemoveUser)
+	mux.HandleFunc("POST /users/{name}/disable", s.handleDisableUser)
+	mux.HandleFunc("POST /users/{name}/enable", s.handleDisableUser)
+	mux.HandleFunc("GET /services", s.handleListServices)
+	mux.HandleFunc("POST /services/{name}/start", s.handleControlService)
+	mux.HandleFunc("POST /services/{name}/stop", s.handleControlService)
+
+	s.server = &http.Server{
+		Handler:           s.authorize(mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Listen binds the admin listener
+func (s *AdminServer) Listen() error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+
+	s.logger.Info("Admin API available at %s", listener.Addr())
+	return nil
+}
+
+// Serve answers requests until the server is stopped or ctx is done
+func (s *AdminServer) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.server.Close() })
+	defer stop()
+
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// listen takes the socket passed by systemd, or opens the unix socket, or
+// the TCP listener if there is none
+func (s *AdminServer) listen() (net.Listener, error) {
+	if listener, err := s.manager.sockets.Listener("admin"); listener != nil || err != nil {
+		return listener, err
+	}
+
+	socket := s.config.Admin.Socket
+	if socket == "" {
+		listener, err := net.Listen("tcp", s.config.Admin.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", s.config.Admin.Listen, err)
+		}
+		return listener, nil
+	}
+
+	// A socket left behind by an earlier run would fail the listen
+	if info, err := os.Lstat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict access to %s: %w", socket, err)
+	}
+	return listener, nil
+}
+
+// Stop stops the admin server
+func (s *AdminServer) Stop() error {
+	// The listener is only closed by the HTTP server once it serves
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	return s.server.Close()
+}
+
+// Shutdown stops accepting requests and waits for those in progress
+// until ctx is done, when they are cut off
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return err
+	}
+	return nil
+}
+
+// Name returns the server name
+func (s *AdminServer) Name() string {
+	return "Admin"
+}
+
+// Port returns the port the server is listening on, zero on a unix socket
+func (s *AdminServer) Port() int {
+	if s.config.Admin.Socket != "" {
+		return 0
+	}
+	_, port, _ := net.SplitHostPort(s.config.Admin.Listen)
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// authorize rejects requests without the configured token
+func (s *AdminServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.config.Admin.Token
+		if token != "" {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				s.logger.Warn("Rejected admin request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleListSessions lists the sessions of all services
+func (s *AdminServer) handleListSessions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Sessions().List())
+}
+
+// handleKickSession ends a session
+func (s *AdminServer) handleKickSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.manager.Sessions().Kick(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no session %s", id))
+		return
+	}
+	s.logger.Info("Kicked session %s", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListBans lists the banned addresses
+func (s *AdminServer) handleListBans(w http.ResponseWriter, r *http.Request) {
+	guard := s.guard(w)
+	if guard == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, guard.Bans())
+}
+
+// handleBan bans an address and ends its sessions
+func (s *AdminServer) handleBan(w http.ResponseWriter, r *http.Request) {
+	guard := s.guard(w)
+	if guard == nil {
+		return
+	}
+
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	ip := net.ParseIP(req.IP)
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid ip '%s'", req.IP))
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration '%s'", req.Duration))
+			return
+		}
+		duration = d
+	}
+	if req.Reason == "" {
+		req.Reason = "banned by the administrator"
+	}
+
+	guard.Ban(ip, duration, req.Reason)
+	kicked := s.manager.Sessions().KickIP(ip)
+	s.logger.Info("Banned %s, ended %d sessions", ip, kicked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleUnban lifts the ban of an address
+func (s *AdminServer) handleUnban(w http.ResponseWriter, r *http.Request) {
+	guard := s.guard(w)
+	if guard == nil {
+		return
+	}
+
+	ip := net.ParseIP(r.PathValue("ip"))
+	if ip == nil || !guard.Unban(ip) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s is not banned", r.PathValue("ip")))
+		return
+	}
+	s.logger.Info("Lifted the ban of %s", ip)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// guard returns the brute-force guard, answering the request if
+// protection is disabled
+func (s *AdminServer) guard(w http.ResponseWriter) *auth.Guard {
+	guard := s.authenticator.Guard()
+	if guard == nil {
+		writeError(w, http.StatusConflict, errors.New("brute-force protection is disabled"))
+	}
+	return guard
+}
+
+// handleListUsers lists the users
+func (s *AdminServer) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	var users []userInfo
+	for _, name := range s.authenticator.ListUsers() {
+		user, exists := s.authenticator.GetUser(name)
+		if !exists {
+			continue
+		}
+		users = append(users, userInfo{
+			Name:        user.Name,
+			Path:        user.Path,
+			Permissions: user.Permissions,
+			Groups:      user.Groups,
+			Disabled:    user.Disabled,
+		})
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// handleAddUser adds a user until the server stops. The configuration
+// file is not changed.
+func (s *AdminServer) handleAddUser(w http.ResponseWriter, r *http.Request) {
+	var req userRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	user := &config.User{
+		Pass:         req.Pass,
+		UID:          req.UID,
+		GID:          req.GID,
+		Path:         req.Path,
+		Permissions:  req.Permissions,
+		Groups:       req.Groups,
+		DownloadRate: req.DownloadRate,
+		UploadRate:   req.UploadRate,
+		Disabled:     req.Disabled,
+	}
+	if err := s.manager.Config().ValidateUser(req.Name, user); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.authenticator.AddUser(user); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+
+	s.logger.Info("Added user %s", user.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleRemoveUser removes a user and ends their sessions
+func (s *AdminServer) handleRemoveUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.authenticator.RemoveUser(name) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no user %s", name))
+		return
+	}
+	kicked := s.manager.Sessions().KickUser(name)
+	s.logger.Info("Removed user %s, ended %d sessions", name, kicked)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDisableUser disables a user, ending their sessions, or enables one
+func (s *AdminServer) handleDisableUser(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	disable := strings.HasSuffix(r.URL.Path, "/disable")
+	if !s.authenticator.SetDisabled(name, disable) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no user %s", name))
+		return
+	}
+
+	if disable {
+		kicked := s.manager.Sessions().KickUser(name)
+		s.logger.Info("Disabled user %s, ended %d sessions", name, kicked)
+	} else {
+		s.logger.Info("Enabled user %s", name)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListServices lists the services that can be controlled
+func (s *AdminServer) handleListServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.manager.Services())
+}
+
+// handleControlService starts or stops a service
+func (s *AdminServer) handleControlService(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var err error
+	if strings.HasSuffix(r.URL.Path, "/start") {
+		err = s.manager.StartService(name)
+	} else {
+		err = s.manager.StopService(name)
+	}
+	switch {
+	case errors.Is(err, ErrUnknownService):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusConflict, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeJSON answers with a JSON document
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an error message
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
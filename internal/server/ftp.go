@@ -3,16 +3,22 @@ package server
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Merith-TK/ftp-aio/internal/audit"
 	"github.com/Merith-TK/ftp-aio/internal/auth"
 	"github.com/Merith-TK/ftp-aio/internal/config"
 	"github.com/Merith-TK/ftp-aio/internal/fs"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
 	"github.com/Merith-TK/ftp-aio/internal/utils"
 )
 
@@ -22,84 +28,150 @@ type FTPServer struct {
 	logger        *utils.Logger
 	authenticator *auth.Authenticator
 	fileSystem    *fs.FileSystem
+	audit         *audit.Recorder
+	sessions      *SessionRegistry
+	sockets       *Sockets
 	listener      net.Listener
+	access        atomic.Pointer[[]*auth.AccessList] // swapped in place by a reload
 	done          chan struct{}
+	stopOnce      sync.Once
 	pasvMinPort   int
 	pasvMaxPort   int
 }
 
 // FTPConnection represents a single FTP connection
 type FTPConnection struct {
-	conn         net.Conn
-	server       *FTPServer
-	user         *config.User
-	username     string
-	currentDir   string
-	pasvListener net.Listener
+	conn          net.Conn
+	server        *FTPServer
+	session       string
+	logger        *utils.Logger // carries the session, and the user once logged in
+	sessionLogger *utils.Logger // carries the session only
+	status        *Session      // listed by the admin API
+	user          *config.User
+	username      string
+	currentDir    string
+	pasvListener  net.Listener
+	hashAlgorithm string // selected with OPTS HASH, SHA-256 when empty
+	copyFrom      string // source set by SITE CPFR
+
+	mutex   sync.Mutex // guards busy and closing against drain
+	busy    bool       // running a command, such as a transfer
+	closing bool       // said goodbye as the server shuts down
 }
 
 // NewFTPServer creates a new FTP server
-func NewFTPServer(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem) *FTPServer {
+func NewFTPServer(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem, recorder *audit.Recorder, sessions *SessionRegistry, sockets *Sockets) *FTPServer {
 	return &FTPServer{
 		config:        cfg,
-		logger:        logger,
+		logger:        logger.Subsystem("ftp"),
 		authenticator: authenticator,
 		fileSystem:    fileSystem,
+		audit:         recorder,
+		sessions:      sessions,
+		sockets:       sockets,
 		done:          make(chan struct{}),
 		pasvMinPort:   2122, // Start just above the FTP control port
 		pasvMaxPort:   2132, // Small range for better firewall compatibility
 	}
 }
 
-// Start starts the FTP server
-func (s *FTPServer) Start(ctx context.Context) error {
+// Listen binds the FTP control port
+func (s *FTPServer) Listen() error {
 	port := s.config.Services.FTP.Port
 
-	// Start listening
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	// Global and service network restrictions
+	access, err := serviceAccess(s.config, "ftp", s.config.Services.FTP.Access)
+	if err != nil {
+		return err
+	}
+	s.SetAccess(access)
+
+	// Take the socket passed by systemd, if any
+	listener, err := s.sockets.Listener("ftp")
 	if err != nil {
-		return fmt.Errorf("failed to listen on port %d: %w", port, err)
+		return err
+	}
+	if listener != nil {
+		s.logger.Info("FTP server listening on %s, passed by systemd", listener.Addr())
+	} else {
+		// Start listening
+		listener, err = net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			return fmt.Errorf("failed to listen on port %d: %w", port, err)
+		}
+		s.logger.Info("FTP server listening on port %d", port)
 	}
 	s.listener = listener
+	metrics.PassivePorts.Set(float64(s.pasvMaxPort - s.pasvMinPort + 1))
+	return nil
+}
 
-	s.logger.Info("FTP server listening on port %d", port)
+// Serve accepts connections until the server is stopped or ctx is done. It
+// returns an error if the listener fails.
+func (s *FTPServer) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.listener.Close() })
+	defer stop()
 
-	// Accept connections in a goroutine
-	go func() {
-		for {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
 			select {
 			case <-s.done:
-				return
+				return nil
 			default:
-				conn, err := listener.Accept()
-				if err != nil {
-					select {
-					case <-s.done:
-						return
-					default:
-						s.logger.Error("Failed to accept FTP connection: %v", err)
-						continue
-					}
-				}
-
-				// Handle connection in a goroutine
-				go s.handleConnection(conn)
 			}
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("FTP listener closed: %w", err)
+			}
+			// Such as running out of file descriptors, which may pass
+			s.logger.Error("Failed to accept FTP connection: %v", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
 		}
-	}()
 
-	// Wait for context cancellation
-	<-ctx.Done()
-	return nil
+		// Drop clients from networks that are not permitted
+		if err := auth.CheckAccess(remoteIP(conn.RemoteAddr()), *s.access.Load()...); err != nil {
+			s.logger.Warn("Rejected FTP connection: %v", err)
+			conn.Close()
+			continue
+		}
+
+		// Handle connection in a goroutine
+		go s.handleConnection(conn)
+	}
 }
 
 // Stop stops the FTP server
 func (s *FTPServer) Stop() error {
-	close(s.done)
-	if s.listener != nil {
-		return s.listener.Close()
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.done)
+		if s.listener != nil {
+			err = s.listener.Close()
+		}
+	})
+	return err
+}
+
+// Shutdown stops accepting connections and lets the sessions finish their
+// transfers, including those of servers replaced by a reload. Idle sessions
+// are sent 421 at once, busy ones after their command. Sessions still busy
+// when ctx is done are closed.
+func (s *FTPServer) Shutdown(ctx context.Context) error {
+	err := s.Stop()
+	if closed := s.sessions.Drain(ctx, "ftp"); closed > 0 {
+		return fmt.Errorf("closed %d sessions that did not finish in time", closed)
 	}
-	return nil
+	return err
+}
+
+// SetAccess replaces the global and service access lists new clients are
+// checked against
+func (s *FTPServer) SetAccess(access []*auth.AccessList) {
+	s.access.Store(&access)
 }
 
 // Name returns the server name
@@ -116,19 +188,40 @@ func (s *FTPServer) Port() int {
 func (s *FTPServer) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
-	s.logger.Debug("New FTP connection from %s", conn.RemoteAddr())
+	session := utils.NewSessionID()
+	logger := s.logger.With("session", session, "service", "ftp", "remote", conn.RemoteAddr().String())
+	logger.Debug("New FTP connection from %s", conn.RemoteAddr())
+
+	// Refuse banned clients before they can try again
+	if s.authenticator.IsBanned(remoteIP(conn.RemoteAddr())) {
+		logger.Debug("Refusing FTP connection from banned address %s", conn.RemoteAddr())
+		conn.Write([]byte("421 Too many failed logins, try again later\r\n"))
+		return
+	}
+
+	metrics.ActiveSessions.Inc("ftp")
+	defer metrics.ActiveSessions.Dec("ftp")
 
 	ftpConn := &FTPConnection{
-		conn:       conn,
-		server:     s,
-		currentDir: "/",
+		conn:          conn,
+		server:        s,
+		session:       session,
+		logger:        logger,
+		sessionLogger: logger,
+		currentDir:    "/",
 	}
 
+	// Kicking the session from the admin API closes the control connection
+	ftpConn.status = s.sessions.Open(session, "ftp", conn.RemoteAddr().String(), func() { conn.Close() }, ftpConn.drain)
+	defer s.sessions.Close(ftpConn.status)
+
 	// Send welcome message
 	ftpConn.sendResponse(220, "FTP-AIO Server Ready")
 
 	// Handle commands
 	ftpConn.handleCommands()
+	ftpConn.logout()
+	ftpConn.closePassive()
 }
 
 // sendResponse sends an FTP response
@@ -141,7 +234,63 @@ func (c *FTPConnection) sendResponse(code int, message string) {
 		response = fmt.Sprintf("%d %s\r\n", code, message)
 	}
 	c.conn.Write([]byte(response))
-	c.server.logger.Debug("FTP response: %s", strings.TrimSpace(response))
+	c.logger.Debug("FTP response: %s", strings.TrimSpace(response))
+
+	if code >= 400 {
+		metrics.Errors.Inc("ftp", strconv.Itoa(code))
+	}
+}
+
+// closePassive closes the passive listener if there is one
+func (c *FTPConnection) closePassive() {
+	if c.pasvListener != nil {
+		c.pasvListener.Close()
+		c.pasvListener = nil
+		metrics.PassivePortsInUse.Dec()
+	}
+}
+
+// recordTransfer records a transfer in the metrics and the transfer log
+func (c *FTPConnection) recordTransfer(direction, path string, bytes int64, start time.Time, complete bool) {
+	duration := time.Since(start)
+	metrics.TransferBytes.Add(float64(bytes), "ftp", c.user.Name, direction)
+	metrics.TransferDuration.Observe(duration.Seconds(), "ftp", direction)
+
+	c.server.audit.Transfer(audit.Transfer{
+		Duration:  duration,
+		Service:   "ftp",
+		Session:   c.session,
+		User:      c.user.Name,
+		Anonymous: c.user.Anonymous,
+		Remote:    remoteIP(c.conn.RemoteAddr()).String(),
+		Path:      path,
+		Direction: direction,
+		Bytes:     bytes,
+		Complete:  complete,
+	})
+}
+
+// auditEvent records an action of the client in the audit log, failed if
+// err is set
+func (c *FTPConnection) auditEvent(action, path, target string, err error) {
+	user := c.username
+	if c.user != nil {
+		user = c.user.Name
+	}
+	event := audit.Event{
+		Service: "ftp",
+		Session: c.session,
+		User:    user,
+		Remote:  remoteIP(c.conn.RemoteAddr()).String(),
+		Action:  action,
+		Path:    path,
+		Target:  target,
+		Success: err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	c.server.audit.Event(event)
 }
 
 // normalizePath normalizes a path by resolving . and .. components
@@ -162,6 +311,29 @@ func (c *FTPConnection) normalizePath(path string) string {
 	return cleaned
 }
 
+// resolvePath turns a name sent by the client into a normalized path,
+// taking relative names from the current directory
+func (c *FTPConnection) resolvePath(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = c.currentDir + "/" + name
+	}
+	return c.normalizePath(name)
+}
+
+// parseListArgs strips ls style options such as "-la" from LIST, NLST and
+// STAT arguments. It returns the path that remains and whether -R asked
+// for a recursive listing.
+func parseListArgs(args string) (string, bool) {
+	args = strings.TrimSpace(args)
+	recursive := false
+	for strings.HasPrefix(args, "-") {
+		option, rest, _ := strings.Cut(args, " ")
+		recursive = recursive || strings.Contains(option, "R")
+		args = strings.TrimSpace(rest)
+	}
+	return args, recursive
+}
+
 // handleCommands handles FTP commands in a loop
 func (c *FTPConnection) handleCommands() {
 	scanner := bufio.NewScanner(c.conn)
@@ -172,7 +344,12 @@ func (c *FTPConnection) handleCommands() {
 			continue
 		}
 
-		c.server.logger.Debug("FTP command: %s", line)
+		c.logger.Debug("FTP command: %s", line)
+
+		// No new commands once the server shuts down
+		if !c.beginCommand() {
+			return
+		}
 
 		parts := strings.SplitN(line, " ", 2)
 		command := strings.ToUpper(parts[0])
@@ -240,17 +417,90 @@ func (c *FTPConnection) handleCommands() {
 			c.handleMlsd(args)
 		case "OPTS":
 			c.handleOpts(args)
+		case "SITE":
+			c.handleSite(args)
+		case "STAT":
+			c.handleStat(args)
+		case "HASH":
+			c.handleHash(args, c.currentHash(), false)
+		case "XCRC":
+			c.handleHash(args, fs.HashCRC32, true)
+		case "XMD5":
+			c.handleHash(args, fs.HashMD5, true)
+		case "XSHA1":
+			c.handleHash(args, fs.HashSHA1, true)
+		case "XSHA256":
+			c.handleHash(args, fs.HashSHA256, true)
 		case "NOOP":
 			c.sendResponse(200, "OK")
 		default:
 			c.sendResponse(502, "Command not implemented")
 		}
+
+		if !c.endCommand() {
+			return
+		}
+	}
+}
+
+// beginCommand marks the connection busy. Once the server is draining it
+// says goodbye instead and returns false.
+func (c *FTPConnection) beginCommand() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closing {
+		return false
+	}
+	if c.status.Draining() {
+		c.goodbye()
+		return false
+	}
+	c.busy = true
+	return true
+}
+
+// endCommand marks the connection idle. If the server started draining
+// during the command it says goodbye and returns false.
+func (c *FTPConnection) endCommand() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.busy = false
+	if c.status.Draining() && !c.closing {
+		c.goodbye()
+		return false
+	}
+	return true
+}
+
+// drain is called when the server shuts down. An idle connection is told
+// and closed at once, a busy one by endCommand.
+func (c *FTPConnection) drain() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.busy || c.closing {
+		return
 	}
+	c.goodbye()
+	c.conn.Close()
+}
+
+// goodbye tells the client the server is going away. Must be called with
+// the mutex held.
+func (c *FTPConnection) goodbye() {
+	c.closing = true
+	c.sendResponse(421, "Server shutting down, closing control connection")
 }
 
 // handleUser handles the USER command
 func (c *FTPConnection) handleUser(username string) {
 	c.username = username
+	if config.IsAnonymousName(username) && c.server.authenticator.Anonymous() != nil {
+		c.sendResponse(331, "Guest login ok, send your email address as password")
+		return
+	}
 	c.sendResponse(331, "Password required")
 }
 
@@ -262,13 +512,56 @@ func (c *FTPConnection) handlePass(password string) {
 	}
 
 	// Authenticate user
-	user, err := c.server.authenticator.Authenticate(c.username, password)
+	user, err := c.server.authenticator.Authenticate(c.username, password, remoteIP(c.conn.RemoteAddr()))
 	if err != nil {
-		c.sendResponse(530, "Login incorrect")
+		c.auditEvent(audit.ActionLoginFailed, "", "", err)
+		switch {
+		case errors.Is(err, auth.ErrBanned):
+			metrics.Logins.Inc("ftp", "banned")
+			c.logger.Info("FTP login failed for %s from %s: %v", c.username, c.conn.RemoteAddr(), err)
+			c.sendResponse(421, "Too many failed logins, closing connection")
+			c.conn.Close()
+		case errors.Is(err, auth.ErrAccessDenied):
+			metrics.Logins.Inc("ftp", "denied")
+			c.logger.Warn("Rejected FTP login for %s: %v", c.username, err)
+			c.sendResponse(530, "Login not permitted from this address")
+		case errors.Is(err, auth.ErrLockedOut):
+			metrics.Logins.Inc("ftp", "locked_out")
+			c.logger.Info("FTP login failed for %s from %s: %v", c.username, c.conn.RemoteAddr(), err)
+			c.sendResponse(530, "Account temporarily locked")
+		case errors.Is(err, auth.ErrUserDisabled):
+			metrics.Logins.Inc("ftp", "denied")
+			c.logger.Info("Rejected FTP login for %s: %v", c.username, err)
+			c.sendResponse(530, "Account disabled")
+		default:
+			metrics.Logins.Inc("ftp", "failure")
+			c.logger.Info("FTP login failed for %s from %s: %v", c.username, c.conn.RemoteAddr(), err)
+			c.sendResponse(530, "Login incorrect")
+		}
 		return
 	}
 
+	// A new login replaces the previous one
+	c.logout()
+
+	if user.Anonymous {
+		limit := c.server.config.Anonymous.MaxSessions
+		if !c.server.sessions.ClaimAnonymous(c.status, limit) {
+			c.logger.Info("Refused anonymous login from %s: %d sessions active", c.conn.RemoteAddr(), limit)
+			metrics.Logins.Inc("ftp", "denied")
+			c.sendResponse(421, "Too many anonymous users, try again later")
+			c.conn.Close()
+			return
+		}
+		// The password of an anonymous login is the client's email address
+		c.logger.Info("Anonymous FTP login from %s as %s, email %q", c.conn.RemoteAddr(), c.username, password)
+	}
+
+	metrics.Logins.Inc("ftp", "success")
 	c.user = user
+	c.logger = c.sessionLogger.With("user", user.Name)
+	c.status.SetUser(user.Name)
+	c.auditEvent(audit.ActionLogin, "", "", nil)
 	// Set initial directory to user's configured path
 	c.currentDir = user.Path
 	if c.currentDir == "" {
@@ -278,6 +571,19 @@ func (c *FTPConnection) handlePass(password string) {
 	c.sendResponse(230, "Login successful")
 }
 
+// logout ends the current login, releasing any anonymous session slot
+func (c *FTPConnection) logout() {
+	if c.user != nil {
+		c.auditEvent(audit.ActionLogout, "", "", nil)
+	}
+	if c.user != nil && c.user.Anonymous {
+		c.server.sessions.ReleaseAnonymous(c.status)
+	}
+	c.user = nil
+	c.logger = c.sessionLogger
+	c.status.SetUser("")
+}
+
 // handleType handles the TYPE command
 func (c *FTPConnection) handleType(args string) {
 	c.sendResponse(200, "Type set to binary")
@@ -294,6 +600,12 @@ func (c *FTPConnection) handleFeat() {
 		" MLST type*;size*;modify*;",
 		" MLSD",
 		" UTF8",
+		c.hashFeature(),
+		" XCRC",
+		" XMD5",
+		" XSHA1",
+		" XSHA256",
+		" SITE CPFR;CPTO;CHMOD;RMDIR",
 		"211 END",
 	}
 	
@@ -305,9 +617,7 @@ func (c *FTPConnection) handleFeat() {
 // handlePasv handles the PASV command (passive mode)
 func (c *FTPConnection) handlePasv() {
 	// Close any existing passive listener
-	if c.pasvListener != nil {
-		c.pasvListener.Close()
-	}
+	c.closePassive()
 
 	// Try to create a listener within the passive port range
 	var listener net.Listener
@@ -323,32 +633,33 @@ func (c *FTPConnection) handlePasv() {
 	}
 	
 	if listener == nil {
-		c.server.logger.Error("Failed to create passive listener in range %d-%d: %v", c.server.pasvMinPort, c.server.pasvMaxPort, err)
+		c.logger.Error("Failed to create passive listener in range %d-%d: %v", c.server.pasvMinPort, c.server.pasvMaxPort, err)
 		c.sendResponse(425, "Cannot open passive connection")
 		return
 	}
 
 	c.pasvListener = listener
+	metrics.PassivePortsInUse.Inc()
 
 	// Convert port to high/low bytes
 	p1 := port / 256
 	p2 := port % 256
 
-	c.server.logger.Debug("PASV: created listener on port %d (p1=%d, p2=%d)", port, p1, p2)
+	c.logger.Debug("PASV: created listener on port %d (p1=%d, p2=%d)", port, p1, p2)
 
 	// Get the local IP address from the control connection
 	localAddr := c.conn.LocalAddr().(*net.TCPAddr).IP
 	ip := localAddr.To4()
 	
-	c.server.logger.Debug("PASV: local address detected as %s", localAddr.String())
+	c.logger.Debug("PASV: local address detected as %s", localAddr.String())
 	
 	// For local testing and many firewall scenarios, use 127.0.0.1
 	// In production, you'd want to configure the external IP
 	if ip == nil || localAddr.IsUnspecified() || localAddr.String() == "0.0.0.0" {
 		ip = net.IPv4(127, 0, 0, 1)
-		c.server.logger.Debug("PASV: using loopback IP for passive mode")
+		c.logger.Debug("PASV: using loopback IP for passive mode")
 	} else {
-		c.server.logger.Debug("PASV: using detected IP %s for passive mode", ip.String())
+		c.logger.Debug("PASV: using detected IP %s for passive mode", ip.String())
 	}
 
 	c.sendResponse(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", 
@@ -388,17 +699,64 @@ func (c *FTPConnection) handleList(args string) {
 		tcpListener.SetDeadline(time.Time{})
 	}
 
-	c.server.logger.Debug("Data connection established for LIST %s from %s", c.currentDir, dataConn.RemoteAddr())
+	// List the current directory unless a path follows the options
+	dir := c.currentDir
+	name, recursive := parseListArgs(args)
+	if name != "" {
+		dir = c.resolvePath(name)
+	}
+
+	c.logger.Debug("Data connection established for LIST %s from %s", dir, dataConn.RemoteAddr())
 
 	// Get actual directory listing from file system
-	files, err := c.server.fileSystem.ListDirectory(c.user, c.currentDir)
+	listing, err := c.listing(dir, recursive)
 	if err != nil {
-		c.server.logger.Error("Failed to list directory: %v", err)
-		c.sendResponse(550, "Failed to list directory")
+		c.logger.Error("Failed to list directory: %v", err)
+		if errors.Is(err, fs.ErrTooManyEntries) {
+			c.sendResponse(550, "Too many entries for a recursive listing")
+		} else {
+			c.sendResponse(550, "Failed to list directory")
+		}
 		return
 	}
 
-	// Format listing in standard FTP format with proper ownership
+	// Send listing
+	dataConn.Write([]byte(listing))
+
+	c.sendResponse(226, "Directory listing completed")
+
+	// Close passive listener
+	c.closePassive()
+}
+
+// listing formats a directory like ls -l, or like ls -lR with a header
+// before every directory when recursive
+func (c *FTPConnection) listing(dir string, recursive bool) (string, error) {
+	if !recursive {
+		files, err := c.server.fileSystem.ListDirectory(c.user, dir)
+		if err != nil {
+			return "", err
+		}
+		return c.formatListing(files), nil
+	}
+
+	listings, err := c.server.fileSystem.ListDirectoryRecursive(c.user, dir)
+	if err != nil {
+		return "", err
+	}
+	var listing strings.Builder
+	for i, l := range listings {
+		if i > 0 {
+			listing.WriteString("\r\n")
+		}
+		listing.WriteString(l.Path + ":\r\n")
+		listing.WriteString(c.formatListing(l.Files))
+	}
+	return listing.String(), nil
+}
+
+// formatListing formats files in standard FTP format with proper ownership
+func (c *FTPConnection) formatListing(files []fs.FileInfo) string {
 	var listing strings.Builder
 	for _, file := range files {
 		// Determine permissions based on user access and file type
@@ -438,22 +796,13 @@ func (c *FTPConnection) handleList(args string) {
 		}
 	}
 
-	// Send listing
-	dataConn.Write([]byte(listing.String()))
-
-	c.sendResponse(226, "Directory listing completed")
-
-	// Close passive listener
-	c.pasvListener.Close()
-	c.pasvListener = nil
+	return listing.String()
 }
 
 // handleEpsv handles the EPSV command (extended passive mode)
 func (c *FTPConnection) handleEpsv() {
 	// Close any existing passive listener
-	if c.pasvListener != nil {
-		c.pasvListener.Close()
-	}
+	c.closePassive()
 
 	// Try to create a listener within the passive port range
 	var listener net.Listener
@@ -469,14 +818,15 @@ func (c *FTPConnection) handleEpsv() {
 	}
 	
 	if listener == nil {
-		c.server.logger.Error("Failed to create passive listener in range %d-%d: %v", c.server.pasvMinPort, c.server.pasvMaxPort, err)
+		c.logger.Error("Failed to create passive listener in range %d-%d: %v", c.server.pasvMinPort, c.server.pasvMaxPort, err)
 		c.sendResponse(425, "Cannot open passive connection")
 		return
 	}
 
 	c.pasvListener = listener
+	metrics.PassivePortsInUse.Inc()
 
-	c.server.logger.Debug("EPSV: created listener on port %d", port)
+	c.logger.Debug("EPSV: created listener on port %d", port)
 
 	// Extended passive mode response format: (|||port|)
 	c.sendResponse(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
@@ -519,7 +869,8 @@ func (c *FTPConnection) handleCwd(path string) {
 
 	// Check if user has permission to access this directory
 	if err := auth.CheckPermission(c.user, c.server.config.Data, newPath, auth.PermissionList); err != nil {
-		c.server.logger.Debug("CWD permission denied for user %s to path %s: %v", c.username, newPath, err)
+		c.logger.Debug("CWD permission denied for user %s to path %s: %v", c.username, newPath, err)
+		c.auditEvent(audit.ActionDenied, newPath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
@@ -527,14 +878,14 @@ func (c *FTPConnection) handleCwd(path string) {
 	// Try to list the directory to ensure it exists and is accessible
 	_, err := c.server.fileSystem.ListDirectory(c.user, newPath)
 	if err != nil {
-		c.server.logger.Debug("CWD failed for user %s to path %s: %v", c.username, newPath, err)
+		c.logger.Debug("CWD failed for user %s to path %s: %v", c.username, newPath, err)
 		c.sendResponse(550, "Directory not found or access denied")
 		return
 	}
 
 	// Update current directory
 	c.currentDir = newPath
-	c.server.logger.Debug("CWD successful: user %s changed to directory %s", c.username, newPath)
+	c.logger.Debug("CWD successful: user %s changed to directory %s", c.username, newPath)
 	c.sendResponse(250, fmt.Sprintf("Directory changed to %s", newPath))
 }
 
@@ -565,7 +916,8 @@ func (c *FTPConnection) handleRetr(filename string) {
 
 	// Check read permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, filePath, auth.PermissionRead); err != nil {
-		c.server.logger.Debug("RETR permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.logger.Debug("RETR permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.auditEvent(audit.ActionDenied, filePath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
@@ -573,8 +925,12 @@ func (c *FTPConnection) handleRetr(filename string) {
 	// Read the file and get a reader
 	reader, err := c.server.fileSystem.ReadFile(c.user, filePath)
 	if err != nil {
-		c.server.logger.Error("Failed to read file %s: %v", filePath, err)
-		c.sendResponse(550, "File not found")
+		c.logger.Error("Failed to read file %s: %v", filePath, err)
+		if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "File is in use")
+		} else {
+			c.sendResponse(550, "File not found")
+		}
 		return
 	}
 	defer reader.Close()
@@ -590,7 +946,7 @@ func (c *FTPConnection) handleRetr(filename string) {
 	// Accept data connection
 	dataConn, err := c.pasvListener.Accept()
 	if err != nil {
-		c.server.logger.Error("Failed to accept data connection for RETR: %v", err)
+		c.logger.Error("Failed to accept data connection for RETR: %v", err)
 		c.sendResponse(425, "Cannot open data connection")
 		return
 	}
@@ -602,22 +958,25 @@ func (c *FTPConnection) handleRetr(filename string) {
 	}
 	dataConn.SetDeadline(time.Now().Add(10 * time.Minute))
 
-	c.server.logger.Debug("Data connection established for RETR %s from %s", filePath, dataConn.RemoteAddr())
+	c.logger.Debug("Data connection established for RETR %s from %s", filePath, dataConn.RemoteAddr())
 
 	// Copy file content to data connection
-	bytesRead, err := io.Copy(dataConn, reader)
+	start := time.Now()
+	transfer := c.status.BeginTransfer(filePath, metrics.Out, dataConn)
+	bytesRead, err := io.Copy(dataConn, transfer.Reader(utils.NewRateLimitedReader(reader, c.user.DownloadRate)))
+	c.status.EndTransfer()
+	c.recordTransfer(metrics.Out, filePath, bytesRead, start, err == nil)
 	if err != nil {
-		c.server.logger.Error("Failed to send file: %v", err)
+		c.logger.Error("Failed to send file: %v", err)
 		c.sendResponse(426, "Transfer aborted")
 		return
 	}
 
-	c.server.logger.Debug("RETR completed: sent %d bytes from %s", bytesRead, filePath)
+	c.logger.Debug("RETR completed: sent %d bytes from %s", bytesRead, filePath)
 	c.sendResponse(226, "Transfer completed")
 
 	// Close passive listener
-	c.pasvListener.Close()
-	c.pasvListener = nil
+	c.closePassive()
 }
 
 // handleStor handles the STOR command
@@ -632,12 +991,6 @@ func (c *FTPConnection) handleStor(filename string) {
 		return
 	}
 
-	// Check if user has write permissions
-	if c.user.IsReadOnly() {
-		c.sendResponse(550, "Permission denied: read-only user")
-		return
-	}
-
 	// Get the file path
 	filePath := filename
 	if !strings.HasPrefix(filePath, "/") {
@@ -651,19 +1004,20 @@ func (c *FTPConnection) handleStor(filename string) {
 	// Normalize the path to handle .. and . properly
 	filePath = c.normalizePath(filePath)
 
-	c.server.logger.Debug("STOR: normalized path %s for user %s", filePath, c.username)
+	c.logger.Debug("STOR: normalized path %s for user %s", filePath, c.username)
 
 	// Check write permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, filePath, auth.PermissionWrite); err != nil {
-		c.server.logger.Debug("STOR permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.logger.Debug("STOR permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.auditEvent(audit.ActionDenied, filePath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
 
-	c.server.logger.Debug("STOR: permissions OK, sending 150 response")
+	c.logger.Debug("STOR: permissions OK, sending 150 response")
 	c.sendResponse(150, "Opening data connection for file upload")
 
-	c.server.logger.Debug("STOR: waiting for data connection on port range %d-%d...", c.server.pasvMinPort, c.server.pasvMaxPort)
+	c.logger.Debug("STOR: waiting for data connection on port range %d-%d...", c.server.pasvMinPort, c.server.pasvMaxPort)
 	
 	// Set a reasonable timeout for the data connection (increased for GUI clients)
 	deadline := time.Now().Add(60 * time.Second)
@@ -674,12 +1028,9 @@ func (c *FTPConnection) handleStor(filename string) {
 	// Accept data connection
 	dataConn, err := c.pasvListener.Accept()
 	if err != nil {
-		c.server.logger.Error("Failed to accept data connection for STOR: %v", err)
+		c.logger.Error("Failed to accept data connection for STOR: %v", err)
 		c.sendResponse(425, "Cannot open data connection")
-		if c.pasvListener != nil {
-			c.pasvListener.Close()
-			c.pasvListener = nil
-		}
+		c.closePassive()
 		return
 	}
 	defer dataConn.Close()
@@ -692,33 +1043,51 @@ func (c *FTPConnection) handleStor(filename string) {
 	// Set timeout for data transfer
 	dataConn.SetDeadline(time.Now().Add(10 * time.Minute))
 
-	c.server.logger.Debug("Data connection established for STOR %s from %s", filePath, dataConn.RemoteAddr())
+	c.logger.Debug("Data connection established for STOR %s from %s", filePath, dataConn.RemoteAddr())
 
 	// Create the file writer
-	writer, err := c.server.fileSystem.WriteFile(c.user, filePath)
+	atomic := c.server.config.Services.FTP.UseAtomicUploads(c.user)
+	writer, err := c.server.fileSystem.WriteFile(c.user, filePath, fs.WriteOptions{Atomic: atomic})
 	if err != nil {
-		c.server.logger.Error("Failed to create file %s: %v", filePath, err)
-		c.sendResponse(550, "Failed to store file")
+		c.logger.Error("Failed to create file %s: %v", filePath, err)
+		if errors.Is(err, fs.ErrQuotaExceeded) {
+			c.sendResponse(552, "Quota exceeded")
+		} else if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "File is in use")
+		} else {
+			c.sendResponse(550, "Failed to store file")
+		}
 		return
 	}
-	defer writer.Close()
 
-	c.server.logger.Debug("STOR: file writer created, starting data transfer...")
+	c.logger.Debug("STOR: file writer created, starting data transfer...")
 
 	// Copy data from connection to file
-	bytesWritten, err := io.Copy(writer, dataConn)
+	start := time.Now()
+	transfer := c.status.BeginTransfer(filePath, metrics.In, dataConn)
+	bytesWritten, err := io.Copy(writer, transfer.Reader(utils.NewRateLimitedReader(dataConn, c.user.UploadRate)))
+	if err != nil {
+		writer.Abort()
+	} else {
+		err = writer.Close()
+	}
+	c.status.EndTransfer()
+	c.recordTransfer(metrics.In, filePath, bytesWritten, start, err == nil)
 	if err != nil {
-		c.server.logger.Error("Failed to write file data %s: %v", filePath, err)
-		c.sendResponse(550, "Failed to store file")
+		c.logger.Error("Failed to write file data %s: %v", filePath, err)
+		if errors.Is(err, fs.ErrQuotaExceeded) {
+			c.sendResponse(552, "Quota exceeded")
+		} else {
+			c.sendResponse(550, "Failed to store file")
+		}
 		return
 	}
 
-	c.server.logger.Debug("STOR completed: wrote %d bytes to %s", bytesWritten, filePath)
+	c.logger.Debug("STOR completed: wrote %d bytes to %s", bytesWritten, filePath)
 	c.sendResponse(226, "Transfer completed")
 
 	// Close passive listener
-	c.pasvListener.Close()
-	c.pasvListener = nil
+	c.closePassive()
 }
 
 // handleDele handles the DELE command (delete file)
@@ -728,12 +1097,6 @@ func (c *FTPConnection) handleDele(filename string) {
 		return
 	}
 
-	// Check if user has write permissions
-	if c.user.IsReadOnly() {
-		c.sendResponse(550, "Permission denied: read-only user")
-		return
-	}
-
 	// Get the file path
 	filePath := filename
 	if !strings.HasPrefix(filePath, "/") {
@@ -749,20 +1112,26 @@ func (c *FTPConnection) handleDele(filename string) {
 
 	// Check delete permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, filePath, auth.PermissionDelete); err != nil {
-		c.server.logger.Debug("DELE permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.logger.Debug("DELE permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.auditEvent(audit.ActionDenied, filePath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
 
 	// Delete the file using the file system
 	err := c.server.fileSystem.DeleteFile(c.user, filePath)
+	c.auditEvent(audit.ActionDelete, filePath, "", err)
 	if err != nil {
-		c.server.logger.Error("Failed to delete file %s: %v", filePath, err)
-		c.sendResponse(550, "Failed to delete file")
+		c.logger.Error("Failed to delete file %s: %v", filePath, err)
+		if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "File is in use")
+		} else {
+			c.sendResponse(550, "Failed to delete file")
+		}
 		return
 	}
 
-	c.server.logger.Debug("DELE completed: deleted %s", filePath)
+	c.logger.Debug("DELE completed: deleted %s", filePath)
 	c.sendResponse(250, "File deleted")
 }
 
@@ -773,11 +1142,6 @@ func (c *FTPConnection) handleMkd(dirname string) {
 		return
 	}
 
-	if c.user.IsReadOnly() {
-		c.sendResponse(550, "Permission denied: read-only user")
-		return
-	}
-
 	// Get the directory path
 	dirPath := dirname
 	if !strings.HasPrefix(dirPath, "/") {
@@ -792,20 +1156,22 @@ func (c *FTPConnection) handleMkd(dirname string) {
 
 	// Check write permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, dirPath, auth.PermissionWrite); err != nil {
-		c.server.logger.Debug("MKD permission denied for user %s to dir %s: %v", c.username, dirPath, err)
+		c.logger.Debug("MKD permission denied for user %s to dir %s: %v", c.username, dirPath, err)
+		c.auditEvent(audit.ActionDenied, dirPath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
 
 	// Create directory using file system
 	err := c.server.fileSystem.CreateDirectory(c.user, dirPath)
+	c.auditEvent(audit.ActionMkdir, dirPath, "", err)
 	if err != nil {
-		c.server.logger.Error("Failed to create directory %s: %v", dirPath, err)
+		c.logger.Error("Failed to create directory %s: %v", dirPath, err)
 		c.sendResponse(550, "Failed to create directory")
 		return
 	}
 
-	c.server.logger.Debug("MKD completed: created %s", dirPath)
+	c.logger.Debug("MKD completed: created %s", dirPath)
 	c.sendResponse(257, fmt.Sprintf("\"%s\" directory created", dirPath))
 }
 
@@ -816,11 +1182,6 @@ func (c *FTPConnection) handleRmd(dirname string) {
 		return
 	}
 
-	if c.user.IsReadOnly() {
-		c.sendResponse(550, "Permission denied: read-only user")
-		return
-	}
-
 	// Get the directory path
 	dirPath := dirname
 	if !strings.HasPrefix(dirPath, "/") {
@@ -835,20 +1196,22 @@ func (c *FTPConnection) handleRmd(dirname string) {
 
 	// Check delete permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, dirPath, auth.PermissionDelete); err != nil {
-		c.server.logger.Debug("RMD permission denied for user %s to dir %s: %v", c.username, dirPath, err)
+		c.logger.Debug("RMD permission denied for user %s to dir %s: %v", c.username, dirPath, err)
+		c.auditEvent(audit.ActionDenied, dirPath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
 
 	// Remove directory using file system
 	err := c.server.fileSystem.RemoveDirectory(c.user, dirPath)
+	c.auditEvent(audit.ActionRmdir, dirPath, "", err)
 	if err != nil {
-		c.server.logger.Error("Failed to remove directory %s: %v", dirPath, err)
+		c.logger.Error("Failed to remove directory %s: %v", dirPath, err)
 		c.sendResponse(550, "Failed to remove directory")
 		return
 	}
 
-	c.server.logger.Debug("RMD completed: removed %s", dirPath)
+	c.logger.Debug("RMD completed: removed %s", dirPath)
 	c.sendResponse(250, "Directory removed")
 }
 
@@ -873,7 +1236,8 @@ func (c *FTPConnection) handleSize(filename string) {
 
 	// Check read permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, filePath, auth.PermissionRead); err != nil {
-		c.server.logger.Debug("SIZE permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.logger.Debug("SIZE permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.auditEvent(audit.ActionDenied, filePath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
@@ -881,7 +1245,7 @@ func (c *FTPConnection) handleSize(filename string) {
 	// Get file size using file system
 	size, err := c.server.fileSystem.GetFileSize(c.user, filePath)
 	if err != nil {
-		c.server.logger.Error("Failed to get size of file %s: %v", filePath, err)
+		c.logger.Error("Failed to get size of file %s: %v", filePath, err)
 		c.sendResponse(550, "File not found")
 		return
 	}
@@ -922,12 +1286,12 @@ func (c *FTPConnection) handleMlsd(args string) {
 		tcpListener.SetDeadline(time.Time{})
 	}
 
-	c.server.logger.Debug("Data connection established for MLSD %s from %s", c.currentDir, dataConn.RemoteAddr())
+	c.logger.Debug("Data connection established for MLSD %s from %s", c.currentDir, dataConn.RemoteAddr())
 
 	// Get directory listing
 	files, err := c.server.fileSystem.ListDirectory(c.user, c.currentDir)
 	if err != nil {
-		c.server.logger.Error("Failed to list directory for MLSD: %v", err)
+		c.logger.Error("Failed to list directory for MLSD: %v", err)
 		c.sendResponse(550, "Failed to list directory")
 		return
 	}
@@ -957,8 +1321,7 @@ func (c *FTPConnection) handleMlsd(args string) {
 	c.sendResponse(226, "MLSD completed")
 
 	// Close passive listener
-	c.pasvListener.Close()
-	c.pasvListener = nil
+	c.closePassive()
 }
 
 // handleOpts handles the OPTS command (set options)
@@ -976,11 +1339,263 @@ func (c *FTPConnection) handleOpts(args string) {
 	case "UTF8":
 		// Accept UTF8 option but don't actually change anything
 		c.sendResponse(200, "UTF8 set to on")
+	case "HASH":
+		if len(parts) < 2 || strings.TrimSpace(parts[1]) == "" {
+			c.sendResponse(200, c.currentHash())
+			return
+		}
+		algorithm, err := fs.ParseHashAlgorithm(strings.TrimSpace(parts[1]))
+		if err != nil {
+			c.sendResponse(501, "Unknown hash algorithm")
+			return
+		}
+		c.hashAlgorithm = algorithm
+		c.sendResponse(200, algorithm)
 	default:
 		c.sendResponse(502, "OPTS not implemented for " + option)
 	}
 }
 
+// handleSite handles the SITE command and its subcommands
+func (c *FTPConnection) handleSite(args string) {
+	if c.user == nil {
+		c.sendResponse(530, "Not logged in")
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	subcommand := strings.ToUpper(parts[0])
+	var subargs string
+	if len(parts) > 1 {
+		subargs = strings.TrimSpace(parts[1])
+	}
+
+	switch subcommand {
+	case "CHMOD":
+		c.handleSiteChmod(subargs)
+	case "RMDIR":
+		c.handleSiteRmdir(subargs)
+	case "CPFR":
+		c.handleSiteCpfr(subargs)
+	case "CPTO":
+		c.handleSiteCpto(subargs)
+	case "HELP":
+		c.sendResponse(214, "SITE commands: CHMOD CPFR CPTO RMDIR HELP")
+	default:
+		c.sendResponse(500, "Unknown SITE command")
+	}
+}
+
+// handleSiteChmod handles SITE CHMOD <mode> <path>
+func (c *FTPConnection) handleSiteChmod(args string) {
+	parts := strings.SplitN(args, " ", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		c.sendResponse(501, "Syntax: SITE CHMOD <mode> <path>")
+		return
+	}
+
+	mode, err := config.ParseMode(parts[0])
+	if err != nil {
+		c.sendResponse(501, "Invalid mode")
+		return
+	}
+
+	filePath := c.resolvePath(parts[1])
+	err = c.server.fileSystem.Chmod(c.user, filePath, mode)
+	c.auditEvent(audit.ActionChmod, filePath, mode.String(), err)
+	if err != nil {
+		c.logger.Debug("SITE CHMOD failed for user %s on %s: %v", c.username, filePath, err)
+		c.sendResponse(550, "Cannot change mode")
+		return
+	}
+
+	c.logger.Debug("SITE CHMOD completed: %s set to %s", filePath, mode)
+	c.sendResponse(200, "SITE CHMOD command successful")
+}
+
+// handleSiteRmdir handles SITE RMDIR [-R] <path>, which removes a directory
+// with everything in it
+func (c *FTPConnection) handleSiteRmdir(args string) {
+	name, _ := parseListArgs(args)
+	if name == "" {
+		c.sendResponse(501, "Syntax: SITE RMDIR -R <path>")
+		return
+	}
+
+	dirPath := c.resolvePath(name)
+	removed, err := c.server.fileSystem.RemoveDirectoryAll(c.user, dirPath)
+	c.auditEvent(audit.ActionRmdir, dirPath, "", err)
+	if err != nil {
+		c.logger.Debug("SITE RMDIR failed for user %s on %s after %d entries: %v", c.username, dirPath, removed, err)
+		if errors.Is(err, fs.ErrTooManyEntries) {
+			c.sendResponse(550, "Directory has too many entries to remove at once")
+		} else if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "A file in the directory is in use")
+		} else {
+			c.sendResponse(550, "Cannot remove directory")
+		}
+		return
+	}
+
+	c.logger.Debug("SITE RMDIR completed: %s removed with %d entries", dirPath, removed)
+	c.sendResponse(250, fmt.Sprintf("Directory removed, %d entries deleted", removed))
+}
+
+// handleSiteCpfr handles SITE CPFR <path>, which selects the file to copy
+func (c *FTPConnection) handleSiteCpfr(args string) {
+	if args == "" {
+		c.sendResponse(501, "Syntax: SITE CPFR <path>")
+		return
+	}
+
+	filePath := c.resolvePath(args)
+	info, err := c.server.fileSystem.GetFileInfo(c.user, filePath)
+	if err != nil || info.IsDir {
+		c.logger.Debug("SITE CPFR failed for user %s on %s: %v", c.username, filePath, err)
+		c.sendResponse(550, "File not found")
+		return
+	}
+
+	c.copyFrom = filePath
+	c.sendResponse(350, "File exists, ready for destination name")
+}
+
+// handleSiteCpto handles SITE CPTO <path>, which copies the file selected
+// with SITE CPFR on the server
+func (c *FTPConnection) handleSiteCpto(args string) {
+	if c.copyFrom == "" {
+		c.sendResponse(503, "Use SITE CPFR first")
+		return
+	}
+	if args == "" {
+		c.sendResponse(501, "Syntax: SITE CPTO <path>")
+		return
+	}
+
+	from, to := c.copyFrom, c.resolvePath(args)
+	c.copyFrom = ""
+
+	err := c.server.fileSystem.CopyFile(c.user, from, to)
+	c.auditEvent(audit.ActionCopy, from, to, err)
+	if err != nil {
+		c.logger.Debug("SITE CPTO failed for user %s from %s to %s: %v", c.username, from, to, err)
+		if errors.Is(err, fs.ErrQuotaExceeded) {
+			c.sendResponse(552, "Quota exceeded")
+		} else if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "File is in use")
+		} else {
+			c.sendResponse(550, "Cannot copy file")
+		}
+		return
+	}
+
+	c.logger.Debug("SITE CPTO completed: %s copied to %s", from, to)
+	c.sendResponse(250, "Copy successful")
+}
+
+// currentHash returns the algorithm HASH uses in this session
+func (c *FTPConnection) currentHash() string {
+	if c.hashAlgorithm == "" {
+		return fs.HashSHA256
+	}
+	return c.hashAlgorithm
+}
+
+// hashFeature lists the hash algorithms for FEAT, marking the selected one
+func (c *FTPConnection) hashFeature() string {
+	names := make([]string, len(fs.HashAlgorithms))
+	for i, algorithm := range fs.HashAlgorithms {
+		if algorithm == c.currentHash() {
+			algorithm += "*"
+		}
+		names[i] = algorithm
+	}
+	return " HASH " + strings.Join(names, ";")
+}
+
+// handleHash handles HASH <path> from the FTP HASH draft, and the older
+// XCRC, XMD5, XSHA1 and XSHA256 commands when legacy is set
+func (c *FTPConnection) handleHash(args, algorithm string, legacy bool) {
+	if c.user == nil {
+		c.sendResponse(530, "Not logged in")
+		return
+	}
+
+	// The legacy commands may quote the name and add a byte range, which
+	// isn't supported
+	name := strings.TrimSpace(args)
+	if legacy && strings.HasPrefix(name, "\"") {
+		if end := strings.Index(name[1:], "\""); end >= 0 {
+			name = name[1 : end+1]
+		}
+	}
+	if name == "" {
+		c.sendResponse(501, "No filename given")
+		return
+	}
+
+	filePath := c.resolvePath(name)
+	sum, size, err := c.server.fileSystem.HashFile(c.user, filePath, algorithm)
+	if err != nil {
+		c.logger.Debug("%s hash failed for user %s on %s: %v", algorithm, c.username, filePath, err)
+		if errors.Is(err, fs.ErrLocked) {
+			c.sendResponse(450, "File is in use")
+		} else {
+			c.sendResponse(550, "Cannot compute hash")
+		}
+		return
+	}
+
+	if legacy {
+		c.sendResponse(250, sum)
+	} else {
+		c.sendResponse(213, fmt.Sprintf("%s 0-%d %s %s", algorithm, size, sum, name))
+	}
+}
+
+// handleStat handles the STAT command. Without arguments it reports the
+// session status, with a path it sends the listing over the control
+// connection, recursively with -R.
+func (c *FTPConnection) handleStat(args string) {
+	if c.user == nil {
+		c.sendResponse(530, "Not logged in")
+		return
+	}
+
+	if strings.TrimSpace(args) == "" {
+		c.sendResponse(0, "211-FTP server status:")
+		c.sendResponse(0, " Connected from "+remoteIP(c.conn.RemoteAddr()).String())
+		c.sendResponse(0, " Logged in as "+c.username)
+		c.sendResponse(0, " Current directory "+c.currentDir)
+		c.sendResponse(211, "End of status")
+		return
+	}
+
+	dir := c.currentDir
+	name, recursive := parseListArgs(args)
+	if name != "" {
+		dir = c.resolvePath(name)
+	}
+
+	listing, err := c.listing(dir, recursive)
+	if err != nil {
+		c.logger.Debug("STAT failed for user %s on %s: %v", c.username, dir, err)
+		if errors.Is(err, fs.ErrTooManyEntries) {
+			c.sendResponse(550, "Too many entries for a recursive listing")
+		} else {
+			c.sendResponse(550, "Failed to list directory")
+		}
+		return
+	}
+
+	// Lines are indented so none can be mistaken for the final reply
+	c.sendResponse(0, "213-Status of "+dir+":")
+	for _, line := range strings.Split(strings.TrimSuffix(listing, "\r\n"), "\r\n") {
+		c.sendResponse(0, " "+line)
+	}
+	c.sendResponse(213, "End of status")
+}
+
 // handleMdtm handles the MDTM command (file modification time)
 func (c *FTPConnection) handleMdtm(filename string) {
 	if c.user == nil {
@@ -1008,7 +1623,8 @@ func (c *FTPConnection) handleMdtm(filename string) {
 
 	// Check read permission
 	if err := auth.CheckPermission(c.user, c.server.config.Data, filePath, auth.PermissionRead); err != nil {
-		c.server.logger.Debug("MDTM permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.logger.Debug("MDTM permission denied for user %s to file %s: %v", c.username, filePath, err)
+		c.auditEvent(audit.ActionDenied, filePath, "", err)
 		c.sendResponse(550, "Permission denied")
 		return
 	}
@@ -1016,7 +1632,7 @@ func (c *FTPConnection) handleMdtm(filename string) {
 	// Get file information
 	fileInfo, err := c.server.fileSystem.GetFileInfo(c.user, filePath)
 	if err != nil {
-		c.server.logger.Debug("MDTM failed to get file info for %s: %v", filePath, err)
+		c.logger.Debug("MDTM failed to get file info for %s: %v", filePath, err)
 		c.sendResponse(550, "File not found")
 		return
 	}
@@ -0,0 +1,117 @@
+//go:build linux
+
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock system calls, numbered alike on all architectures
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	prSetNoNewPrivs = 38
+)
+
+// Landlock file system access rights
+const (
+	landlockExecute    = 1 << 0
+	landlockWriteFile  = 1 << 1
+	landlockReadFile   = 1 << 2
+	landlockReadDir    = 1 << 3
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13 // ABI 2
+	landlockTruncate   = 1 << 14 // ABI 3
+)
+
+// confine restricts every thread of the process to writing below writable
+// and reading below readable. Landlock ABI 2 is needed, as the first one
+// denies renames between directories, which the trash and versions use.
+func confine(writable, readable []string) error {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return fmt.Errorf("landlock is not available: %w", errno)
+	}
+	if abi < 2 {
+		return fmt.Errorf("landlock ABI %d cannot rename files between directories, Linux 5.19 or later is needed", abi)
+	}
+
+	handled := uint64(landlockExecute | landlockWriteFile | landlockReadFile | landlockReadDir |
+		landlockRemoveDir | landlockRemoveFile | landlockMakeChar | landlockMakeDir | landlockMakeReg |
+		landlockMakeSock | landlockMakeFifo | landlockMakeBlock | landlockMakeSym | landlockRefer)
+	if abi >= 3 {
+		handled |= landlockTruncate
+	}
+
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&handled)), unsafe.Sizeof(handled), 0)
+	if errno != 0 {
+		return fmt.Errorf("failed to create ruleset: %w", errno)
+	}
+	defer syscall.Close(int(fd))
+
+	// Nothing is executed once confined
+	write := handled &^ landlockExecute
+	for _, path := range writable {
+		if err := landlockAllow(fd, path, write); err != nil {
+			return err
+		}
+	}
+	for _, path := range readable {
+		if err := landlockAllow(fd, path, landlockReadFile|landlockReadDir); err != nil {
+			return err
+		}
+	}
+
+	// Both apply to the calling thread only, so they are made on all
+	// threads, which the Go runtime cannot do in a cgo build
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return fmt.Errorf("the server must be built with CGO_ENABLED=0")
+		}
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(sysLandlockRestrictSelf, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to restrict the process: %w", errno)
+	}
+	return nil
+}
+
+// landlockAllow grants access below a directory. Directories that do not
+// exist are skipped.
+func landlockAllow(ruleset uintptr, path string, access uint64) error {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer syscall.Close(fd)
+
+	// struct landlock_path_beneath_attr is packed: allowed access, then fd
+	var attr [12]byte
+	binary.NativeEndian.PutUint64(attr[0:8], access)
+	binary.NativeEndian.PutUint32(attr[8:12], uint32(fd))
+
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, ruleset, landlockRulePathBeneath, uintptr(unsafe.Pointer(&attr[0])), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to allow access to %s: %w", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package server
+
+import "fmt"
+
+// confine is not available without Landlock
+func confine(writable, readable []string) error {
+	return fmt.Errorf("landlock is only available on Linux")
+}
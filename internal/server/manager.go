@@ -2,9 +2,13 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
 
+	"github.com/Merith-TK/ftp-aio/internal/audit"
 	"github.com/Merith-TK/ftp-aio/internal/auth"
 	"github.com/Merith-TK/ftp-aio/internal/config"
 	"github.com/Merith-TK/ftp-aio/internal/fs"
@@ -15,97 +19,346 @@ import (
 type Manager struct {
 	config        *config.Config
 	logger        *utils.Logger
+	rootLogger    *utils.Logger // handed to the servers, which pick their own subsystem
 	authenticator *auth.Authenticator
 	fileSystem    *fs.FileSystem
-	servers       []Server
+	audit         *audit.Recorder
+	sessions      *SessionRegistry
+	sockets       *Sockets        // passed by systemd socket activation
+	ctx           context.Context // of Start, for services started later
 	wg            sync.WaitGroup
+
+	mutex    sync.Mutex
+	services []*service // in the order they were first started
+	closing  bool       // shutting down, no more services are started
+
+	reloadMutex sync.Mutex // one Reload at a time
 }
 
-// Server interface that all protocol servers must implement
+// Services that can be started and stopped while running
+var controlledServices = []string{"ftp", "tftp", "metrics"}
+
+// ErrUnknownService is returned for services that cannot be controlled
+var ErrUnknownService = errors.New("unknown service")
+
+// Server interface that all protocol servers must implement. The manager
+// binds the listeners of all servers before any of them serves.
 type Server interface {
-	Start(ctx context.Context) error
+	// Listen binds the server's listener, after which it is ready
+	Listen() error
+	// Serve handles clients until the server is stopped or ctx is done,
+	// returning an error if it cannot go on
+	Serve(ctx context.Context) error
+	// Stop closes the listener. Sessions already connected carry on.
 	Stop() error
+	// Shutdown stops the server and ends its sessions once their transfers
+	// finish, closing those still busy when ctx is done
+	Shutdown(ctx context.Context) error
 	Name() string
 	Port() int
 }
 
 // NewManager creates a new server manager
-func NewManager(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem) *Manager {
+func NewManager(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem, recorder *audit.Recorder, sockets *Sockets) *Manager {
 	return &Manager{
 		config:        cfg,
-		logger:        logger,
+		logger:        logger.Subsystem("server"),
+		rootLogger:    logger,
 		authenticator: authenticator,
 		fileSystem:    fileSystem,
-		servers:       make([]Server, 0),
+		audit:         recorder,
+		sessions:      NewSessionRegistry(),
+		sockets:       sockets,
 	}
 }
 
-// Start starts all enabled servers
+// Start binds the listeners of all enabled servers and serves them. A
+// service that cannot bind fails the start, or is left failed or retried
+// in the background, as the supervisor settings say.
 func (m *Manager) Start(ctx context.Context) error {
 	m.logger.Info("Starting server manager...")
+	m.ctx = ctx
 
-	// Create servers based on configuration
-	if err := m.createServers(); err != nil {
+	names, err := enabledServices(m.config)
+	if err != nil {
 		return fmt.Errorf("failed to create servers: %w", err)
 	}
 
-	// Start all servers
-	for _, server := range m.servers {
-		m.wg.Add(1)
-		go func(s Server) {
-			defer m.wg.Done()
-
-			m.logger.Info("Starting %s server on port %d", s.Name(), s.Port())
+	// Sockets passed by systemd are matched to services by name
+	if activated := m.sockets.Names(); len(activated) > 0 {
+		m.logger.Info("Using sockets passed by systemd for %s", strings.Join(activated, ", "))
+	}
+	if m.sockets != nil {
+		for _, name := range m.sockets.ignored {
+			m.logger.Warn("Ignoring socket '%s' passed by systemd, set FileDescriptorName= to a service: ftp, tftp, metrics or admin", name)
+		}
+	}
 
-			if err := s.Start(ctx); err != nil {
-				m.logger.Error("Failed to start %s server: %v", s.Name(), err)
+	// Bind every listener before serving any, so a port conflict is known
+	// before clients are accepted
+	type boundService struct {
+		service *service
+		server  Server
+	}
+	var bound []boundService
+	abort := func(err error) error {
+		for _, b := range bound {
+			b.server.Stop()
+			m.setState(b.service, StateStopped, nil)
+		}
+		return err
+	}
+	for _, name := range names {
+		svc, server, err := m.bind(name)
+		if err != nil {
+			if m.config.Supervisor.StartFailure == config.StartFail {
+				return abort(err)
 			}
-		}(server)
+			continue
+		}
+		bound = append(bound, boundService{service: svc, server: server})
 	}
 
-	m.logger.Info("All servers started successfully")
+	// Give up root before any client is accepted
+	if err := m.dropPrivileges(); err != nil {
+		return abort(err)
+	}
+
+	for _, b := range bound {
+		m.supervise(b.service, b.server)
+	}
+
+	if len(bound) == len(names) {
+		m.logger.Info("All servers started successfully")
+	} else {
+		m.logger.Warn("Started %d of %d servers", len(bound), len(names))
+	}
+
+	// Tell systemd the server is ready, once the listeners are bound
+	m.notify("READY=1\n" + m.status())
 	return nil
 }
 
-// Stop stops all servers
-func (m *Manager) Stop() error {
-	m.logger.Info("Stopping all servers...")
+// StartService starts a service that is not running, whether or not it is
+// enabled in the configuration. It fails if the listener cannot be bound.
+func (m *Manager) StartService(name string) error {
+	if !controlled(name) {
+		return fmt.Errorf("%w '%s'", ErrUnknownService, name)
+	}
+	return m.startService(strings.ToLower(name))
+}
+
+// startService binds and supervises a service
+func (m *Manager) startService(name string) error {
+	m.mutex.Lock()
+	if m.closing {
+		m.mutex.Unlock()
+		return fmt.Errorf("the server is shutting down")
+	}
+	if svc := m.find(name); svc != nil && svc.active() {
+		m.mutex.Unlock()
+		return fmt.Errorf("%s is already started (%s)", name, svc.state)
+	}
+	m.mutex.Unlock()
+
+	svc, server, err := m.bind(name)
+	if err != nil {
+		return err
+	}
+	m.supervise(svc, server)
+	return nil
+}
+
+// StopService stops a service, or its attempts to restart. Sessions
+// already connected are not ended.
+func (m *Manager) StopService(name string) error {
+	if !controlled(name) {
+		return fmt.Errorf("%w '%s'", ErrUnknownService, name)
+	}
+	return m.stopService(strings.ToLower(name))
+}
 
-	// Stop all servers
-	for _, server := range m.servers {
-		if err := server.Stop(); err != nil {
-			m.logger.Error("Failed to stop %s server: %v", server.Name(), err)
-		} else {
-			m.logger.Info("Stopped %s server", server.Name())
+// stopService stops a supervised service and waits for its supervisor
+func (m *Manager) stopService(name string) error {
+	return m.endService(name, Server.Stop)
+}
+
+// endService stops a supervised service with stop and waits for its
+// supervisor
+func (m *Manager) endService(name string, stop func(Server) error) error {
+	m.mutex.Lock()
+	svc := m.find(name)
+	if svc == nil || !svc.active() {
+		m.mutex.Unlock()
+		return fmt.Errorf("%s is not running", name)
+	}
+	svc.stopOnce.Do(func() { close(svc.stop) })
+	server := svc.server
+	m.mutex.Unlock()
+
+	var err error
+	if server != nil {
+		err = stop(server)
+	}
+	<-svc.done
+
+	if err != nil {
+		m.logger.Error("Failed to stop %s server: %v", svc.title, err)
+		return err
+	}
+	m.logger.Info("Stopped %s server", svc.title)
+	return nil
+}
+
+// Services describes the services that can be controlled, and the admin
+// API if it is enabled
+func (m *Manager) Services() []ServiceInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	services := make([]ServiceInfo, 0, len(controlledServices)+1)
+	for _, name := range append(append([]string(nil), controlledServices...), "admin") {
+		svc := m.find(name)
+		if svc == nil {
+			if name != "admin" {
+				services = append(services, ServiceInfo{Name: name, State: StateStopped})
+			}
+			continue
+		}
+		services = append(services, svc.info())
+	}
+	return services
+}
+
+// Sessions returns the sessions of all services
+func (m *Manager) Sessions() *SessionRegistry {
+	return m.sessions
+}
+
+// Shutdown stops all servers together, letting sessions finish their
+// transfers until ctx is done. Sessions still busy then are closed.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	// No reload may restart a service in the meantime
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+
+	m.logger.Info("Stopping all servers, waiting for transfers in progress...")
+	m.notify("STOPPING=1")
+
+	m.mutex.Lock()
+	m.closing = true
+	var names []string
+	for _, svc := range m.services {
+		if svc.active() {
+			names = append(names, svc.name)
 		}
 	}
+	m.mutex.Unlock()
+
+	// Drain all servers at once, each stopped by the same deadline
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.endService(name, func(server Server) error {
+				return server.Shutdown(ctx)
+			})
+		}()
+	}
+	wg.Wait()
 
 	// Wait for all goroutines to finish
 	m.wg.Wait()
 
 	m.logger.Info("All servers stopped")
-	return nil
+	return errors.Join(errs...)
 }
 
-// createServers creates server instances based on configuration
-func (m *Manager) createServers() error {
+// enabledServices returns the services enabled in a configuration, in the
+// order they are started
+func enabledServices(cfg *config.Config) ([]string, error) {
+	var names []string
+
 	// FTP Server
-	if m.config.Services.FTP.Enabled {
-		server := NewFTPServer(m.config, m.logger, m.authenticator, m.fileSystem)
-		m.servers = append(m.servers, server)
+	if cfg.Services.FTP.Enabled {
+		names = append(names, "ftp")
 	}
 
 	// TFTP Server
-	if m.config.Services.TFTP.Enabled {
-		server := NewTFTPServer(m.config, m.logger, m.authenticator, m.fileSystem)
-		m.servers = append(m.servers, server)
+	if cfg.Services.TFTP.Enabled {
+		names = append(names, "tftp")
 	}
 
 	// TODO: Add other servers (FTPS, SFTP, HTTP, HTTPS) in future phases
 
-	if len(m.servers) == 0 {
-		return fmt.Errorf("no servers enabled")
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no servers enabled")
+	}
+
+	// Metrics of the servers above
+	if cfg.Metrics.Enabled {
+		names = append(names, "metrics")
+	}
+
+	// Control of the servers above
+	if cfg.Admin.Enabled {
+		names = append(names, "admin")
 	}
 
+	return names, nil
+}
+
+// newServer creates the server of a service, nil for unknown services
+func (m *Manager) newServer(cfg *config.Config, name string) Server {
+	switch name {
+	case "ftp":
+		return NewFTPServer(cfg, m.rootLogger, m.authenticator, m.fileSystem, m.audit, m.sessions, m.sockets)
+	case "tftp":
+		return NewTFTPServer(cfg, m.rootLogger, m.authenticator, m.fileSystem, m.audit, m.sessions, m.sockets)
+	case "metrics":
+		return NewMetricsServer(cfg, m.rootLogger, m.HealthHandler(), m.sockets)
+	case "admin":
+		return NewAdminServer(cfg, m.rootLogger, m, m.authenticator)
+	}
 	return nil
 }
+
+// controlled returns true if a service can be started and stopped
+func controlled(name string) bool {
+	for _, service := range controlledServices {
+		if strings.EqualFold(service, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP extracts the IP address from a network address
+func remoteIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// serviceAccess builds the global and service access lists for a server
+func serviceAccess(cfg *config.Config, service string, access config.AccessConfig) ([]*auth.AccessList, error) {
+	global, err := auth.NewAccessList("global", cfg.Access)
+	if err != nil {
+		return nil, err
+	}
+	local, err := auth.NewAccessList(service, access)
+	if err != nil {
+		return nil, err
+	}
+	return []*auth.AccessList{global, local}, nil
+}
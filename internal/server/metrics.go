@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// MetricsServer serves the metrics of all services over HTTP, and the
+// health of the services for load balancers and orchestrators
+type MetricsServer struct {
+	config   *config.Config
+	logger   *utils.Logger
+	server   *http.Server
+	sockets  *Sockets
+	listener net.Listener
+}
+
+// NewMetricsServer creates a new metrics server
+func NewMetricsServer(cfg *config.Config, logger *utils.Logger, health http.Handler, sockets *Sockets) *MetricsServer {
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Metrics.Path, metrics.Handler())
+	mux.Handle(cfg.Metrics.HealthPath, health)
+
+	return &MetricsServer{
+		config: cfg,
+		logger: logger.Subsystem("metrics"),
+		server: &http.Server{
+			Addr:              cfg.Metrics.Listen,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		},
+		sockets: sockets,
+	}
+}
+
+// Listen binds the metrics listener
+func (s *MetricsServer) Listen() error {
+	// The socket passed by systemd, if any
+	listener, err := s.sockets.Listener("metrics")
+	if err != nil {
+		return err
+	}
+	if listener == nil {
+		listener, err = net.Listen("tcp", s.config.Metrics.Listen)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.config.Metrics.Listen, err)
+		}
+	}
+	s.listener = listener
+
+	s.logger.Info("Metrics available at http://%s%s", listener.Addr(), s.config.Metrics.Path)
+	return nil
+}
+
+// Serve answers requests until the server is stopped or ctx is done
+func (s *MetricsServer) Serve(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.server.Close() })
+	defer stop()
+
+	if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Stop stops the metrics server
+func (s *MetricsServer) Stop() error {
+	// The listener is only closed by the HTTP server once it serves
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	return s.server.Close()
+}
+
+// Shutdown stops accepting requests and waits for those in progress
+// until ctx is done, when they are cut off
+func (s *MetricsServer) Shutdown(ctx context.Context) error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		s.server.Close()
+		return err
+	}
+	return nil
+}
+
+// Name returns the server name
+func (s *MetricsServer) Name() string {
+	return "Metrics"
+}
+
+// Port returns the port the server is listening on
+func (s *MetricsServer) Port() int {
+	_, port, _ := net.SplitHostPort(s.config.Metrics.Listen)
+	n, _ := strconv.Atoi(port)
+	return n
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// identity is a user and its groups to run as
+type identity struct {
+	uid    int
+	gid    int
+	groups []int // supplementary groups
+}
+
+// dropPrivileges switches to the run_as user and group, then confines file
+// access with Landlock if asked to. Privileged ports cannot be bound after
+// this, so services restarted later need ports above 1023 or sockets
+// passed by systemd.
+func (m *Manager) dropPrivileges() error {
+	runAs := m.config.RunAs
+
+	if runAs.User != "" {
+		id, err := lookupIdentity(runAs.User, runAs.Group)
+		if err != nil {
+			return fmt.Errorf("failed to look up run_as user or group: %w", err)
+		}
+		switch os.Geteuid() {
+		case 0:
+			if err := handOver(m.config, id); err != nil {
+				return fmt.Errorf("failed to hand directories to the run_as user: %w", err)
+			}
+			if err := setIdentity(id); err != nil {
+				return fmt.Errorf("failed to drop privileges: %w", err)
+			}
+			m.logger.Info("Running as user %s (uid %d, gid %d)", runAs.User, id.uid, id.gid)
+		case id.uid:
+			m.logger.Debug("Already running as user %s", runAs.User)
+		default:
+			return fmt.Errorf("run_as needs the server to be started as root")
+		}
+
+		for _, dir := range []string{m.config.State, m.config.Data} {
+			if err := checkWritable(dir); err != nil {
+				return fmt.Errorf("%s is not writable by run_as user %s: %w", dir, runAs.User, err)
+			}
+		}
+	}
+
+	if runAs.Landlock {
+		writable, readable := confinedPaths(m.config)
+		if err := confine(writable, readable); err != nil {
+			return fmt.Errorf("failed to confine file access with Landlock: %w", err)
+		}
+		m.logger.Info("File access confined to %s, and reading %s", strings.Join(writable, ", "), strings.Join(readable, ", "))
+	}
+	return nil
+}
+
+// handOver gives the run_as identity the directories the server writes to
+// while still root. The state directory belongs to the server and is
+// handed over with everything in it. The data directory, user homes and
+// local mount sources are handed over only where root owns them, so trees
+// belonging to other users are left alone.
+func handOver(cfg *config.Config, id *identity) error {
+	err := filepath.WalkDir(cfg.State, func(name string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(name, id.uid, id.gid)
+	})
+	if err != nil {
+		return err
+	}
+
+	dirs := []string{cfg.Data}
+	users := make([]*config.User, 0, len(cfg.Users)+1)
+	for _, u := range cfg.Users {
+		users = append(users, u)
+	}
+	if anonymous := cfg.AnonymousUser(); anonymous != nil {
+		users = append(users, anonymous)
+	}
+	for _, u := range users {
+		dirs = append(dirs, u.GetFullPath(cfg.Data))
+		for _, mount := range u.ResolvedMounts() {
+			if mount.Storage.IsLocal() {
+				dirs = append(dirs, mount.Source)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		info, err := os.Stat(dir)
+		if err != nil || !info.IsDir() || !ownedByRoot(info) {
+			continue
+		}
+		if err := os.Chown(dir, id.uid, id.gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWritable returns an error if files cannot be created in dir
+func checkWritable(dir string) error {
+	file, err := os.CreateTemp(dir, ".ftp-aio-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// lookupIdentity resolves a user and an optional group, by name or id. Ids
+// without an entry in the user database are taken as they are.
+func lookupIdentity(name, group string) (*identity, error) {
+	id := &identity{}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		uid, numErr := strconv.Atoi(name)
+		if numErr != nil {
+			return nil, err
+		}
+		id.uid, id.gid = uid, uid
+		u, err = user.LookupId(name)
+	}
+	if err == nil {
+		id.uid, _ = strconv.Atoi(u.Uid)
+		id.gid, _ = strconv.Atoi(u.Gid)
+		gids, _ := u.GroupIds()
+		for _, gid := range gids {
+			if n, err := strconv.Atoi(gid); err == nil {
+				id.groups = append(id.groups, n)
+			}
+		}
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			gid, numErr := strconv.Atoi(group)
+			if numErr != nil {
+				return nil, err
+			}
+			id.gid = gid
+		} else {
+			id.gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	if len(id.groups) == 0 {
+		id.groups = []int{id.gid}
+	}
+	return id, nil
+}
+
+// confinedPaths returns the directories Landlock leaves writable: data,
+// state, log files and the local sources of every mount, including group
+// mounts and the anonymous upload directory. The directories of the
+// configuration file and TLS certificates are left readable so reloads
+// still work, as is /etc for name resolution.
+func confinedPaths(cfg *config.Config) (writable, readable []string) {
+	add := func(list *[]string, path string) {
+		if path == "" {
+			return
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return
+		}
+		for _, existing := range *list {
+			if existing == path {
+				return
+			}
+		}
+		*list = append(*list, path)
+	}
+
+	add(&writable, cfg.Data)
+	add(&writable, cfg.State)
+	for _, output := range cfg.Logging.Outputs {
+		if output.Type == config.LogOutputFile {
+			add(&writable, filepath.Dir(output.Path))
+		}
+	}
+	if cfg.TransferLog.Enabled {
+		add(&writable, filepath.Dir(cfg.TransferLog.Path))
+	}
+	if cfg.AuditLog.Enabled {
+		add(&writable, filepath.Dir(cfg.AuditLog.Path))
+	}
+
+	users := make([]*config.User, 0, len(cfg.Users)+1)
+	for _, u := range cfg.Users {
+		users = append(users, u)
+	}
+	if anonymous := cfg.AnonymousUser(); anonymous != nil {
+		users = append(users, anonymous)
+	}
+	for _, u := range users {
+		for _, mount := range u.ResolvedMounts() {
+			if mount.Storage.IsLocal() {
+				add(&writable, mount.Source)
+			}
+		}
+	}
+
+	add(&readable, "/etc")
+	if file := cfg.File(); file != "" {
+		add(&readable, filepath.Dir(file))
+	}
+	for _, file := range []string{cfg.Services.FTPS.Cert, cfg.Services.FTPS.Key, cfg.Services.HTTPS.Cert, cfg.Services.HTTPS.Key} {
+		if file != "" {
+			add(&readable, filepath.Dir(file))
+		}
+	}
+	return writable, readable
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+)
+
+// setIdentity is not available without unix credentials
+func setIdentity(id *identity) error {
+	return fmt.Errorf("run_as is not supported on this platform")
+}
+
+// ownedByRoot is never true without unix credentials
+func ownedByRoot(info os.FileInfo) bool {
+	return false
+}
@@ -0,0 +1,95 @@
+//go:build unix
+
+package server
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// droppedBaseEnv passes the test directory to the process that drops its
+// privileges, which cannot be regained in the test binary itself
+const droppedBaseEnv = "FTP_AIO_TEST_DROPPED_BASE"
+
+// droppedConfig is the configuration the dropped process runs with
+func droppedConfig(base string) *config.Config {
+	return &config.Config{
+		Data:  filepath.Join(base, "data"),
+		State: filepath.Join(base, "state"),
+		Users: map[string]*config.User{
+			"bob": {Name: "bob", Path: "/bob", Permissions: "rw"},
+		},
+		RunAs: config.RunAsConfig{User: "65534"},
+	}
+}
+
+// TestDropPrivilegesHandsOverState starts as root, lays out state and data
+// as root would at startup, and checks that the server can still write
+// them once it runs as the run_as user
+func TestDropPrivilegesHandsOverState(t *testing.T) {
+	if base := os.Getenv(droppedBaseEnv); base != "" {
+		writeAfterDrop(t, base)
+		return
+	}
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges needs root")
+	}
+
+	base, err := os.MkdirTemp("", "ftp-aio-run-as-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	if err := os.Chmod(base, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := droppedConfig(base)
+	for _, dir := range []string{filepath.Join(cfg.State, "trash"), filepath.Join(cfg.Data, "bob")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(cfg.State, "quota.json"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDropPrivilegesHandsOverState$", "-test.v")
+	cmd.Env = append(os.Environ(), droppedBaseEnv+"="+base)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("dropped process failed: %v\n%s", err, output)
+	}
+}
+
+// writeAfterDrop drops privileges and writes state and data as the server
+// does while running
+func writeAfterDrop(t *testing.T, base string) {
+	logger, err := utils.NewLogger("error", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := droppedConfig(base)
+	m := &Manager{config: cfg, logger: logger}
+	if err := m.dropPrivileges(); err != nil {
+		t.Fatal(err)
+	}
+	if os.Geteuid() == 0 {
+		t.Fatal("still running as root")
+	}
+
+	for _, name := range []string{
+		filepath.Join(cfg.State, "quota.json"),
+		filepath.Join(cfg.State, "bans.json"),
+		filepath.Join(cfg.State, "trash", "entry.json"),
+		filepath.Join(cfg.Data, "bob", "upload.txt"),
+	} {
+		if err := os.WriteFile(name, []byte("{}"), 0644); err != nil {
+			t.Errorf("write after dropping privileges: %v", err)
+		}
+	}
+}
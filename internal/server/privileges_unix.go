@@ -0,0 +1,35 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// setIdentity switches the process to a user and its groups, supplementary
+// groups first as they cannot be changed once root is given up
+func setIdentity(id *identity) error {
+	if err := syscall.Setgroups(id.groups); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(id.gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(id.uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+
+	// Root must not be regained
+	if id.uid != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("root privileges could be regained")
+	}
+	return nil
+}
+
+// ownedByRoot returns true if a file belongs to root
+func ownedByRoot(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Uid == 0
+}
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Merith-TK/ftp-aio/internal/auth"
+	"github.com/Merith-TK/ftp-aio/internal/config"
+)
+
+// reloadedServices are the services restarted by Reload when the settings
+// their server is built from change
+var reloadedServices = []string{"ftp", "tftp", "metrics", "admin"}
+
+// appliedSettings are the top level settings Reload applies while running.
+// Other changes, including group quotas, need a restart of the server.
+var appliedSettings = map[string]bool{
+	"Users":     true, // swapped into the authenticator
+	"Access":    true, // checked at login and swapped into the listeners
+	"Anonymous": true, // swapped into the authenticator, restarts the FTP service
+	"Metrics":   true,
+	"Admin":     true,
+}
+
+// Reload applies a new, validated configuration without interrupting
+// sessions: the users are swapped into the authenticator in one step,
+// access lists into the running servers, and services that were enabled,
+// disabled, moved to another port or, for FTP, given new anonymous
+// settings are restarted. The changes are
+// logged, including those that only take effect after a restart.
+func (m *Manager) Reload(cfg *config.Config) error {
+	m.reloadMutex.Lock()
+	defer m.reloadMutex.Unlock()
+
+	old := m.Config()
+
+	if err := m.authenticator.Update(cfg); err != nil {
+		return fmt.Errorf("failed to update users: %w", err)
+	}
+
+	m.mutex.Lock()
+	m.config = cfg
+	m.mutex.Unlock()
+
+	var restarted []string
+	for _, name := range reloadedServices {
+		if reflect.DeepEqual(listenerSettings(old, name), listenerSettings(cfg, name)) {
+			continue
+		}
+		m.restart(name, serviceEnabled(cfg, name))
+		restarted = append(restarted, name)
+	}
+	m.updateAccess(cfg)
+
+	added, removed, changed := diffUsers(old.Users, cfg.Users)
+	m.logger.Info("Configuration reloaded: users added %s, removed %s, changed %s; services restarted %s",
+		listOrNone(added), listOrNone(removed), listOrNone(changed), listOrNone(restarted))
+	if pending := pendingSettings(old, cfg); len(pending) > 0 {
+		m.logger.Warn("Changes to %s take effect after a restart", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Config returns the configuration in use
+func (m *Manager) Config() *config.Config {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.config
+}
+
+// restart stops a service if it is running and starts it again if it is
+// enabled. Sessions of the old server carry on.
+func (m *Manager) restart(name string, enabled bool) {
+	m.mutex.Lock()
+	svc := m.find(name)
+	active := svc != nil && svc.active()
+	m.mutex.Unlock()
+
+	if active {
+		m.stopService(name)
+	}
+	if enabled {
+		m.startService(name)
+	}
+}
+
+// accessSetter is implemented by servers that check clients against
+// access lists, which a reload replaces in place
+type accessSetter interface {
+	SetAccess(access []*auth.AccessList)
+}
+
+// updateAccess swaps the global and service access lists of cfg into the
+// running servers. New clients are checked against them at once.
+func (m *Manager) updateAccess(cfg *config.Config) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, svc := range m.services {
+		setter, ok := svc.server.(accessSetter)
+		if !ok {
+			continue
+		}
+		access, err := serviceAccess(cfg, svc.name, serviceAccessConfig(cfg, svc.name))
+		if err != nil {
+			m.logger.Error("Keeping the access rules of %s: %v", svc.name, err)
+			continue
+		}
+		setter.SetAccess(access)
+	}
+}
+
+// serviceAccessConfig returns the access rules of a service in cfg
+func serviceAccessConfig(cfg *config.Config, service string) config.AccessConfig {
+	switch service {
+	case "ftp":
+		return cfg.Services.FTP.Access
+	case "tftp":
+		return cfg.Services.TFTP.Access
+	}
+	return config.AccessConfig{}
+}
+
+// listenerSettings returns the settings a service's server is built from.
+// Access rules are not among them, they are swapped in place.
+func listenerSettings(cfg *config.Config, service string) any {
+	switch service {
+	case "ftp":
+		return []any{cfg.Services.FTP.Enabled, cfg.Services.FTP.Port, cfg.Anonymous}
+	case "tftp":
+		return []any{cfg.Services.TFTP.Enabled, cfg.Services.TFTP.Port}
+	case "metrics":
+		return cfg.Metrics
+	case "admin":
+		return cfg.Admin
+	}
+	return nil
+}
+
+// serviceEnabled returns true if a service is enabled in cfg
+func serviceEnabled(cfg *config.Config, service string) bool {
+	switch service {
+	case "ftp":
+		return cfg.Services.FTP.Enabled
+	case "tftp":
+		return cfg.Services.TFTP.Enabled
+	case "metrics":
+		return cfg.Metrics.Enabled
+	case "admin":
+		return cfg.Admin.Enabled
+	}
+	return false
+}
+
+// diffUsers compares two sets of users by name
+func diffUsers(old, new map[string]*config.User) (added, removed, changed []string) {
+	for name, user := range new {
+		if previous, exists := old[name]; !exists {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(previous, user) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, exists := new[name]; !exists {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// pendingSettings names the changed top level settings that Reload cannot
+// apply, by their YAML keys
+func pendingSettings(old, new *config.Config) []string {
+	// Listener settings of restarted services are applied
+	oldServices, newServices := old.Services, new.Services
+	for _, services := range []*config.ServiceConfig{&oldServices, &newServices} {
+		services.FTP.Enabled, services.FTP.Port, services.FTP.Access = false, 0, config.AccessConfig{}
+		services.TFTP.Enabled, services.TFTP.Port, services.TFTP.Access = false, 0, config.AccessConfig{}
+	}
+
+	var pending []string
+	if !reflect.DeepEqual(oldServices, newServices) {
+		pending = append(pending, "services")
+	}
+
+	oldValue, newValue := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		if !field.IsExported() || appliedSettings[field.Name] || field.Name == "Services" {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			pending = append(pending, strings.Split(field.Tag.Get("yaml"), ",")[0])
+		}
+	}
+	return pending
+}
+
+// listOrNone joins names for a log line
+func listOrNone(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session is a client connection, or a TFTP transfer, as listed by the
+// admin API
+type Session struct {
+	id      string
+	service string
+	remote  string // client address with port
+	started time.Time
+	kick    func()
+	drain   func() // asks the session to end once idle, may be nil
+
+	draining  atomic.Bool
+	drainOnce sync.Once
+
+	anonymous bool // holds an anonymous slot, guarded by the registry mutex
+
+	mutex    sync.Mutex
+	user     string
+	transfer *ActiveTransfer
+}
+
+// ActiveTransfer is the file transfer a session is busy with
+type ActiveTransfer struct {
+	path      string
+	direction string
+	started   time.Time
+	bytes     atomic.Int64
+	conn      io.Closer // data connection, closed when the session is kicked
+}
+
+// SessionInfo describes a session
+type SessionInfo struct {
+	ID       string        `json:"id"`
+	Service  string        `json:"service"`
+	User     string        `json:"user,omitempty"`
+	Remote   string        `json:"remote"`
+	Started  time.Time     `json:"started"`
+	Transfer *TransferInfo `json:"transfer,omitempty"`
+}
+
+// TransferInfo describes a transfer in progress
+type TransferInfo struct {
+	Path      string    `json:"path"`
+	Direction string    `json:"direction"`
+	Started   time.Time `json:"started"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// SetUser records who is logged in, empty after a logout
+func (s *Session) SetUser(name string) {
+	s.mutex.Lock()
+	s.user = name
+	s.mutex.Unlock()
+}
+
+// User returns who is logged in
+func (s *Session) User() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.user
+}
+
+// BeginTransfer records the start of a transfer. conn, which may be nil,
+// is closed if the session is kicked during the transfer.
+func (s *Session) BeginTransfer(path, direction string, conn io.Closer) *ActiveTransfer {
+	transfer := &ActiveTransfer{path: path, direction: direction, started: time.Now(), conn: conn}
+	s.mutex.Lock()
+	s.transfer = transfer
+	s.mutex.Unlock()
+	return transfer
+}
+
+// EndTransfer records the end of the current transfer
+func (s *Session) EndTransfer() {
+	s.mutex.Lock()
+	s.transfer = nil
+	s.mutex.Unlock()
+}
+
+// Kick ends the session, aborting its transfer
+func (s *Session) Kick() {
+	s.mutex.Lock()
+	transfer := s.transfer
+	s.mutex.Unlock()
+
+	if transfer != nil && transfer.conn != nil {
+		transfer.conn.Close()
+	}
+	if s.kick != nil {
+		s.kick()
+	}
+}
+
+// Draining returns true once the server shuts down. The session should end
+// after its current transfer.
+func (s *Session) Draining() bool {
+	return s.draining.Load()
+}
+
+// beginDrain marks the session draining and asks it to end once idle
+func (s *Session) beginDrain() {
+	s.drainOnce.Do(func() {
+		s.draining.Store(true)
+		if s.drain != nil {
+			s.drain()
+		}
+	})
+}
+
+// Info describes the session
+func (s *Session) Info() SessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	info := SessionInfo{
+		ID:      s.id,
+		Service: s.service,
+		User:    s.user,
+		Remote:  s.remote,
+		Started: s.started,
+	}
+	if t := s.transfer; t != nil {
+		info.Transfer = &TransferInfo{
+			Path:      t.path,
+			Direction: t.direction,
+			Started:   t.started,
+			Bytes:     t.bytes.Load(),
+		}
+	}
+	return info
+}
+
+// Add counts bytes transferred
+func (t *ActiveTransfer) Add(n int64) {
+	t.bytes.Add(n)
+}
+
+// Reader counts the bytes read through reader
+func (t *ActiveTransfer) Reader(reader io.Reader) io.Reader {
+	return &countingReader{reader: reader, transfer: t}
+}
+
+// countingReader adds what it reads to a transfer
+type countingReader struct {
+	reader   io.Reader
+	transfer *ActiveTransfer
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.transfer.bytes.Add(int64(n))
+	return n, err
+}
+
+// SessionRegistry keeps the sessions of all services
+type SessionRegistry struct {
+	mutex    sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewSessionRegistry creates an empty registry
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string]*Session)}
+}
+
+// Open registers a session. kick is called to end it from the admin API,
+// drain when the server shuts down.
+func (r *SessionRegistry) Open(id, service, remote string, kick, drain func()) *Session {
+	session := &Session{
+		id:      id,
+		service: service,
+		remote:  remote,
+		started: time.Now(),
+		kick:    kick,
+		drain:   drain,
+	}
+	r.mutex.Lock()
+	r.sessions[id] = session
+	r.mutex.Unlock()
+	return session
+}
+
+// Close unregisters a session
+func (r *SessionRegistry) Close(session *Session) {
+	r.mutex.Lock()
+	delete(r.sessions, session.id)
+	r.mutex.Unlock()
+}
+
+// ClaimAnonymous takes an anonymous login slot for session, returning
+// false if limit sessions already hold one. Zero means unlimited. The
+// count covers every server sharing the registry, so it survives reloads.
+func (r *SessionRegistry) ClaimAnonymous(session *Session, limit int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if session.anonymous {
+		return true
+	}
+	if limit > 0 {
+		active := 0
+		for _, s := range r.sessions {
+			if s.anonymous {
+				active++
+			}
+		}
+		if active >= limit {
+			return false
+		}
+	}
+	session.anonymous = true
+	return true
+}
+
+// ReleaseAnonymous gives back the anonymous login slot of session
+func (r *SessionRegistry) ReleaseAnonymous(session *Session) {
+	r.mutex.Lock()
+	session.anonymous = false
+	r.mutex.Unlock()
+}
+
+// List describes all sessions, oldest first
+func (r *SessionRegistry) List() []SessionInfo {
+	r.mutex.Lock()
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+	r.mutex.Unlock()
+
+	infos := make([]SessionInfo, len(sessions))
+	for i, session := range sessions {
+		infos[i] = session.Info()
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// Kick ends a session by id, returning false if there is none
+func (r *SessionRegistry) Kick(id string) bool {
+	r.mutex.Lock()
+	session, exists := r.sessions[id]
+	r.mutex.Unlock()
+
+	if !exists {
+		return false
+	}
+	session.Kick()
+	return true
+}
+
+// KickUser ends all sessions of a user and returns how many there were
+func (r *SessionRegistry) KickUser(username string) int {
+	return r.kickWhere(func(s *Session) bool {
+		return s.User() == username
+	})
+}
+
+// KickIP ends all sessions from an address and returns how many there were
+func (r *SessionRegistry) KickIP(ip net.IP) int {
+	return r.kickWhere(func(s *Session) bool {
+		host, _, err := net.SplitHostPort(s.remote)
+		if err != nil {
+			host = s.remote
+		}
+		return ip.Equal(net.ParseIP(host))
+	})
+}
+
+// Drain asks the sessions of a service to end once idle and waits until
+// they have. Sessions still open when ctx is done are kicked, and their
+// number is returned.
+func (r *SessionRegistry) Drain(ctx context.Context, service string) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		// Sessions opened since the last round are drained too
+		remaining := r.where(func(s *Session) bool {
+			return s.service == service
+		})
+		if len(remaining) == 0 {
+			return 0
+		}
+		for _, session := range remaining {
+			session.beginDrain()
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			for _, session := range remaining {
+				session.Kick()
+			}
+			return len(remaining)
+		}
+	}
+}
+
+// kickWhere ends the sessions matching a condition
+func (r *SessionRegistry) kickWhere(match func(*Session) bool) int {
+	matched := r.where(match)
+	for _, session := range matched {
+		session.Kick()
+	}
+	return len(matched)
+}
+
+// where returns the sessions matching a condition
+func (r *SessionRegistry) where(match func(*Session) bool) []*Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var matched []*Session
+	for _, session := range r.sessions {
+		if match(session) {
+			matched = append(matched, session)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/config"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
+)
+
+// States of a service
+const (
+	StateStarting = "starting" // binding its listener
+	StateRunning  = "running"  // serving clients
+	StateFailed   = "failed"   // could not bind, or stopped on its own
+	StateStopped  = "stopped"  // stopped on purpose
+)
+
+// ServiceInfo describes the state of a service
+type ServiceInfo struct {
+	Name     string    `json:"name"`
+	Port     int       `json:"port,omitempty"`
+	State    string    `json:"state"`
+	Error    string    `json:"error,omitempty"` // why the service failed
+	Since    time.Time `json:"since"`           // when it entered the state
+	Restarts int       `json:"restarts"`
+}
+
+// service is a supervised server. A restart replaces the server with a
+// new instance.
+type service struct {
+	name     string // as in the configuration, such as "ftp"
+	title    string // as the server names itself, such as "FTP"
+	port     int
+	server   Server // the instance serving, nil while failed or stopped
+	state    string
+	err      error
+	since    time.Time
+	restarts int
+
+	stop     chan struct{} // closed to stop the service
+	stopOnce sync.Once
+	done     chan struct{} // closed when the supervisor returns, nil without one
+}
+
+// active returns true while a supervisor runs the service, or retries it.
+// Must be called with the manager's mutex held.
+func (s *service) active() bool {
+	if s.done == nil {
+		return false
+	}
+	select {
+	case <-s.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// info describes the service. Must be called with the manager's mutex held.
+func (s *service) info() ServiceInfo {
+	info := ServiceInfo{
+		Name:     s.name,
+		Port:     s.port,
+		State:    s.state,
+		Since:    s.since,
+		Restarts: s.restarts,
+	}
+	if s.err != nil {
+		info.Error = s.err.Error()
+	}
+	return info
+}
+
+// find returns the service of a name, nil if it was never started. Must be
+// called with the mutex held.
+func (m *Manager) find(name string) *service {
+	for _, svc := range m.services {
+		if svc.name == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+// bind creates the server of a service and binds its listener. If that
+// fails the service is left failed, and with the retry policy the
+// supervisor keeps trying in the background.
+func (m *Manager) bind(name string) (*service, Server, error) {
+	cfg := m.Config()
+	server := m.newServer(cfg, name)
+
+	svc := &service{
+		name:  name,
+		title: server.Name(),
+		port:  server.Port(),
+		stop:  make(chan struct{}),
+	}
+	m.mutex.Lock()
+	replaced := false
+	for i, previous := range m.services {
+		if previous.name == name {
+			svc.restarts = previous.restarts
+			m.services[i] = svc
+			replaced = true
+		}
+	}
+	if !replaced {
+		m.services = append(m.services, svc)
+	}
+	m.mutex.Unlock()
+
+	m.setState(svc, StateStarting, nil)
+	m.logger.Info("Starting %s server on port %d", svc.title, svc.port)
+
+	if err := server.Listen(); err != nil {
+		m.logger.Error("Failed to start %s server: %v", svc.title, err)
+		m.setState(svc, StateFailed, err)
+		if cfg.Supervisor.StartFailure == config.StartRetry {
+			m.supervise(svc, nil)
+		}
+		return nil, nil, err
+	}
+	return svc, server, nil
+}
+
+// supervise serves a bound server in the background. When the server stops
+// on its own, or server is nil because it could not bind, it is replaced
+// by a new instance after a growing delay, if restarts are enabled.
+func (m *Manager) supervise(svc *service, server Server) {
+	done := make(chan struct{})
+	m.mutex.Lock()
+	svc.done = done
+	m.mutex.Unlock()
+
+	// A bound server is running from here on, clients wait in the backlog
+	// until it accepts them
+	if server != nil {
+		m.setState(svc, StateRunning, nil)
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(done)
+
+		backoff := m.Config().Supervisor.MinBackoff
+		for {
+			if server == nil {
+				if !m.wait(svc, backoff) {
+					m.setState(svc, StateStopped, nil)
+					return
+				}
+				backoff = min(backoff*2, m.Config().Supervisor.MaxBackoff)
+
+				m.countRestart(svc)
+				server = m.newServer(m.Config(), svc.name)
+				m.setState(svc, StateStarting, nil)
+				if err := server.Listen(); err != nil {
+					m.logger.Error("Failed to restart %s server: %v", svc.title, err)
+					m.setState(svc, StateFailed, err)
+					server = nil
+					continue
+				}
+				m.setState(svc, StateRunning, nil)
+			}
+
+			// Hand the instance to StopService, unless it came first
+			m.mutex.Lock()
+			svc.server = server
+			m.mutex.Unlock()
+			if m.stopping(svc) {
+				server.Stop()
+				m.setState(svc, StateStopped, nil)
+				return
+			}
+
+			started := time.Now()
+			err := server.Serve(m.ctx)
+
+			m.mutex.Lock()
+			svc.server = nil
+			m.mutex.Unlock()
+
+			if m.stopping(svc) {
+				server.Stop()
+				m.setState(svc, StateStopped, nil)
+				return
+			}
+			if err == nil {
+				err = errors.New("stopped unexpectedly")
+			}
+			server.Stop()
+			m.logger.Error("%s server failed: %v", svc.title, err)
+			m.setState(svc, StateFailed, err)
+
+			cfg := m.Config().Supervisor
+			if !cfg.Restart {
+				return
+			}
+			// A server that ran for a while starts over with a short delay
+			if time.Since(started) > cfg.MaxBackoff {
+				backoff = cfg.MinBackoff
+			}
+			server = nil
+		}
+	}()
+}
+
+// stopping returns true once a service is stopped or the manager is done
+func (m *Manager) stopping(svc *service) bool {
+	select {
+	case <-svc.stop:
+		return true
+	case <-m.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// wait waits before a restart, returning false if the service is stopped
+// in the meantime
+func (m *Manager) wait(svc *service, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-svc.stop:
+		return false
+	case <-m.ctx.Done():
+		return false
+	}
+}
+
+// setState records the state of a service and why it failed
+func (m *Manager) setState(svc *service, state string, err error) {
+	m.mutex.Lock()
+	svc.state = state
+	svc.err = err
+	svc.since = time.Now()
+	m.mutex.Unlock()
+	m.notify(m.status())
+
+	up := 0.0
+	if state == StateRunning {
+		up = 1
+	}
+	metrics.ServiceUp.Set(up, svc.name)
+}
+
+// countRestart counts an attempt to restart a service
+func (m *Manager) countRestart(svc *service) {
+	m.mutex.Lock()
+	svc.restarts++
+	m.mutex.Unlock()
+	metrics.ServiceRestarts.Inc(svc.name)
+}
+
+// Healthy returns true if no started service is failed or still starting
+func (m *Manager) Healthy() bool {
+	for _, info := range m.Services() {
+		if info.State == StateFailed || info.State == StateStarting {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthHandler answers health checks with the state of every service,
+// 200 when healthy and 503 otherwise
+func (m *Manager) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, code := "ok", http.StatusOK
+		if !m.Healthy() {
+			status, code = "unhealthy", http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, map[string]any{
+			"status":   status,
+			"services": m.Services(),
+		})
+	})
+}
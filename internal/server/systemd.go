@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Merith-TK/ftp-aio/internal/utils"
+)
+
+// Sockets are the sockets passed by systemd socket activation, by the name
+// of the service they are for. Services with a socket use it instead of
+// binding their own, so privileged ports work without root.
+type Sockets struct {
+	files   map[string]*os.File
+	ignored []string // names of sockets no service uses
+}
+
+// NewSockets keeps activated sockets by their names. A socket whose name
+// is not a service, or is taken by an earlier one, is ignored.
+func NewSockets(files []*os.File) *Sockets {
+	s := &Sockets{files: make(map[string]*os.File)}
+	for _, file := range files {
+		name := file.Name()
+		if _, taken := s.files[name]; taken || !knownService(name) {
+			s.ignored = append(s.ignored, name)
+			file.Close()
+			continue
+		}
+		s.files[name] = file
+	}
+	return s
+}
+
+// Listener returns a stream listener on the socket of a service, nil if it
+// has none. The socket stays open when the listener is closed, so the
+// service can be restarted on it.
+func (s *Sockets) Listener(service string) (net.Listener, error) {
+	file := s.file(service)
+	if file == nil {
+		return nil, nil
+	}
+	listener, err := net.FileListener(file)
+	if err != nil {
+		return nil, fmt.Errorf("activated socket '%s' is not a listening stream socket: %w", service, err)
+	}
+	return listener, nil
+}
+
+// UDPConn returns a connection on the UDP socket of a service, nil if it
+// has none. The socket stays open when the connection is closed.
+func (s *Sockets) UDPConn(service string) (*net.UDPConn, error) {
+	file := s.file(service)
+	if file == nil {
+		return nil, nil
+	}
+	conn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, fmt.Errorf("activated socket '%s' is not a datagram socket: %w", service, err)
+	}
+	udp, ok := conn.(*net.UDPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("activated socket '%s' is not a UDP socket", service)
+	}
+	return udp, nil
+}
+
+// Names lists the services with a socket
+func (s *Sockets) Names() []string {
+	if s == nil {
+		return nil
+	}
+	var names []string
+	for _, name := range reloadedServices {
+		if s.files[name] != nil {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// file returns the socket of a service, nil without one
+func (s *Sockets) file(service string) *os.File {
+	if s == nil {
+		return nil
+	}
+	return s.files[service]
+}
+
+// knownService returns true for the name of a service
+func knownService(name string) bool {
+	for _, service := range reloadedServices {
+		if service == name {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends a state to systemd when it runs the server
+func (m *Manager) notify(state string) {
+	if err := utils.Notify(state); err != nil {
+		m.logger.Warn("%v", err)
+	}
+}
+
+// status summarizes the services for systemctl status
+func (m *Manager) status() string {
+	var parts []string
+	for _, info := range m.Services() {
+		parts = append(parts, info.Name+" "+info.State)
+	}
+	return "STATUS=" + strings.Join(parts, ", ")
+}
+
+// RunWatchdog pings the systemd watchdog at half its interval while the
+// services are healthy, so that systemd restarts a server that hangs or
+// whose services stay failed. It returns at once if the watchdog is not
+// enabled, and when ctx is done.
+func (m *Manager) RunWatchdog(ctx context.Context) {
+	interval := utils.WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	m.logger.Debug("Pinging the systemd watchdog every %s", interval/2)
+
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Only log when the health changes
+		if !m.Healthy() {
+			if healthy {
+				m.logger.Warn("Not pinging the systemd watchdog while services are failed")
+			}
+			healthy = false
+			continue
+		}
+		if !healthy {
+			m.logger.Info("Services recovered, pinging the systemd watchdog again")
+		}
+		healthy = true
+		m.notify("WATCHDOG=1")
+	}
+}
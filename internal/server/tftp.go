@@ -3,16 +3,22 @@ package server
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Merith-TK/ftp-aio/internal/audit"
 	"github.com/Merith-TK/ftp-aio/internal/auth"
 	"github.com/Merith-TK/ftp-aio/internal/config"
 	"github.com/Merith-TK/ftp-aio/internal/fs"
+	"github.com/Merith-TK/ftp-aio/internal/metrics"
 	"github.com/Merith-TK/ftp-aio/internal/utils"
 )
 
@@ -48,20 +54,40 @@ type transferState struct {
 	user        *config.User
 	filename    string
 	isUpload    bool        // true for upload (WRQ), false for download (RRQ)
-	writer      io.WriteCloser  // for uploads
+	writer      fs.FileWriter   // for uploads
 	reader      io.ReadCloser   // for downloads
 	blockNum    uint16
 	lastPacket  []byte      // for retransmission
+	lastActive  time.Time   // when the client was last heard from
+	started     time.Time   // when the transfer began
+	logger      *utils.Logger // carries the session, client and user
+	finished    bool        // the last block of a download was sent
+	complete    bool        // the client received or sent the whole file
+	bytes       int64       // file data transferred so far
+	session     string
+	remote      string      // client IP address
+	status      *Session    // listed by the admin API
+	progress    *ActiveTransfer
 }
 
+// tftpIdleTimeout is how long a transfer may go without packets before it
+// is abandoned, releasing its file
+const tftpIdleTimeout = 30 * time.Second
+
 // TFTPServer implements the TFTP protocol server
 type TFTPServer struct {
 	config        *config.Config
 	logger        *utils.Logger
 	authenticator *auth.Authenticator
 	fileSystem    *fs.FileSystem
+	audit         *audit.Recorder
+	sessions      *SessionRegistry
+	sockets       *Sockets
 	conn          *net.UDPConn
+	access        atomic.Pointer[[]*auth.AccessList] // swapped in place by a reload
 	done          chan struct{}
+	stopOnce      sync.Once
+	draining      atomic.Bool // refusing new transfers as the server shuts down
 	
 	// Active transfers map: clientAddr -> transfer state
 	transfers map[string]*transferState
@@ -69,80 +95,150 @@ type TFTPServer struct {
 }
 
 // NewTFTPServer creates a new TFTP server
-func NewTFTPServer(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem) *TFTPServer {
+func NewTFTPServer(cfg *config.Config, logger *utils.Logger, authenticator *auth.Authenticator, fileSystem *fs.FileSystem, recorder *audit.Recorder, sessions *SessionRegistry, sockets *Sockets) *TFTPServer {
 	return &TFTPServer{
 		config:        cfg,
-		logger:        logger,
+		logger:        logger.Subsystem("tftp"),
 		authenticator: authenticator,
 		fileSystem:    fileSystem,
+		audit:         recorder,
+		sessions:      sessions,
+		sockets:       sockets,
 		done:          make(chan struct{}),
 		transfers:     make(map[string]*transferState),
 	}
 }
 
-// Start starts the TFTP server
-func (s *TFTPServer) Start(ctx context.Context) error {
+// Listen binds the TFTP port
+func (s *TFTPServer) Listen() error {
 	port := s.config.Services.TFTP.Port
 
+	// Global and service network restrictions
+	access, err := serviceAccess(s.config, "tftp", s.config.Services.TFTP.Access)
+	if err != nil {
+		return err
+	}
+	s.SetAccess(access)
+
+	// Take the socket passed by systemd, if any
+	conn, err := s.sockets.UDPConn("tftp")
+	if err != nil {
+		return err
+	}
+	if conn != nil {
+		s.conn = conn
+		s.logger.Info("TFTP server listening on %s, passed by systemd", conn.LocalAddr())
+		return nil
+	}
+
 	// Start listening on UDP
 	addr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to resolve UDP address: %w", err)
 	}
 
-	conn, err := net.ListenUDP("udp", addr)
+	conn, err = net.ListenUDP("udp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on UDP port %d: %w", port, err)
 	}
 	s.conn = conn
 
 	s.logger.Info("TFTP server listening on port %d", port)
+	return nil
+}
+
+// Serve handles packets until the server is stopped or ctx is done. It
+// returns an error if the socket fails.
+func (s *TFTPServer) Serve(ctx context.Context) error {
+	// Give up on transfers whose client disappeared
+	go s.reapTransfers()
+
+	buffer := make([]byte, 516) // TFTP max packet size
 
-	// Handle packets in a goroutine
-	go func() {
-		buffer := make([]byte, 516) // TFTP max packet size
+	for {
+		select {
+		case <-s.done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		default:
+		}
 
-		for {
+		// Set read timeout to avoid blocking forever
+		s.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+
+		n, clientAddr, err := s.conn.ReadFromUDP(buffer)
+		if err != nil {
+			// Check if it's a timeout
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
 			select {
 			case <-s.done:
-				return
+				return nil
 			default:
-				// Set read timeout to avoid blocking forever
-				s.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-				
-				n, clientAddr, err := s.conn.ReadFromUDP(buffer)
-				if err != nil {
-					// Check if it's a timeout
-					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-						continue
-					}
-					select {
-					case <-s.done:
-						return
-					default:
-						s.logger.Error("Failed to read UDP packet: %v", err)
-						continue
-					}
-				}
-
-				// Handle packet in a separate goroutine
-				go s.handlePacket(buffer[:n], clientAddr)
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return fmt.Errorf("TFTP socket closed: %w", err)
+			}
+			s.logger.Error("Failed to read UDP packet: %v", err)
+			continue
 		}
-	}()
 
-	// Wait for context cancellation
-	<-ctx.Done()
-	return nil
+		// Drop packets from networks that are not permitted
+		if err := auth.CheckAccess(clientAddr.IP, *s.access.Load()...); err != nil {
+			s.logger.Warn("Rejected TFTP packet: %v", err)
+			continue
+		}
+
+		// Handle packet in a separate goroutine, with its own copy as the
+		// buffer is reused for the next one
+		packet := append([]byte(nil), buffer[:n]...)
+		go s.handlePacket(packet, clientAddr)
+	}
 }
 
 // Stop stops the TFTP server
 func (s *TFTPServer) Stop() error {
-	close(s.done)
-	if s.conn != nil {
-		return s.conn.Close()
-	}
-	return nil
+	var err error
+	s.stopOnce.Do(func() {
+		close(s.done)
+
+		// Discard uploads that never completed
+		s.transfersMutex.RLock()
+		var pending []string
+		for clientKey := range s.transfers {
+			pending = append(pending, clientKey)
+		}
+		s.transfersMutex.RUnlock()
+		for _, clientKey := range pending {
+			s.abortTransfer(clientKey)
+		}
+
+		if s.conn != nil {
+			err = s.conn.Close()
+		}
+	})
+	return err
+}
+
+// Shutdown refuses new transfers and waits for those in progress, which
+// share the socket, before stopping. Transfers still going when ctx is done
+// are cancelled.
+func (s *TFTPServer) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+	closed := s.sessions.Drain(ctx, "tftp")
+	err := s.Stop()
+	if closed > 0 {
+		return fmt.Errorf("cancelled %d transfers that did not finish in time", closed)
+	}
+	return err
+}
+
+// SetAccess replaces the global and service access lists new clients are
+// checked against
+func (s *TFTPServer) SetAccess(access []*auth.AccessList) {
+	s.access.Store(&access)
 }
 
 // Name returns the server name
@@ -164,8 +260,22 @@ func (s *TFTPServer) handlePacket(data []byte, clientAddr *net.UDPAddr) {
 
 	opcode := binary.BigEndian.Uint16(data[:2])
 	clientKey := clientAddr.String()
+	logger := s.logger.With("service", "tftp", "remote", clientKey)
 	
-	s.logger.Debug("TFTP packet from %s: opcode=%d, size=%d", clientAddr, opcode, len(data))
+	logger.Debug("TFTP packet from %s: opcode=%d, size=%d", clientAddr, opcode, len(data))
+
+	// Refuse new transfers from banned clients
+	if (opcode == OpRRQ || opcode == OpWRQ) && s.authenticator.IsBanned(clientAddr.IP) {
+		logger.Debug("Refusing TFTP request from banned address %s", clientAddr)
+		s.sendError(clientAddr, ErrAccessViolation, "Access denied")
+		return
+	}
+
+	// Transfers in progress go on while the server shuts down
+	if (opcode == OpRRQ || opcode == OpWRQ) && s.draining.Load() {
+		s.sendError(clientAddr, ErrNotDefined, "Server shutting down")
+		return
+	}
 
 	switch opcode {
 	case OpRRQ:
@@ -176,8 +286,12 @@ func (s *TFTPServer) handlePacket(data []byte, clientAddr *net.UDPAddr) {
 		s.handleDATA(data, clientAddr, clientKey)
 	case OpACK:
 		s.handleACK(data, clientAddr, clientKey)
+	case OpERROR:
+		// The client gave up, drop whatever it was sending
+		logger.Debug("TFTP transfer aborted by %s", clientAddr)
+		s.abortTransfer(clientKey)
 	default:
-		s.logger.Debug("Unsupported TFTP opcode: %d", opcode)
+		logger.Debug("Unsupported TFTP opcode: %d", opcode)
 		s.sendError(clientAddr, ErrIllegalOperation, "Unsupported operation")
 	}
 }
@@ -203,14 +317,17 @@ func (s *TFTPServer) parseRequest(data []byte) (filename, mode string, err error
 
 // handleRRQ handles a Read Request
 func (s *TFTPServer) handleRRQ(data []byte, clientAddr *net.UDPAddr) {
+	session := utils.NewSessionID()
+	logger := s.logger.With("session", session, "service", "tftp", "remote", clientAddr.String())
+	
 	filename, mode, err := s.parseRequest(data)
 	if err != nil {
-		s.logger.Debug("Invalid RRQ: %v", err)
+		logger.Debug("Invalid RRQ: %v", err)
 		s.sendError(clientAddr, ErrIllegalOperation, err.Error())
 		return
 	}
 	
-	s.logger.Debug("TFTP RRQ: file=%s, mode=%s, client=%s", filename, mode, clientAddr)
+	logger.Debug("TFTP RRQ: file=%s, mode=%s, client=%s", filename, mode, clientAddr)
 	
 	// For TFTP, we'll use a default user or anonymous access
 	// In a real implementation, you might want to add authentication
@@ -219,15 +336,28 @@ func (s *TFTPServer) handleRRQ(data []byte, clientAddr *net.UDPAddr) {
 		s.sendError(clientAddr, ErrAccessViolation, "No default user configured")
 		return
 	}
+	logger = logger.With("user", user.Name)
 	
-	// Normalize filename
-	if !strings.HasPrefix(filename, "/") {
-		filename = "/" + filename
-	}
+	// Resolve filename inside the user's home
+	filename = s.userPath(user, filename)
 	
-	// Check read permission
-	if err := auth.CheckPermission(user, s.config.Data, filename, auth.PermissionRead); err != nil {
-		s.logger.Debug("TFTP RRQ permission denied: %v", err)
+	// The default user's own network restrictions apply as for a login,
+	// and its read permission
+	err = auth.CheckUserAccess(clientAddr.IP, user)
+	if err == nil {
+		err = auth.CheckPermission(user, s.config.Data, filename, auth.PermissionRead)
+	}
+	if err != nil {
+		logger.Debug("TFTP RRQ denied: %v", err)
+		s.audit.Event(audit.Event{
+			Service: "tftp",
+			Session: session,
+			User:    user.Name,
+			Remote:  clientAddr.IP.String(),
+			Action:  audit.ActionDenied,
+			Path:    filename,
+			Error:   err.Error(),
+		})
 		s.sendError(clientAddr, ErrAccessViolation, "Access denied")
 		return
 	}
@@ -235,37 +365,48 @@ func (s *TFTPServer) handleRRQ(data []byte, clientAddr *net.UDPAddr) {
 	// Open file
 	reader, err := s.fileSystem.ReadFile(user, filename)
 	if err != nil {
-		s.logger.Debug("TFTP RRQ file not found: %v", err)
-		s.sendError(clientAddr, ErrFileNotFound, "File not found")
+		logger.Debug("TFTP RRQ file not found: %v", err)
+		if errors.Is(err, fs.ErrLocked) {
+			s.sendError(clientAddr, ErrNotDefined, "File is in use")
+		} else {
+			s.sendError(clientAddr, ErrFileNotFound, "File not found")
+		}
 		return
 	}
 	
 	// Create transfer state
 	clientKey := clientAddr.String()
-	s.transfersMutex.Lock()
-	s.transfers[clientKey] = &transferState{
-		user:     user,
-		filename: filename,
-		isUpload: false,
-		reader:   reader,
-		blockNum: 1, // Start with block 1
-	}
-	s.transfersMutex.Unlock()
+	transfer := &transferState{
+		user:       user,
+		filename:   filename,
+		isUpload:   false,
+		reader:     reader,
+		blockNum:   1, // Start with block 1
+		lastActive: time.Now(),
+		started:    time.Now(),
+		logger:     logger,
+		session:    session,
+		remote:     clientAddr.IP.String(),
+	}
+	s.startTransfer(clientKey, transfer)
 	
 	// Send first block
-	s.sendNextBlock(s.transfers[clientKey], clientAddr, clientKey)
+	s.sendNextBlock(transfer, clientAddr, clientKey)
 }
 
 // handleWRQ handles a Write Request  
 func (s *TFTPServer) handleWRQ(data []byte, clientAddr *net.UDPAddr) {
+	session := utils.NewSessionID()
+	logger := s.logger.With("session", session, "service", "tftp", "remote", clientAddr.String())
+	
 	filename, mode, err := s.parseRequest(data)
 	if err != nil {
-		s.logger.Debug("Invalid WRQ: %v", err)
+		logger.Debug("Invalid WRQ: %v", err)
 		s.sendError(clientAddr, ErrIllegalOperation, err.Error())
 		return
 	}
 	
-	s.logger.Debug("TFTP WRQ: file=%s, mode=%s, client=%s", filename, mode, clientAddr)
+	logger.Debug("TFTP WRQ: file=%s, mode=%s, client=%s", filename, mode, clientAddr)
 	
 	// For TFTP, we'll use a default user or anonymous access
 	user := s.getDefaultUser()
@@ -273,44 +414,62 @@ func (s *TFTPServer) handleWRQ(data []byte, clientAddr *net.UDPAddr) {
 		s.sendError(clientAddr, ErrAccessViolation, "No default user configured")
 		return
 	}
+	logger = logger.With("user", user.Name)
 	
-	// Check if user has write permissions
-	if user.IsReadOnly() {
-		s.sendError(clientAddr, ErrAccessViolation, "Read-only access")
-		return
-	}
+	// Resolve filename inside the user's home
+	filename = s.userPath(user, filename)
 	
-	// Normalize filename
-	if !strings.HasPrefix(filename, "/") {
-		filename = "/" + filename
+	// The default user's own network restrictions apply as for a login,
+	// and its write permission
+	err = auth.CheckUserAccess(clientAddr.IP, user)
+	if err == nil {
+		err = auth.CheckPermission(user, s.config.Data, filename, auth.PermissionWrite)
 	}
-	
-	// Check write permission
-	if err := auth.CheckPermission(user, s.config.Data, filename, auth.PermissionWrite); err != nil {
-		s.logger.Debug("TFTP WRQ permission denied: %v", err)
+	if err != nil {
+		logger.Debug("TFTP WRQ denied: %v", err)
+		s.audit.Event(audit.Event{
+			Service: "tftp",
+			Session: session,
+			User:    user.Name,
+			Remote:  clientAddr.IP.String(),
+			Action:  audit.ActionDenied,
+			Path:    filename,
+			Error:   err.Error(),
+		})
 		s.sendError(clientAddr, ErrAccessViolation, "Access denied")
 		return
 	}
 	
 	// Create file writer
-	writer, err := s.fileSystem.WriteFile(user, filename)
+	atomic := s.config.Services.TFTP.UseAtomicUploads(user)
+	writer, err := s.fileSystem.WriteFile(user, filename, fs.WriteOptions{Atomic: atomic})
 	if err != nil {
-		s.logger.Debug("TFTP WRQ failed to create file: %v", err)
-		s.sendError(clientAddr, ErrAccessViolation, "Cannot create file")
+		logger.Debug("TFTP WRQ failed to create file: %v", err)
+		if errors.Is(err, fs.ErrQuotaExceeded) {
+			s.sendError(clientAddr, ErrDiskFull, "Quota exceeded")
+		} else if errors.Is(err, fs.ErrLocked) {
+			s.sendError(clientAddr, ErrNotDefined, "File is in use")
+		} else {
+			s.sendError(clientAddr, ErrAccessViolation, "Cannot create file")
+		}
 		return
 	}
 	
 	// Create transfer state
 	clientKey := clientAddr.String()
-	s.transfersMutex.Lock()
-	s.transfers[clientKey] = &transferState{
-		user:     user,
-		filename: filename,
-		isUpload: true,
-		writer:   writer,
-		blockNum: 1, // Expecting block 1 first
-	}
-	s.transfersMutex.Unlock()
+	transfer := &transferState{
+		user:       user,
+		filename:   filename,
+		isUpload:   true,
+		writer:     writer,
+		blockNum:   1, // Expecting block 1 first
+		lastActive: time.Now(),
+		started:    time.Now(),
+		logger:     logger,
+		session:    session,
+		remote:     clientAddr.IP.String(),
+	}
+	s.startTransfer(clientKey, transfer)
 	
 	// Send initial ACK (block 0) to start the transfer
 	s.sendACK(0, clientAddr)
@@ -436,6 +595,7 @@ func (s *TFTPServer) sendError(clientAddr *net.UDPAddr, errorCode uint16, messag
 	errorPacket[len(errorPacket)-1] = 0 // Null terminator
 	
 	s.conn.WriteToUDP(errorPacket, clientAddr)
+	metrics.Errors.Inc("tftp", strconv.Itoa(int(errorCode)))
 }
 
 // waitForACK waits for an ACK packet for the specified block number
@@ -497,22 +657,35 @@ func (s *TFTPServer) waitForDATA(clientAddr *net.UDPAddr, timeout time.Duration)
 }
 
 // getDefaultUser returns a default user for TFTP operations
-// In a real implementation, you might want to configure this or use anonymous access
+// In a real implementation, you might want to configure this or use anonymous access.
+// Users come from the authenticator, so reloads and the admin API apply at once.
 func (s *TFTPServer) getDefaultUser() *config.User {
+	var users []*config.User
+	for _, name := range s.authenticator.ListUsers() {
+		if user, exists := s.authenticator.GetUser(name); exists && !user.Disabled {
+			users = append(users, user)
+		}
+	}
+	
 	// Try to find the first user with write permissions for uploads
-	for _, user := range s.config.Users {
+	for _, user := range users {
 		if !user.IsReadOnly() {
 			return user // Return the first user with write permissions
 		}
 	}
 	
 	// If no write user found, return the first user (for read operations)
-	for _, user := range s.config.Users {
-		return user
+	if len(users) > 0 {
+		return users[0]
 	}
 	return nil
 }
 
+// userPath maps a client filename into the user's home directory
+func (s *TFTPServer) userPath(user *config.User, filename string) string {
+	return path.Join("/", user.Path, path.Clean("/"+filename))
+}
+
 // handleDATA handles a DATA packet during an upload
 func (s *TFTPServer) handleDATA(data []byte, clientAddr *net.UDPAddr, clientKey string) {
 	if len(data) < 4 {
@@ -523,8 +696,6 @@ func (s *TFTPServer) handleDATA(data []byte, clientAddr *net.UDPAddr, clientKey
 	blockNum := binary.BigEndian.Uint16(data[2:4])
 	fileData := data[4:]
 	
-	s.logger.Debug("TFTP DATA from %s: block=%d, size=%d", clientAddr, blockNum, len(fileData))
-	
 	// Get transfer state
 	s.transfersMutex.RLock()
 	transfer, exists := s.transfers[clientKey]
@@ -534,13 +705,16 @@ func (s *TFTPServer) handleDATA(data []byte, clientAddr *net.UDPAddr, clientKey
 		s.sendError(clientAddr, ErrUnknownTID, "No active upload")
 		return
 	}
+	s.touchTransfer(transfer)
+	transfer.logger.Debug("TFTP DATA from %s: block=%d, size=%d", clientAddr, blockNum, len(fileData))
 	
 	// Check if this is the expected block
 	if blockNum != transfer.blockNum {
-		s.logger.Debug("Unexpected block number: got %d, expected %d", blockNum, transfer.blockNum)
+		transfer.logger.Debug("Unexpected block number: got %d, expected %d", blockNum, transfer.blockNum)
 		// Send ACK for previous block to trigger retransmission
 		if blockNum == transfer.blockNum-1 {
 			s.sendACK(blockNum, clientAddr)
+			metrics.TFTPRetransmits.Inc()
 		}
 		return
 	}
@@ -548,22 +722,49 @@ func (s *TFTPServer) handleDATA(data []byte, clientAddr *net.UDPAddr, clientKey
 	// Write data to file
 	_, err := transfer.writer.Write(fileData)
 	if err != nil {
-		s.logger.Error("Error writing to file: %v", err)
-		s.sendError(clientAddr, ErrDiskFull, "Write error")
-		s.cleanupTransfer(clientKey)
+		transfer.logger.Error("Error writing to file: %v", err)
+		if errors.Is(err, fs.ErrQuotaExceeded) {
+			s.sendError(clientAddr, ErrDiskFull, "Quota exceeded")
+		} else {
+			s.sendError(clientAddr, ErrDiskFull, "Write error")
+		}
+		s.abortTransfer(clientKey)
 		return
 	}
+	metrics.TransferBytes.Add(float64(len(fileData)), "tftp", transfer.user.Name, metrics.In)
+	s.transfersMutex.Lock()
+	transfer.bytes += int64(len(fileData))
+	transfer.progress.Add(int64(len(fileData)))
+	s.transfersMutex.Unlock()
 	
-	// Send ACK
-	s.sendACK(blockNum, clientAddr)
-	
-	// If this was the last packet (less than 512 bytes), we're done
+	// If this was the last packet (less than 512 bytes), the upload is
+	// only acknowledged once the file is complete, so the client learns
+	// when it could not be stored
 	if len(fileData) < 512 {
-		s.logger.Debug("TFTP file upload completed")
+		s.transfersMutex.Lock()
+		err := transfer.writer.Close()
+		s.transfersMutex.Unlock()
+		if err != nil {
+			transfer.logger.Error("Error completing upload of %s: %v", transfer.filename, err)
+			if errors.Is(err, fs.ErrQuotaExceeded) {
+				s.sendError(clientAddr, ErrDiskFull, "Quota exceeded")
+			} else {
+				s.sendError(clientAddr, ErrNotDefined, "Cannot store file")
+			}
+		} else {
+			transfer.logger.Debug("TFTP file upload completed")
+			s.transfersMutex.Lock()
+			transfer.complete = true
+			s.transfersMutex.Unlock()
+			s.sendACK(blockNum, clientAddr)
+		}
 		s.cleanupTransfer(clientKey)
 		return
 	}
 	
+	// Send ACK
+	s.sendACK(blockNum, clientAddr)
+	
 	// Update expected block number
 	s.transfersMutex.Lock()
 	transfer.blockNum++
@@ -579,8 +780,6 @@ func (s *TFTPServer) handleACK(data []byte, clientAddr *net.UDPAddr, clientKey s
 	
 	blockNum := binary.BigEndian.Uint16(data[2:4])
 	
-	s.logger.Debug("TFTP ACK from %s: block=%d", clientAddr, blockNum)
-	
 	// Get transfer state
 	s.transfersMutex.RLock()
 	transfer, exists := s.transfers[clientKey]
@@ -590,15 +789,113 @@ func (s *TFTPServer) handleACK(data []byte, clientAddr *net.UDPAddr, clientKey s
 		s.sendError(clientAddr, ErrUnknownTID, "No active download")
 		return
 	}
+	s.touchTransfer(transfer)
+	transfer.logger.Debug("TFTP ACK from %s: block=%d", clientAddr, blockNum)
 	
-	// Check if this is the expected ACK
-	if blockNum != transfer.blockNum-1 {
-		s.logger.Debug("Unexpected ACK number: got %d, expected %d", blockNum, transfer.blockNum-1)
-		return
+	// The block last sent, the counter only moves past it for full blocks
+	s.transfersMutex.RLock()
+	sent := transfer.blockNum - 1
+	if transfer.finished {
+		sent = transfer.blockNum
 	}
+	lastPacket := transfer.lastPacket
+	s.transfersMutex.RUnlock()
 	
-	// Send next block
-	s.sendNextBlock(transfer, clientAddr, clientKey)
+	switch {
+	case blockNum == sent && transfer.finished:
+		// The final ACK completes the download
+		transfer.logger.Debug("TFTP file download completed")
+		s.transfersMutex.Lock()
+		transfer.complete = true
+		s.transfersMutex.Unlock()
+		s.cleanupTransfer(clientKey)
+	case blockNum == sent:
+		s.sendNextBlock(transfer, clientAddr, clientKey)
+	case blockNum == sent-1:
+		// The client missed the last block, send it again
+		s.conn.WriteToUDP(lastPacket, clientAddr)
+		metrics.TFTPRetransmits.Inc()
+	default:
+		transfer.logger.Debug("Unexpected ACK number: got %d, expected %d", blockNum, sent)
+	}
+}
+
+// touchTransfer records that the client of a transfer is still there
+func (s *TFTPServer) touchTransfer(transfer *transferState) {
+	s.transfersMutex.Lock()
+	transfer.lastActive = time.Now()
+	s.transfersMutex.Unlock()
+}
+
+// reapTransfers abandons transfers whose client went away without an
+// error packet, so their files are unlocked and partial uploads discarded
+func (s *TFTPServer) reapTransfers() {
+	ticker := time.NewTicker(tftpIdleTimeout / 6)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.transfersMutex.RLock()
+		var idle []string
+		for clientKey, transfer := range s.transfers {
+			if time.Since(transfer.lastActive) > tftpIdleTimeout {
+				idle = append(idle, clientKey)
+			}
+		}
+		s.transfersMutex.RUnlock()
+
+		for _, clientKey := range idle {
+			s.logger.Debug("Abandoning idle TFTP transfer from %s", clientKey)
+			s.abortTransfer(clientKey)
+		}
+	}
+}
+
+// abortTransfer removes a failed transfer, discarding any partial upload
+func (s *TFTPServer) abortTransfer(clientKey string) {
+	s.transfersMutex.Lock()
+	if transfer, exists := s.transfers[clientKey]; exists && transfer.writer != nil {
+		transfer.writer.Abort()
+	}
+	s.transfersMutex.Unlock()
+	s.cleanupTransfer(clientKey)
+}
+
+// startTransfer registers a new transfer, abandoning any earlier one of
+// the same client
+func (s *TFTPServer) startTransfer(clientKey string, transfer *transferState) {
+	s.abortTransfer(clientKey)
+	
+	direction := metrics.Out
+	if transfer.isUpload {
+		direction = metrics.In
+	}
+	transfer.status = s.sessions.Open(transfer.session, "tftp", clientKey, func() { s.kickTransfer(clientKey) }, nil)
+	transfer.status.SetUser(transfer.user.Name)
+	transfer.progress = transfer.status.BeginTransfer(transfer.filename, direction, nil)
+	
+	s.transfersMutex.Lock()
+	s.transfers[clientKey] = transfer
+	s.transfersMutex.Unlock()
+	metrics.ActiveSessions.Inc("tftp")
+}
+
+// kickTransfer ends a transfer from the admin API, or one that outlasted
+// the shutdown, telling the client
+func (s *TFTPServer) kickTransfer(clientKey string) {
+	message := "Transfer cancelled by the administrator"
+	if s.draining.Load() {
+		message = "Server shutting down"
+	}
+	if addr, err := net.ResolveUDPAddr("udp", clientKey); err == nil {
+		s.sendError(addr, ErrNotDefined, message)
+	}
+	s.abortTransfer(clientKey)
 }
 
 // cleanupTransfer removes a transfer state and closes resources
@@ -614,6 +911,27 @@ func (s *TFTPServer) cleanupTransfer(clientKey string) {
 			transfer.reader.Close()
 		}
 		delete(s.transfers, clientKey)
+		s.sessions.Close(transfer.status)
+		
+		direction := metrics.Out
+		if transfer.isUpload {
+			direction = metrics.In
+		}
+		duration := time.Since(transfer.started)
+		metrics.TransferDuration.Observe(duration.Seconds(), "tftp", direction)
+		metrics.ActiveSessions.Dec("tftp")
+		
+		s.audit.Transfer(audit.Transfer{
+			Duration:  duration,
+			Service:   "tftp",
+			Session:   transfer.session,
+			User:      transfer.user.Name,
+			Remote:    transfer.remote,
+			Path:      transfer.filename,
+			Direction: direction,
+			Bytes:     transfer.bytes,
+			Complete:  transfer.complete,
+		})
 	}
 }
 
@@ -622,7 +940,7 @@ func (s *TFTPServer) sendNextBlock(transfer *transferState, clientAddr *net.UDPA
 	buffer := make([]byte, 512)
 	n, err := transfer.reader.Read(buffer)
 	if err != nil && err != io.EOF {
-		s.logger.Error("Error reading file: %v", err)
+		transfer.logger.Error("Error reading file: %v", err)
 		s.sendError(clientAddr, ErrNotDefined, "Read error")
 		s.cleanupTransfer(clientKey)
 		return
@@ -635,10 +953,20 @@ func (s *TFTPServer) sendNextBlock(transfer *transferState, clientAddr *net.UDPA
 	copy(dataPacket[4:], buffer[:n])
 	
 	s.conn.WriteToUDP(dataPacket, clientAddr)
+	metrics.TransferBytes.Add(float64(n), "tftp", transfer.user.Name, metrics.Out)
+	
+	s.transfersMutex.Lock()
+	transfer.bytes += int64(n)
+	transfer.progress.Add(int64(n))
+	transfer.lastPacket = dataPacket
+	s.transfersMutex.Unlock()
 	
 	// If this was the last block (less than 512 bytes), cleanup after ACK
 	if n < 512 {
 		// We'll cleanup when we receive the final ACK
+		s.transfersMutex.Lock()
+		transfer.finished = true
+		s.transfersMutex.Unlock()
 		return
 	}
 	
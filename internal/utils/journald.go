@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultJournaldSocket is the socket of journald's native protocol
+const defaultJournaldSocket = "/run/systemd/journal/socket"
+
+// journaldWriter sends records to journald with every field as a journal
+// field, so they can be queried with journalctl SESSION=... and alike
+type journaldWriter struct {
+	conn *net.UnixConn
+	tag  string
+}
+
+// newJournaldWriter connects to journald
+func newJournaldWriter(o LogOutput) (*journaldWriter, error) {
+	address := o.Address
+	if address == "" {
+		address = defaultJournaldSocket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: address, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+
+	tag := o.Tag
+	if tag == "" {
+		tag = defaultLogTag
+	}
+	return &journaldWriter{conn: conn, tag: tag}, nil
+}
+
+// emit sends a record as one datagram of journal fields
+func (w *journaldWriter) emit(r slog.Record, fields []field) error {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", r.Message)
+	appendJournalField(&b, "PRIORITY", strconv.Itoa(syslogSeverity(r.Level)))
+	appendJournalField(&b, "SYSLOG_IDENTIFIER", w.tag)
+	for _, f := range fields {
+		if name := journalFieldName(f.key); name != "" {
+			appendJournalField(&b, name, f.String())
+		}
+	}
+
+	_, err := w.conn.Write(b.Bytes())
+	return err
+}
+
+// appendJournalField encodes a field, values with newlines in the binary
+// form with an explicit length
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteString("=")
+		b.WriteString(value)
+		b.WriteString("\n")
+		return
+	}
+	b.WriteString("\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteString("\n")
+}
+
+// journalFieldName turns a key into a journal field name: upper case
+// letters, digits and underscores, not starting with an underscore, which
+// journald reserves for trusted fields
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// Close closes the connection to journald
+func (w *journaldWriter) Close() error {
+	return w.conn.Close()
+}
@@ -1,45 +1,77 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"log/slog"
+	"strconv"
 	"strings"
-	"time"
+	"sync/atomic"
 )
 
 // LogLevel represents different log levels
-type LogLevel int
+type LogLevel = slog.Level
 
 const (
-	DEBUG LogLevel = iota
-	INFO
-	WARN
-	ERROR
+	DEBUG = slog.LevelDebug
+	INFO  = slog.LevelInfo
+	WARN  = slog.LevelWarn
+	ERROR = slog.LevelError
 )
 
-// Logger provides simple logging functionality
+// Logger writes leveled messages with key/value fields through log/slog.
+// Loggers derived with With carry their fields on every line, loggers
+// derived with Subsystem have their own level.
 type Logger struct {
-	level  LogLevel
-	format string
-	logger *log.Logger
+	level   LogLevel
+	levels  map[string]LogLevel // per subsystem, shared by derived loggers
+	logger  *slog.Logger
+	closers []io.Closer // outputs to close, shared by derived loggers
 }
 
-// NewLogger creates a new logger with the specified level and format
-func NewLogger(level, format string) *Logger {
-	logLevel := parseLogLevel(level)
+// NewLogger creates a new logger with the specified level, levels of
+// individual subsystems and outputs. Every output receives what the logger
+// emits at or above its own level. Without outputs it writes text to stdout.
+func NewLogger(level string, subsystems map[string]string, outputs []LogOutput) (*Logger, error) {
+	if len(outputs) == 0 {
+		outputs = []LogOutput{{Type: OutputStdout}}
+	}
+
+	l := &Logger{
+		level:  ParseLogLevel(level),
+		levels: make(map[string]LogLevel),
+	}
+	for name, level := range subsystems {
+		l.levels[name] = ParseLogLevel(level)
+	}
 
-	logger := &Logger{
-		level:  logLevel,
-		format: format,
-		logger: log.New(os.Stdout, "", 0),
+	var handlers []slog.Handler
+	for _, output := range outputs {
+		handler, closer, err := newOutputHandler(output)
+		if err != nil {
+			l.Close()
+			return nil, fmt.Errorf("failed to open %s log output: %w", output.Type, err)
+		}
+		if closer != nil {
+			l.closers = append(l.closers, closer)
+		}
+		if output.Level != "" {
+			handler = &leveledHandler{Handler: handler, level: ParseLogLevel(output.Level)}
+		}
+		handlers = append(handlers, handler)
 	}
 
-	return logger
+	if len(handlers) == 1 {
+		l.logger = slog.New(handlers[0])
+	} else {
+		l.logger = slog.New(multiHandler(handlers))
+	}
+	return l, nil
 }
 
-// parseLogLevel converts a string log level to LogLevel enum
-func parseLogLevel(level string) LogLevel {
+// ParseLogLevel converts a string log level to a LogLevel, defaulting to INFO
+func ParseLogLevel(level string) LogLevel {
 	switch strings.ToLower(level) {
 	case "debug":
 		return DEBUG
@@ -54,47 +86,72 @@ func parseLogLevel(level string) LogLevel {
 	}
 }
 
+// With returns a logger adding the given key/value pairs to every line
+func (l *Logger) With(args ...any) *Logger {
+	derived := *l
+	derived.logger = l.logger.With(args...)
+	return &derived
+}
+
+// Subsystem returns a logger for a part of the server, logging at the
+// subsystem's own level if one is configured
+func (l *Logger) Subsystem(name string) *Logger {
+	derived := l.With("subsystem", name)
+	if level, ok := l.levels[name]; ok {
+		derived.level = level
+	}
+	return derived
+}
+
+// Enabled returns true if messages of the level are logged
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(format string, args ...interface{}) {
-	if l.level <= DEBUG {
-		l.log("DEBUG", format, args...)
-	}
+	l.log(DEBUG, format, args...)
 }
 
 // Info logs an info message
 func (l *Logger) Info(format string, args ...interface{}) {
-	if l.level <= INFO {
-		l.log("INFO", format, args...)
-	}
+	l.log(INFO, format, args...)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(format string, args ...interface{}) {
-	if l.level <= WARN {
-		l.log("WARN", format, args...)
-	}
+	l.log(WARN, format, args...)
 }
 
 // Error logs an error message
 func (l *Logger) Error(format string, args ...interface{}) {
-	if l.level <= ERROR {
-		l.log("ERROR", format, args...)
-	}
+	l.log(ERROR, format, args...)
 }
 
-// log formats and prints a log message
-func (l *Logger) log(level, format string, args ...interface{}) {
-	message := fmt.Sprintf(format, args...)
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
+// log formats and writes a message if its level is enabled
+func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.logger.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
 
-	var output string
-	if l.format == "json" {
-		// Simple JSON-like format
-		output = fmt.Sprintf(`{"time":"%s","level":"%s","message":"%s"}`, timestamp, level, message)
-	} else {
-		// Simple text format
-		output = fmt.Sprintf("[%s] %s: %s", timestamp, level, message)
+// Close closes the files and sockets of the outputs
+func (l *Logger) Close() error {
+	var err error
+	for _, closer := range l.closers {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
 	}
+	return err
+}
+
+// sessionCounter numbers sessions for NewSessionID
+var sessionCounter atomic.Uint64
 
-	l.logger.Println(output)
+// NewSessionID returns an identifier for a connection or transfer, unique
+// within the process, to tie its log lines together
+func NewSessionID() string {
+	return strconv.FormatUint(sessionCounter.Add(1), 36)
 }
@@ -0,0 +1,270 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Types of log outputs
+const (
+	OutputStdout   = "stdout"
+	OutputFile     = "file"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+)
+
+// LogOutput is a destination of log lines
+type LogOutput struct {
+	Type   string // one of the Output types
+	Level  string // least severe level written, empty for all the logger emits
+	Format string // text or json, for stdout and file
+
+	// Rotation of file outputs, limits of zero disable it
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	// Syslog and journald outputs
+	Network  string // syslog transport: unixgram (default), udp or tcp
+	Address  string // socket path or host:port, defaults to the local daemon
+	Facility string // syslog facility, defaults to daemon
+	Tag      string // application name, defaults to ftp-aio
+}
+
+// newOutputHandler opens an output and returns its handler, and what to
+// close when the logger is done
+func newOutputHandler(o LogOutput) (slog.Handler, io.Closer, error) {
+	switch o.Type {
+	case OutputStdout, "":
+		return newFormatHandler(os.Stdout, o.Format), nil, nil
+	case OutputFile:
+		file, err := OpenRotatingFile(o.Path, o.MaxSize, o.MaxAge, o.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		return newFormatHandler(file, o.Format), file, nil
+	case OutputSyslog:
+		w, err := newSyslogWriter(o)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &fieldHandler{emitter: w}, w, nil
+	case OutputJournald:
+		w, err := newJournaldWriter(o)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &fieldHandler{emitter: w}, w, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown log output type '%s'", o.Type)
+	}
+}
+
+// newFormatHandler writes lines as JSON objects or text
+func newFormatHandler(w io.Writer, format string) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.MessageKey {
+					a.Key = "message"
+				}
+				return a
+			},
+		})
+	}
+	return &fieldHandler{emitter: &textEmitter{out: &lockedWriter{w: w}}}
+}
+
+// leveledHandler drops records below its level
+type leveledHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h *leveledHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h *leveledHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < h.level {
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// multiHandler hands every record to several outputs. A failing output
+// doesn't keep the record from the others.
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range m {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if handleErr := h.Handle(ctx, r.Clone()); err == nil {
+			err = handleErr
+		}
+	}
+	return err
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := make(multiHandler, len(m))
+	for i, h := range m {
+		derived[i] = h.WithAttrs(attrs)
+	}
+	return derived
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	derived := make(multiHandler, len(m))
+	for i, h := range m {
+		derived[i] = h.WithGroup(name)
+	}
+	return derived
+}
+
+// field is an attribute flattened to a dotted key
+type field struct {
+	key   string
+	value slog.Value
+}
+
+// String formats the value of a field
+func (f field) String() string {
+	if f.value.Kind() == slog.KindTime {
+		return f.value.Time().Format(time.RFC3339)
+	}
+	return f.value.String()
+}
+
+// emitter writes a record with its flattened fields to an output
+type emitter interface {
+	emit(r slog.Record, fields []field) error
+}
+
+// fieldHandler flattens attributes and groups into fields for an emitter
+type fieldHandler struct {
+	emitter emitter
+	fields  []field
+	group   string
+}
+
+func (h *fieldHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return true
+}
+
+func (h *fieldHandler) Handle(ctx context.Context, r slog.Record) error {
+	fields := append([]field(nil), h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendFields(fields, h.group, a)
+		return true
+	})
+	return h.emitter.emit(r, fields)
+}
+
+func (h *fieldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	derived := *h
+	derived.fields = append([]field(nil), h.fields...)
+	for _, a := range attrs {
+		derived.fields = appendFields(derived.fields, h.group, a)
+	}
+	return &derived
+}
+
+func (h *fieldHandler) WithGroup(name string) slog.Handler {
+	derived := *h
+	if h.group != "" {
+		name = h.group + "." + name
+	}
+	derived.group = name
+	return &derived
+}
+
+// appendFields flattens an attribute, prefixing keys with their group
+func appendFields(fields []field, group string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	key := a.Key
+	if group != "" {
+		key = group + "." + key
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		for _, child := range a.Value.Group() {
+			fields = appendFields(fields, key, child)
+		}
+		return fields
+	}
+	return append(fields, field{key: key, value: a.Value})
+}
+
+// lockedWriter serializes writes of whole lines
+type lockedWriter struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.w.Write(p)
+}
+
+// textEmitter writes lines as "[time] LEVEL: message key=value ..."
+type textEmitter struct {
+	out io.Writer
+}
+
+func (e *textEmitter) emit(r slog.Record, fields []field) error {
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(r.Time.Format("2006-01-02 15:04:05"))
+	b.WriteString("] ")
+	b.WriteString(r.Level.String())
+	b.WriteString(": ")
+	b.WriteString(r.Message)
+
+	for _, f := range fields {
+		value := f.String()
+		if value == "" || strings.ContainsAny(value, " =\"\\\t\r\n") {
+			value = strconv.Quote(value)
+		}
+		b.WriteString(" ")
+		b.WriteString(f.key)
+		b.WriteString("=")
+		b.WriteString(value)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(e.out, b.String())
+	return err
+}
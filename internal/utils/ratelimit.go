@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimitedReader throttles reads to a fixed number of bytes per second
+type rateLimitedReader struct {
+	reader io.Reader
+	rate   int64
+	start  time.Time
+	total  int64
+}
+
+// NewRateLimitedReader wraps reader so that it delivers at most rate bytes
+// per second. A rate of zero or less returns reader unchanged.
+func NewRateLimitedReader(reader io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return reader
+	}
+	return &rateLimitedReader{reader: reader, rate: rate, start: time.Now()}
+}
+
+// Read reads at most one second worth of data and sleeps until the average
+// rate drops back to the limit
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.rate {
+		p = p[:r.rate]
+	}
+
+	n, err := r.reader.Read(p)
+	r.total += int64(n)
+
+	expected := time.Duration(float64(r.total) / float64(r.rate) * float64(time.Second))
+	if wait := expected - time.Since(r.start); wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return n, err
+}
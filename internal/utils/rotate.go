@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotateTimeFormat names rotated files, sorting by name sorts them by age
+const rotateTimeFormat = "20060102-150405"
+
+// RotatingFile appends lines to a file, moving it aside once it grows past
+// its size limit or gets older than its age limit
+type RotatingFile struct {
+	path       string
+	maxSize    int64         // bytes, 0 = no limit
+	maxAge     time.Duration // 0 = no limit
+	maxBackups int           // rotated files kept, 0 = all
+	mutex      sync.Mutex
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+// OpenRotatingFile opens a log file for appending, creating its directory.
+// Limits of zero disable rotation by size or age and pruning of old files.
+func OpenRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f := &RotatingFile{path: path, maxSize: maxSize, maxAge: maxAge, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the log file, an existing file counts as opened when it was
+// last modified
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	if f.size > 0 {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends a line, rotating the file first if it is due
+func (f *RotatingFile) Write(line []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.due(int64(len(line))) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return n, err
+}
+
+// due returns true if writing n more bytes calls for a new file
+func (f *RotatingFile) due(n int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+n > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && time.Since(f.opened) >= f.maxAge
+}
+
+// rotate moves the current file aside as <path>.<time>, starts a new one
+// and removes the oldest rotated files beyond the backup limit
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	f.file = nil
+
+	rotated := f.path + "." + time.Now().Format(rotateTimeFormat)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%s-%d", f.path, time.Now().Format(rotateTimeFormat), i)
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		backups, err := filepath.Glob(f.path + ".*")
+		if err != nil {
+			return nil
+		}
+		backups = filterRotated(f.path, backups)
+		sort.Strings(backups)
+		for len(backups) > f.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// filterRotated keeps the names that look like rotated copies of path
+func filterRotated(path string, names []string) []string {
+	var rotated []string
+	for _, name := range names {
+		suffix := strings.TrimPrefix(name, path+".")
+		if len(suffix) >= len(rotateTimeFormat) {
+			if _, err := time.Parse(rotateTimeFormat, suffix[:len(rotateTimeFormat)]); err == nil {
+				rotated = append(rotated, name)
+			}
+		}
+	}
+	return rotated
+}
+
+// Close closes the log file
+func (f *RotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
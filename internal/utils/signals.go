@@ -8,24 +8,34 @@ import (
 	"time"
 )
 
-// GracefulShutdown handles graceful shutdown of the application
-func GracefulShutdown(ctx context.Context, cancel context.CancelFunc, logger *Logger, shutdownFn func() error) {
+// shutdownGrace is how long the servers get to close the remaining
+// sessions once the drain timeout is over, or a second signal arrived
+const shutdownGrace = 5 * time.Second
+
+// GracefulShutdown handles graceful shutdown of the application. On the
+// first signal shutdownFn is called with a context that is done after
+// drainTimeout, or at once on a second signal, when shutdownFn should
+// close the sessions left. The application context is cancelled once
+// shutdownFn returns.
+func GracefulShutdown(ctx context.Context, cancel context.CancelFunc, logger *Logger, drainTimeout time.Duration, shutdownFn func(ctx context.Context) error) {
 	// Create a channel to receive OS signals
 	sigChan := make(chan os.Signal, 1)
 
 	// Register the channel to receive specific signals
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	defer signal.Stop(sigChan)
 
 	// Wait for signal
 	sig := <-sigChan
-	logger.Info("Received signal %s, initiating graceful shutdown...", sig)
+	logger.Info("Received signal %s, initiating graceful shutdown (send it again to force)...", sig)
 
-	// Cancel the context to signal all goroutines to stop
-	cancel()
+	// Cancel the context to signal all goroutines to stop, once the
+	// servers are done with it
+	defer cancel()
 
-	// Create a timeout context for shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer shutdownCancel()
+	// Create a timeout context for draining the sessions
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer drainCancel()
 
 	// Channel to signal shutdown completion
 	done := make(chan error, 1)
@@ -33,21 +43,55 @@ func GracefulShutdown(ctx context.Context, cancel context.CancelFunc, logger *Lo
 	// Run shutdown function in a goroutine
 	go func() {
 		if shutdownFn != nil {
-			done <- shutdownFn()
+			done <- shutdownFn(drainCtx)
 		} else {
 			done <- nil
 		}
 	}()
 
-	// Wait for shutdown to complete or timeout
-	select {
-	case err := <-done:
+	report := func(err error) {
 		if err != nil {
 			logger.Error("Error during shutdown: %v", err)
 		} else {
 			logger.Info("Graceful shutdown completed")
 		}
-	case <-shutdownCtx.Done():
+	}
+
+	// Wait for shutdown to complete, the drain timeout or a second signal
+	select {
+	case err := <-done:
+		report(err)
+		return
+	case <-drainCtx.Done():
+		logger.Warn("Drain timeout exceeded, closing remaining sessions")
+	case sig := <-sigChan:
+		logger.Warn("Received signal %s again, closing remaining sessions", sig)
+		drainCancel()
+	}
+
+	select {
+	case err := <-done:
+		report(err)
+	case <-sigChan:
+		logger.Warn("Forcing exit")
+	case <-time.After(shutdownGrace):
 		logger.Warn("Shutdown timeout exceeded, forcing exit")
 	}
 }
+
+// HandleReload calls reloadFn on every SIGHUP until ctx is done
+func HandleReload(ctx context.Context, logger *Logger, reloadFn func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sigChan:
+			logger.Info("Received SIGHUP, reloading configuration...")
+			reloadFn()
+		}
+	}
+}
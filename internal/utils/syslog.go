@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSyslogAddress is the socket of the local syslog daemon
+const defaultSyslogAddress = "/dev/log"
+
+// defaultLogTag names the application in syslog and journald
+const defaultLogTag = "ftp-aio"
+
+// syslogStructuredID is the SD-ID of the fields in syslog messages, under
+// the enterprise number reserved for examples in RFC 5612
+const syslogStructuredID = "ftpaio@32473"
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity maps a level to a syslog severity
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level < INFO:
+		return 7 // debug
+	case level < WARN:
+		return 6 // informational
+	case level < ERROR:
+		return 4 // warning
+	default:
+		return 3 // error
+	}
+}
+
+// syslogWriter sends RFC 5424 messages to a syslog daemon, reconnecting
+// when the connection breaks
+type syslogWriter struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	pid      int
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+// newSyslogWriter connects to the syslog daemon of an output
+func newSyslogWriter(o LogOutput) (*syslogWriter, error) {
+	w := &syslogWriter{
+		network:  o.Network,
+		address:  o.Address,
+		facility: syslogFacilities["daemon"],
+		tag:      o.Tag,
+		pid:      os.Getpid(),
+	}
+	if w.network == "" {
+		w.network = "unixgram"
+	}
+	switch w.network {
+	case "unixgram", "unix", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("unknown syslog network '%s', must be one of: unixgram, unix, udp, tcp", w.network)
+	}
+	if w.address == "" {
+		if w.network != "unixgram" && w.network != "unix" {
+			return nil, fmt.Errorf("syslog over %s needs an address", w.network)
+		}
+		w.address = defaultSyslogAddress
+	}
+	if o.Facility != "" {
+		facility, ok := syslogFacilities[strings.ToLower(o.Facility)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility '%s'", o.Facility)
+		}
+		w.facility = facility
+	}
+	if w.tag == "" {
+		w.tag = defaultLogTag
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		w.hostname = hostname
+	}
+
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect dials the syslog daemon
+func (w *syslogWriter) connect() error {
+	conn, err := net.DialTimeout(w.network, w.address, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// emit sends a record, trying a new connection once if sending fails
+func (w *syslogWriter) emit(r slog.Record, fields []field) error {
+	message := w.format(r, fields)
+	if w.network == "tcp" || w.network == "unix" {
+		// Octet counting framing of RFC 6587 for stream transports
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn != nil {
+		if _, err := w.conn.Write([]byte(message)); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	_, err := w.conn.Write([]byte(message))
+	return err
+}
+
+// format builds an RFC 5424 message. The subsystem becomes the MSGID and
+// the other fields structured data.
+func (w *syslogWriter) format(r slog.Record, fields []field) string {
+	msgID := "-"
+	var data strings.Builder
+	for _, f := range fields {
+		if f.key == "subsystem" {
+			msgID = syslogName(f.String(), 32)
+			continue
+		}
+		data.WriteString(" ")
+		data.WriteString(syslogName(f.key, 32))
+		data.WriteString(`="`)
+		data.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(f.String()))
+		data.WriteString(`"`)
+	}
+	structured := "-"
+	if data.Len() > 0 {
+		structured = "[" + syslogStructuredID + data.String() + "]"
+	}
+
+	hostname := w.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		w.facility*8+syslogSeverity(r.Level),
+		r.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogName(hostname, 255), syslogName(w.tag, 48), w.pid, msgID, structured, r.Message)
+}
+
+// syslogName limits a header field or parameter name to the printable
+// characters syslog allows
+func syslogName(name string, max int) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > max {
+		name = name[:max]
+	}
+	return name
+}
+
+// Close closes the connection to the daemon
+func (w *syslogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package utils
+
+import "os"
+
+// ListenFDs returns nil, socket activation needs systemd
+func ListenFDs() []*os.File {
+	return nil
+}
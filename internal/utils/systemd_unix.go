@@ -0,0 +1,27 @@
+//go:build unix
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// ListenFDs returns the sockets passed by systemd socket activation, named
+// after FileDescriptorName= of the socket unit, nil when the process was
+// not activated. The environment is cleared so that child processes do
+// not take the sockets for theirs.
+func ListenFDs() []*os.File {
+	count, names := activatedFDs()
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	files := make([]*os.File, 0, count)
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+		files = append(files, os.NewFile(uintptr(fd), names[i]))
+	}
+	return files
+}
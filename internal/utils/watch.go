@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WatchFile calls changedFn when the modification time or size of a file
+// changes, checking every interval until ctx is done. A file that cannot
+// be read is reported again once it is back.
+func WatchFile(ctx context.Context, path string, interval time.Duration, changedFn func()) {
+	last, lastErr := os.Stat(path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			last, lastErr = nil, err
+			continue
+		}
+		if lastErr == nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last, lastErr = info, nil
+		changedFn()
+	}
+}